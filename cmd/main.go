@@ -1,18 +1,108 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/api"
+	"github.com/farhansabbir/rbac/lib/audit"
 	"github.com/farhansabbir/rbac/lib/controllers"
+	"github.com/farhansabbir/rbac/lib/metrics"
+	"github.com/farhansabbir/rbac/lib/middleware"
 )
 
 func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "address the admin API listens on")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on shutdown")
+	auditLog := flag.String("audit-log", "", "append every authorization decision to this JSON-lines file")
+	auditMaxSize := flag.Int64("audit-max-size", 100<<20, "rotate the audit log at this many bytes (0 disables)")
+	auditRotateEvery := flag.Duration("audit-rotate-every", 24*time.Hour, "rotate the audit log this often (0 disables)")
+	auditCompress := flag.Bool("audit-compress", true, "gzip rotated audit logs")
+	cacheTTL := flag.Duration("decision-cache-ttl", 0, "how long to reuse a decision while the policy is unchanged (0 disables)")
+	cacheSize := flag.Int("decision-cache-size", 10000, "most decisions kept in the cache")
+	flag.Parse()
+
 	ctrl := controllers.GetController()
+	reg := metrics.NewRegistry()
+	metrics.RegisterController(reg, ctrl)
+	gkOpts := []lib.GatekeeperOption{
+		lib.WithStore(ctrl),
+		lib.WithDecisionCache(*cacheTTL, *cacheSize),
+		lib.WithObserver(metrics.NewGatekeeperMetrics(reg)),
+	}
+
+	var auditSink *audit.AsyncSink
+	if *auditLog != "" {
+		fileOpts := []audit.FileOption{audit.WithMaxSize(*auditMaxSize), audit.WithRotateEvery(*auditRotateEvery)}
+		if *auditCompress {
+			fileOpts = append(fileOpts, audit.WithCompression())
+		}
+		file, err := audit.NewFileSink(*auditLog, fileOpts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "audit log: %v\n", err)
+			os.Exit(1)
+		}
+		auditSink = audit.NewAsyncSink(file)
+		gkOpts = append(gkOpts, lib.WithAuditSink(auditSink))
+	}
+	gk := lib.NewGatekeeper(gkOpts...)
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", reg)
+	// Callers act as the service account whose API key they present. Changes
+	// to users, profiles and rules must be allowed by the Gatekeeper; grants
+	// and access requests check the caller themselves.
+	principal := middleware.APIKeyPrincipal(ctrl)
+	authz, err := middleware.New(gk, principal, api.Routes(), middleware.WithUnmatchedAllowed())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin API: %v\n", err)
+		os.Exit(1)
+	}
+	mux.Handle("/", authz.Wrap(api.NewServer(ctrl, gk, api.WithPrincipal(principal))))
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("Admin API listening on %s\n", *addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
-	<-sigchan
+
+	exitCode := 0
+	select {
+	case <-sigchan:
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "admin API failed: %v\n", err)
+			exitCode = 1
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "admin API shutdown: %v\n", err)
+		exitCode = 1
+	}
+	cancel()
 	ctrl.Stop()
-	os.Exit(0)
+	if auditSink != nil {
+		if err := auditSink.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "audit log: %v\n", err)
+			exitCode = 1
+		}
+	}
+	os.Exit(exitCode)
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/api"
+	"github.com/farhansabbir/rbac/lib/controllers"
+	"github.com/farhansabbir/rbac/lib/policy"
+)
+
+// query is one "can principal do verb on resource" question
+type query struct {
+	PrincipalID  uint64
+	ResourceType core.ResourceType
+	ResourceID   uint64
+	Verb         core.Verb
+}
+
+// decision is the part of a Gatekeeper decision rbacctl reports. A decision
+// the Gatekeeper reached through an error (e.g. a user without profiles) is
+// a deny whose Reason carries the error.
+type decision struct {
+	Allowed   bool
+	Effect    string
+	RuleID    uint64
+	ProfileID uint64
+	Reason    string
+}
+
+// backend is where policy lives: a local file or a running admin API
+type backend interface {
+	Document() (*policy.Document, error)
+	Decide(q query) (decision, error)
+	Import(doc *policy.Document) error
+}
+
+// localBackend evaluates a policy file with an in-process controller
+type localBackend struct {
+	path string
+	ctrl *controllers.Controller
+	gk   *lib.Gatekeeper
+}
+
+func newLocalBackend(path string) (*localBackend, error) {
+	doc := &policy.Document{}
+	if _, err := os.Stat(path); err == nil {
+		if doc, err = policy.ReadFile(path); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	ctrl, err := policy.NewController(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &localBackend{path: path, ctrl: ctrl, gk: lib.NewGatekeeper(lib.WithStore(ctrl))}, nil
+}
+
+func (b *localBackend) Document() (*policy.Document, error) {
+	return policy.Export(b.ctrl), nil
+}
+
+func (b *localBackend) Decide(q query) (decision, error) {
+	d := b.gk.Decide(&lib.RequestContext{
+		PrincipalID:         q.PrincipalID,
+		RequestResourceType: q.ResourceType,
+		RequestResourceID:   q.ResourceID,
+		RequestVerb:         q.Verb,
+		ContextDT:           time.Now(),
+	})
+	return decision{Allowed: d.Allowed, Effect: d.Effect.String(), RuleID: d.RuleID, ProfileID: d.ProfileID, Reason: d.Reason}, nil
+}
+
+// Import merges doc into the policy file, writing it only if every entity
+// loads cleanly.
+func (b *localBackend) Import(doc *policy.Document) error {
+	if err := policy.Load(b.ctrl, doc); err != nil {
+		return err
+	}
+	return policy.WriteFile(b.path, policy.Export(b.ctrl))
+}
+
+// remoteBackend talks to the admin API
+type remoteBackend struct {
+	client *api.Client
+}
+
+func (b *remoteBackend) Document() (*policy.Document, error) {
+	return b.client.Export()
+}
+
+func (b *remoteBackend) Decide(q query) (decision, error) {
+	d, err := b.client.Authorize(api.AuthorizeRequest{
+		PrincipalID:  q.PrincipalID,
+		ResourceType: q.ResourceType.String(),
+		ResourceID:   q.ResourceID,
+		Verb:         core.FormatVerb(q.Verb),
+	})
+	if err != nil {
+		return decision{}, err
+	}
+	return decision{Allowed: d.Allowed, Effect: d.Effect, RuleID: d.RuleID, ProfileID: d.ProfileID, Reason: d.Reason}, nil
+}
+
+func (b *remoteBackend) Import(doc *policy.Document) error {
+	return b.client.Import(doc)
+}
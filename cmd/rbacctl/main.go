@@ -0,0 +1,507 @@
+// Command rbacctl answers authorization questions and moves policy around,
+// either against a local policy file or a running admin API.
+//
+//	rbacctl -policy policy.json check alice update project 42
+//	rbacctl -server http://127.0.0.1:8080 who-can delete rule 7
+//	rbacctl -server http://127.0.0.1:8080 export -o policy.json
+//
+// check exits 0 when the request is allowed, 1 when it is denied and 2 on
+// any error; test exits 1 when a case fails; lint exits 1 when a finding is
+// an error; diff exits 1 when anyone's access changes; every other
+// subcommand exits 0 or 2.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib/api"
+	"github.com/farhansabbir/rbac/lib/k8s"
+	"github.com/farhansabbir/rbac/lib/policy"
+)
+
+const (
+	exitAllowed = 0
+	exitDenied  = 1
+	exitError   = 2
+)
+
+const usage = `usage: rbacctl (-policy FILE | -server URL) COMMAND [ARGS]
+
+commands:
+  check USER VERB TYPE [ID]     exit 0 if allowed, 1 if denied
+  explain USER VERB TYPE [ID]   show the deciding rule and profile
+  who-can VERB TYPE [ID]        list the users allowed to act
+  diff [-json] FILE             show whose access FILE would add or remove,
+                                and which rule changes cause it; exit 1 if
+                                any
+  test [-v] FILE...             run policy test files, exit 1 on failures
+  lint [-json] [-severity S]    report shadowed, duplicate and broken rules
+                                of at least severity S (info, warning,
+                                error), exit 1 on errors
+  list users|profiles|rules     print entities as a table
+  review [-csv]                 write every user's effective permissions,
+                                with the profile and rule behind each and
+                                wildcard or admin-equivalent users flagged
+  import [-k8s [-widen-namespaces]] -f FILE
+                                add the entities of a policy file, or with
+                                -k8s of Kubernetes RBAC manifests (JSON);
+                                namespaced Roles and RoleBindings are
+                                skipped unless -widen-namespaces imports
+                                them for every namespace
+  export [-o FILE] [-dsl]       write the whole policy
+
+Policy files ending in .rbac use the policy language, others JSON.
+
+USER is a numeric ID, a user name or an email address. VERB uses rule
+syntax, e.g. "read" or "read|list". ID defaults to 0 (collections).
+`
+
+// errDenied makes check and test exit with exitDenied without printing an
+// error
+var errDenied = errors.New("denied")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("rbacctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	policyFile := flags.String("policy", "", "policy file to evaluate locally")
+	server := flags.String("server", "", "admin API base URL")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if flags.NArg() == 0 || (*policyFile == "") == (*server == "") {
+		flags.Usage()
+		return exitError
+	}
+
+	var b backend
+	if *server != "" {
+		b = &remoteBackend{client: api.NewClient(*server)}
+	} else {
+		local, err := newLocalBackend(*policyFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "rbacctl: %v\n", err)
+			return exitError
+		}
+		b = local
+	}
+
+	cmd, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	var err error
+	switch cmd {
+	case "check":
+		err = runCheck(b, cmdArgs, stdout, false)
+	case "explain":
+		err = runCheck(b, cmdArgs, stdout, true)
+	case "test":
+		err = runTest(b, cmdArgs, stdout, stderr)
+	case "lint":
+		err = runLint(b, cmdArgs, stdout, stderr)
+	case "who-can":
+		err = runWhoCan(b, cmdArgs, stdout)
+	case "diff":
+		err = runDiff(b, cmdArgs, stdout, stderr)
+	case "review":
+		err = runReview(b, cmdArgs, stdout, stderr)
+	case "list":
+		err = runList(b, cmdArgs, stdout)
+	case "import":
+		err = runImport(b, cmdArgs, stderr)
+	case "export":
+		err = runExport(b, cmdArgs, stdout, stderr)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+
+	switch {
+	case err == nil:
+		return exitAllowed
+	case errors.Is(err, errDenied):
+		return exitDenied
+	default:
+		fmt.Fprintf(stderr, "rbacctl %s: %v\n", cmd, err)
+		return exitError
+	}
+}
+
+func runCheck(b backend, args []string, stdout io.Writer, explain bool) error {
+	if len(args) < 3 || len(args) > 4 {
+		return errors.New("want USER VERB TYPE [ID]")
+	}
+	doc, err := b.Document()
+	if err != nil {
+		return err
+	}
+	user, err := doc.FindUser(args[0])
+	if err != nil {
+		return err
+	}
+	q, err := parseQuery(args[1:])
+	if err != nil {
+		return err
+	}
+	q.PrincipalID = user.ID
+
+	d, err := b.Decide(q)
+	if err != nil {
+		return err
+	}
+	if !explain {
+		fmt.Fprintln(stdout, d.Effect)
+	} else {
+		fmt.Fprintf(stdout, "user:     %s (%d)\n", user.Name, user.ID)
+		fmt.Fprintf(stdout, "request:  %s %s:%d\n", core.FormatVerb(q.Verb), q.ResourceType, q.ResourceID)
+		fmt.Fprintf(stdout, "effect:   %s\n", d.Effect)
+		fmt.Fprintf(stdout, "reason:   %s\n", d.Reason)
+		if d.RuleID != 0 {
+			fmt.Fprintf(stdout, "rule:     %s\n", ruleLabel(doc, d.RuleID))
+		}
+		if d.ProfileID != 0 {
+			fmt.Fprintf(stdout, "profile:  %s\n", profileLabel(doc, d.ProfileID))
+		}
+	}
+	if !d.Allowed {
+		return errDenied
+	}
+	return nil
+}
+
+func runTest(b backend, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	verbose := flags.Bool("v", false, "print passing cases too")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("want at least one test file")
+	}
+	doc, err := b.Document()
+	if err != nil {
+		return err
+	}
+
+	total, failed := 0, 0
+	for _, path := range flags.Args() {
+		suite, err := policy.ReadSuiteFile(path)
+		if err != nil {
+			return err
+		}
+		result, err := policy.RunSuite(doc, suite)
+		if err != nil {
+			return err
+		}
+		for _, r := range result.Results {
+			if *verbose || !r.Passed {
+				fmt.Fprintf(stdout, "%s: %s\n", path, r)
+			}
+		}
+		total += len(result.Results)
+		failed += result.Failed
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(stdout, "FAIL: %d of %d cases failed\n", failed, total)
+		return errDenied
+	}
+	fmt.Fprintf(stdout, "ok: %d cases passed\n", total)
+	return nil
+}
+
+func runLint(b backend, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "write findings as JSON")
+	level := flags.String("severity", "info", "leave out findings below this severity")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	threshold, err := policy.ParseSeverity(*level)
+	if err != nil {
+		return err
+	}
+	doc, err := b.Document()
+	if err != nil {
+		return err
+	}
+
+	findings := []policy.Finding{}
+	failed := false
+	for _, f := range policy.Lint(doc) {
+		if f.Severity >= threshold {
+			findings = append(findings, f)
+		}
+		failed = failed || f.Severity == policy.SeverityError
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Fprintln(stdout, f)
+		}
+	}
+	if failed {
+		return errDenied
+	}
+	return nil
+}
+
+func runWhoCan(b backend, args []string, stdout io.Writer) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("want VERB TYPE [ID]")
+	}
+	q, err := parseQuery(args)
+	if err != nil {
+		return err
+	}
+	doc, err := b.Document()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEMAIL\tRULE")
+	for _, u := range doc.Users {
+		q.PrincipalID = u.ID
+		d, err := b.Decide(q)
+		if err != nil {
+			return fmt.Errorf("user %d: %w", u.ID, err)
+		}
+		if d.Allowed {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", u.ID, u.Name, u.Email, ruleLabel(doc, d.RuleID))
+		}
+	}
+	return w.Flush()
+}
+
+func runDiff(b backend, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "write the changes as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("want one policy file to compare with")
+	}
+	from, err := b.Document()
+	if err != nil {
+		return err
+	}
+	to, err := policy.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	diffs := policy.Diff(from, to)
+	if *asJSON {
+		if diffs == nil {
+			diffs = []policy.UserDiff{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diffs); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range diffs {
+			fmt.Fprintf(stdout, "%s (%d)\n", d.Name, d.UserID)
+			for _, c := range d.Added {
+				fmt.Fprintf(stdout, "  + %s\n", c)
+			}
+			for _, c := range d.Removed {
+				fmt.Fprintf(stdout, "  - %s\n", c)
+			}
+		}
+	}
+	if len(diffs) > 0 {
+		return errDenied
+	}
+	return nil
+}
+
+func runList(b backend, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("want users, profiles or rules")
+	}
+	doc, err := b.Document()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	switch args[0] {
+	case "users":
+		fmt.Fprintln(w, "ID\tNAME\tEMAIL\tPROFILES")
+		for _, u := range doc.Users {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", u.ID, u.Name, u.Email, joinIDs(u.ProfileIDs))
+		}
+	case "profiles":
+		fmt.Fprintln(w, "ID\tNAME\tDESCRIPTION\tRULES")
+		for _, p := range doc.Profiles {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.ID, p.Name, p.Description, joinIDs(p.RuleIDs))
+		}
+	case "rules":
+		fmt.Fprintln(w, "ID\tNAME\tTARGET\tVERB\tACTION")
+		for _, r := range doc.Rules {
+			action := r.Action.String()
+			if r.Action == core.ActionAllowAndForwardToNextRule {
+				action = fmt.Sprintf("%s:%d", action, r.ForwardRuleID)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s:%s\t%s\t%s\n", r.ID, r.Name, r.TargetResourceType, r.TargetResourceID, core.FormatVerb(r.Verb), action)
+		}
+	default:
+		return fmt.Errorf("cannot list %q, want users, profiles or rules", args[0])
+	}
+	return w.Flush()
+}
+
+func runReview(b backend, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("review", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asCSV := flags.Bool("csv", false, "write CSV, one row per permission, instead of JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	doc, err := b.Document()
+	if err != nil {
+		return err
+	}
+	review := policy.NewReview(doc)
+	if *asCSV {
+		return review.WriteCSV(stdout)
+	}
+	return review.WriteJSON(stdout)
+}
+
+func runImport(b backend, args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	file := flags.String("f", "", "policy file to import")
+	kube := flags.Bool("k8s", false, "the file holds Kubernetes Roles, ClusterRoles and bindings")
+	widen := flags.Bool("widen-namespaces", false, "with -k8s, import Roles and RoleBindings as applying in every namespace")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-f is required")
+	}
+	if *kube {
+		m := k8s.DefaultMapping()
+		m.WidenNamespaces = *widen
+		doc, err := readKubernetes(*file, m, stderr)
+		if err != nil {
+			return err
+		}
+		return b.Import(doc)
+	}
+	doc, err := policy.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	return b.Import(doc)
+}
+
+// readKubernetes converts a manifest file, printing what could not be
+// imported exactly
+func readKubernetes(path string, m k8s.Mapping, stderr io.Writer) (*policy.Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	doc, report, err := k8s.Import(f, m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, finding := range report {
+		fmt.Fprintf(stderr, "warning: %s\n", finding)
+	}
+	return doc, nil
+}
+
+func runExport(b backend, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	out := flags.String("o", "", "write to this file instead of stdout; .rbac files use the policy language")
+	dsl := flags.Bool("dsl", false, "write the policy language instead of JSON to stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	doc, err := b.Document()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *out != "":
+		return policy.WriteFile(*out, doc)
+	case *dsl:
+		return policy.Format(stdout, doc)
+	default:
+		return doc.Encode(stdout)
+	}
+}
+
+// parseQuery reads VERB TYPE [ID]
+func parseQuery(args []string) (query, error) {
+	var q query
+	var err error
+	if q.Verb, err = core.ParseVerb(args[0]); err != nil {
+		return q, err
+	}
+	if q.Verb == 0 {
+		return q, errors.New("a verb is required")
+	}
+	if q.ResourceType, err = core.ParseResourceType(args[1]); err != nil {
+		return q, err
+	}
+	if len(args) == 3 {
+		if q.ResourceID, err = strconv.ParseUint(args[2], 10, 64); err != nil {
+			return q, fmt.Errorf("invalid resource id %q", args[2])
+		}
+	}
+	return q, nil
+}
+
+func ruleLabel(doc *policy.Document, id uint64) string {
+	for _, r := range doc.Rules {
+		if r.ID == id {
+			return fmt.Sprintf("%s (%d)", r.Name, id)
+		}
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+func profileLabel(doc *policy.Document, id uint64) string {
+	for _, p := range doc.Profiles {
+		if p.ID == id {
+			return fmt.Sprintf("%s (%d)", p.Name, id)
+		}
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+func joinIDs(ids []uint64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// AccessRequestState is where an access request is in its lifecycle
+type AccessRequestState string
+
+const (
+	AccessRequestPending  AccessRequestState = "pending"  // waiting for approvals
+	AccessRequestApproved AccessRequestState = "approved" // profile assigned until GetExpiresAt
+	AccessRequestRejected AccessRequestState = "rejected"
+	AccessRequestExpired  AccessRequestState = "expired" // not decided in time, or the assignment ran out
+	AccessRequestRevoked  AccessRequestState = "revoked" // assignment ended early
+)
+
+// AccessRequest asks for a profile for a limited time. It is approved once
+// quorum distinct approvers agree, and the profile is then held for
+// duration from that moment.
+type AccessRequest struct {
+	arID            uint64
+	arResourceType  ResourceType
+	arCreatedAt     time.Time
+	arUpdatedAt     time.Time
+	arClosedAt      time.Time // when it was rejected, expired or revoked
+	arPendingUntil  time.Time // a pending request expires here
+	arExpiresAt     time.Time // end of the assignment, set on approval
+	arRequesterID   uint64
+	arProfileID     uint64
+	arJustification string
+	arDuration      time.Duration
+	arQuorum        int
+	arState         AccessRequestState
+	arApprovals     []uint64 // approver IDs in approval order
+	arClosedBy      uint64   // who rejected or revoked it
+	arVersion       uint64
+	mux             sync.RWMutex
+}
+
+// NewAccessRequest builds a pending request by requester for profile,
+// needing quorum approvals before pendingUntil
+func NewAccessRequest(requesterID, profileID uint64, justification string, duration time.Duration, quorum int, pendingUntil time.Time) *AccessRequest {
+	now := time.Now()
+	return &AccessRequest{
+		arID: xxhash.Sum64String(fmt.Sprint(ResourceTypeAccessRequest) + fmt.Sprint(requesterID, profileID, justification) +
+			strconv.FormatInt(now.UnixNano(), 10)),
+		arResourceType:  ResourceTypeAccessRequest,
+		arCreatedAt:     now,
+		arUpdatedAt:     now,
+		arPendingUntil:  pendingUntil,
+		arRequesterID:   requesterID,
+		arProfileID:     profileID,
+		arJustification: justification,
+		arDuration:      duration,
+		arQuorum:        quorum,
+		arState:         AccessRequestPending,
+	}
+}
+
+func (ar *AccessRequest) MarshalJSON() ([]byte, error) {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return json.Marshal(struct {
+		ID            uint64             `json:"id"`
+		RequesterID   uint64             `json:"requester_id"`
+		ProfileID     uint64             `json:"profile_id"`
+		Justification string             `json:"justification"`
+		Duration      string             `json:"duration"`
+		Quorum        int                `json:"quorum"`
+		State         AccessRequestState `json:"state"`
+		Approvals     []uint64           `json:"approvals"`
+		ClosedBy      uint64             `json:"closed_by,omitempty"`
+		CreatedAt     time.Time          `json:"created_at"`
+		PendingUntil  time.Time          `json:"pending_until"`
+		ExpiresAt     time.Time          `json:"expires_at"`
+		ClosedAt      time.Time          `json:"closed_at"`
+		Version       uint64             `json:"resource_version"`
+	}{
+		ID:            ar.arID,
+		RequesterID:   ar.arRequesterID,
+		ProfileID:     ar.arProfileID,
+		Justification: ar.arJustification,
+		Duration:      ar.arDuration.String(),
+		Quorum:        ar.arQuorum,
+		State:         ar.arState,
+		Approvals:     append([]uint64{}, ar.arApprovals...),
+		ClosedBy:      ar.arClosedBy,
+		CreatedAt:     ar.arCreatedAt,
+		PendingUntil:  ar.arPendingUntil,
+		ExpiresAt:     ar.arExpiresAt,
+		ClosedAt:      ar.arClosedAt,
+		Version:       ar.arVersion,
+	})
+}
+
+func (ar *AccessRequest) GetResourceID() uint64 {
+	return ar.arID
+}
+
+func (ar *AccessRequest) GetResourceType() ResourceType {
+	return ar.arResourceType
+}
+
+// GetResourceName describes the request, e.g. "Profile:42 for 7"
+func (ar *AccessRequest) GetResourceName() string {
+	return fmt.Sprintf("%s:%d for %d", ResourceTypeProfile, ar.arProfileID, ar.arRequesterID)
+}
+
+func (ar *AccessRequest) GetResourceDescription() string {
+	return ar.arJustification
+}
+
+func (ar *AccessRequest) GetResourceCreatedAt() time.Time {
+	return ar.arCreatedAt
+}
+
+func (ar *AccessRequest) GetResourceUpdatedAt() time.Time {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return ar.arUpdatedAt
+}
+
+// GetResourceDeletedAt returns when the request was closed
+func (ar *AccessRequest) GetResourceDeletedAt() time.Time {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return ar.arClosedAt
+}
+
+func (ar *AccessRequest) GetResourceVersion() uint64 {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return ar.arVersion
+}
+
+func (ar *AccessRequest) SetResourceVersion(version uint64) *AccessRequest {
+	ar.mux.Lock()
+	defer ar.mux.Unlock()
+	ar.arVersion = version
+	return ar
+}
+
+// IsActive reports whether the request is pending or approved
+func (ar *AccessRequest) IsActive() bool {
+	state := ar.GetState()
+	return state == AccessRequestPending || state == AccessRequestApproved
+}
+
+// IsDueAt reports whether an active request has run out at t: a pending one
+// was not decided in time, or an approved one's assignment ended
+func (ar *AccessRequest) IsDueAt(t time.Time) bool {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	switch ar.arState {
+	case AccessRequestPending:
+		return !t.Before(ar.arPendingUntil)
+	case AccessRequestApproved:
+		return !t.Before(ar.arExpiresAt)
+	}
+	return false
+}
+
+// Approve records approverID's approval at t. The approval that reaches the
+// quorum approves the request, starting the assignment at t.
+func (ar *AccessRequest) Approve(approverID uint64, t time.Time) *AccessRequest {
+	ar.mux.Lock()
+	defer ar.mux.Unlock()
+	ar.arApprovals = append(ar.arApprovals, approverID)
+	if len(ar.arApprovals) >= ar.arQuorum {
+		ar.arState = AccessRequestApproved
+		ar.arExpiresAt = t.Add(ar.arDuration)
+	}
+	ar.arUpdatedAt = t
+	return ar
+}
+
+// Reject closes a pending request
+func (ar *AccessRequest) Reject(byID uint64, t time.Time) *AccessRequest {
+	return ar.close(AccessRequestRejected, byID, t)
+}
+
+// Revoke closes an approved request before its assignment runs out
+func (ar *AccessRequest) Revoke(byID uint64, t time.Time) *AccessRequest {
+	return ar.close(AccessRequestRevoked, byID, t)
+}
+
+// Expire closes a request that is due, see IsDueAt
+func (ar *AccessRequest) Expire(t time.Time) *AccessRequest {
+	return ar.close(AccessRequestExpired, 0, t)
+}
+
+func (ar *AccessRequest) close(state AccessRequestState, byID uint64, t time.Time) *AccessRequest {
+	ar.mux.Lock()
+	defer ar.mux.Unlock()
+	ar.arState = state
+	ar.arClosedBy = byID
+	ar.arClosedAt = t
+	ar.arUpdatedAt = t
+	return ar
+}
+
+func (ar *AccessRequest) GetState() AccessRequestState {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return ar.arState
+}
+
+// GetApprovals returns the approver IDs in approval order
+func (ar *AccessRequest) GetApprovals() []uint64 {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return append([]uint64(nil), ar.arApprovals...)
+}
+
+func (ar *AccessRequest) HasApproved(approverID uint64) bool {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	for _, id := range ar.arApprovals {
+		if id == approverID {
+			return true
+		}
+	}
+	return false
+}
+
+func (ar *AccessRequest) GetRequesterID() uint64 {
+	return ar.arRequesterID
+}
+
+func (ar *AccessRequest) GetProfileID() uint64 {
+	return ar.arProfileID
+}
+
+func (ar *AccessRequest) GetJustification() string {
+	return ar.arJustification
+}
+
+func (ar *AccessRequest) GetDuration() time.Duration {
+	return ar.arDuration
+}
+
+func (ar *AccessRequest) GetQuorum() int {
+	return ar.arQuorum
+}
+
+func (ar *AccessRequest) GetPendingUntil() time.Time {
+	return ar.arPendingUntil
+}
+
+// GetExpiresAt returns when the assignment ends; zero until approved
+func (ar *AccessRequest) GetExpiresAt() time.Time {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return ar.arExpiresAt
+}
+
+// GetClosedBy returns who rejected or revoked the request
+func (ar *AccessRequest) GetClosedBy() uint64 {
+	ar.mux.RLock()
+	defer ar.mux.RUnlock()
+	return ar.arClosedBy
+}
+
+// Validate checks that the request names a requester, a profile, a reason,
+// a duration and a quorum
+func (ar *AccessRequest) Validate() error {
+	switch {
+	case ar.arRequesterID == 0 || ar.arProfileID == 0:
+		return fmt.Errorf("access request needs a requester and a profile")
+	case ar.arJustification == "":
+		return fmt.Errorf("access request needs a justification")
+	case ar.arDuration <= 0:
+		return fmt.Errorf("access request needs a positive duration")
+	case ar.arQuorum < 1:
+		return fmt.Errorf("access request needs a quorum of at least 1")
+	}
+	return nil
+}
+
+func (ar *AccessRequest) String() string {
+	return fmt.Sprintf("AccessRequest: %s (%s)", ar.GetResourceName(), ar.GetState())
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every presented API key, e.g.
+// "rbk_3f9a0c1d2e4b5a69_<secret>", so keys are easy to spot in logs and
+// secret scanners.
+const APIKeyPrefix = "rbk"
+
+const (
+	apiKeyIDBytes     = 8
+	apiKeySecretBytes = 32
+	apiKeySaltBytes   = 16
+)
+
+// APIKey is one credential of a ServiceAccount. Only a salted hash of the
+// secret is kept; the secret itself is returned once, by NewAPIKey.
+type APIKey struct {
+	keyID        string
+	keySalt      []byte
+	keyHash      []byte
+	keyCreatedAt time.Time
+	keyExpiresAt time.Time // zero: never expires
+	keyRevokedAt time.Time
+}
+
+// NewAPIKey generates a key expiring at expiresAt (zero for never) and
+// returns it with the string to hand to the client
+func NewAPIKey(expiresAt time.Time) (*APIKey, string, error) {
+	id := make([]byte, apiKeyIDBytes)
+	secret := make([]byte, apiKeySecretBytes)
+	salt := make([]byte, apiKeySaltBytes)
+	for _, b := range [][]byte{id, secret, salt} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, "", fmt.Errorf("generate API key: %w", err)
+		}
+	}
+
+	k := &APIKey{
+		keyID:        hex.EncodeToString(id),
+		keySalt:      salt,
+		keyCreatedAt: time.Now(),
+		keyExpiresAt: expiresAt,
+	}
+	encodedSecret := hex.EncodeToString(secret)
+	k.keyHash = k.hash(encodedSecret)
+	return k, APIKeyPrefix + "_" + k.keyID + "_" + encodedSecret, nil
+}
+
+// ParseAPIKey splits a presented key into its key ID and secret
+func ParseAPIKey(presented string) (keyID, secret string, err error) {
+	parts := strings.Split(presented, "_")
+	if len(parts) != 3 || parts[0] != APIKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("malformed API key")
+	}
+	return parts[1], parts[2], nil
+}
+
+func (k *APIKey) hash(secret string) []byte {
+	h := sha256.New()
+	h.Write(k.keySalt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// Matches reports, in constant time, whether secret is this key's secret
+func (k *APIKey) Matches(secret string) bool {
+	return subtle.ConstantTimeCompare(k.hash(secret), k.keyHash) == 1
+}
+
+// IsValidAt reports whether the key may be used at t: it is neither revoked
+// nor expired
+func (k *APIKey) IsValidAt(t time.Time) bool {
+	if !k.keyRevokedAt.IsZero() && !t.Before(k.keyRevokedAt) {
+		return false
+	}
+	return k.keyExpiresAt.IsZero() || t.Before(k.keyExpiresAt)
+}
+
+func (k *APIKey) GetID() string {
+	return k.keyID
+}
+
+func (k *APIKey) GetCreatedAt() time.Time {
+	return k.keyCreatedAt
+}
+
+func (k *APIKey) GetExpiresAt() time.Time {
+	return k.keyExpiresAt
+}
+
+func (k *APIKey) GetRevokedAt() time.Time {
+	return k.keyRevokedAt
+}
+
+// MarshalJSON describes the key without its salt or hash
+func (k *APIKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID        string    `json:"key_id"`
+		CreatedAt time.Time `json:"key_created_at"`
+		ExpiresAt time.Time `json:"key_expires_at"`
+		RevokedAt time.Time `json:"key_revoked_at"`
+	}{
+		ID:        k.keyID,
+		CreatedAt: k.keyCreatedAt,
+		ExpiresAt: k.keyExpiresAt,
+		RevokedAt: k.keyRevokedAt,
+	})
+}
@@ -0,0 +1,29 @@
+package core
+
+import "time"
+
+// BreakGlass makes a profile an emergency profile. An authorized principal
+// can take it on for Duration by giving a reason; once that activation is
+// over, the next one waits for a post-incident acknowledgement.
+type BreakGlass struct {
+	Duration        time.Duration `json:"duration"`
+	ActivatedBy     uint64        `json:"activated_by,omitempty"`
+	Reason          string        `json:"reason,omitempty"`
+	ActivatedAt     time.Time     `json:"activated_at"`
+	ExpiresAt       time.Time     `json:"expires_at"`
+	EndedAt         time.Time     `json:"ended_at"` // when it ran out or was acknowledged
+	AcknowledgedBy  uint64        `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt  time.Time     `json:"acknowledged_at"`
+	Acknowledgement string        `json:"acknowledgement,omitempty"`
+}
+
+// IsActiveAt reports whether an activation is in force at t
+func (b BreakGlass) IsActiveAt(t time.Time) bool {
+	return !b.ActivatedAt.IsZero() && b.EndedAt.IsZero() && t.Before(b.ExpiresAt)
+}
+
+// NeedsAcknowledgement reports whether the last activation has not been
+// acknowledged yet, which blocks the next one
+func (b BreakGlass) NeedsAcknowledgement() bool {
+	return !b.ActivatedAt.IsZero() && b.AcknowledgedAt.IsZero()
+}
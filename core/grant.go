@@ -0,0 +1,224 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Grant delegates part of one principal's access to another. It allows the
+// verbs on the target only while the grantor is itself allowed them, so a
+// grant never outlives or exceeds the access it was cut from.
+type Grant struct {
+	grantID                 uint64
+	grantResourceType       ResourceType
+	grantCreatedAt          time.Time
+	grantUpdatedAt          time.Time
+	grantDeletedAt          time.Time // revocation time
+	grantExpiresAt          time.Time
+	grantGrantorID          uint64
+	grantGranteeID          uint64
+	grantParentID           uint64 // grant the grantor's access came from, 0 for their own
+	grantDepth              int    // 1 for a grant of the grantor's own access
+	grantTargetResourceType ResourceType
+	grantTargetResourceID   string
+	grantVerb               Verb
+	grantVersion            uint64
+	mux                     sync.RWMutex
+}
+
+// NewGrant builds a grant from grantor to grantee of verb on the target,
+// expiring at expiresAt
+func NewGrant(grantorID, granteeID uint64, targetType ResourceType, targetID string, verb Verb, expiresAt time.Time) *Grant {
+	now := time.Now()
+	return &Grant{
+		grantID: xxhash.Sum64String(fmt.Sprint(ResourceTypeGrant) + fmt.Sprint(grantorID, granteeID, targetType, targetID, verb) +
+			strconv.FormatInt(now.UnixNano(), 10)),
+		grantResourceType:       ResourceTypeGrant,
+		grantCreatedAt:          now,
+		grantUpdatedAt:          now,
+		grantExpiresAt:          expiresAt,
+		grantGrantorID:          grantorID,
+		grantGranteeID:          granteeID,
+		grantDepth:              1,
+		grantTargetResourceType: targetType,
+		grantTargetResourceID:   targetID,
+		grantVerb:               verb,
+	}
+}
+
+func (g *Grant) MarshalJSON() ([]byte, error) {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	return json.Marshal(struct {
+		ID                 uint64    `json:"id"`
+		GrantorID          uint64    `json:"grantor_id"`
+		GranteeID          uint64    `json:"grantee_id"`
+		ParentID           uint64    `json:"parent_id,omitempty"`
+		Depth              int       `json:"depth"`
+		TargetResourceType string    `json:"target_resource_type"`
+		TargetResourceID   string    `json:"target_resource_id"`
+		Verb               string    `json:"verb"`
+		CreatedAt          time.Time `json:"created_at"`
+		ExpiresAt          time.Time `json:"expires_at"`
+		RevokedAt          time.Time `json:"revoked_at"`
+		Version            uint64    `json:"resource_version"`
+	}{
+		ID:                 g.grantID,
+		GrantorID:          g.grantGrantorID,
+		GranteeID:          g.grantGranteeID,
+		ParentID:           g.grantParentID,
+		Depth:              g.grantDepth,
+		TargetResourceType: g.grantTargetResourceType.String(),
+		TargetResourceID:   g.grantTargetResourceID,
+		Verb:               FormatVerb(g.grantVerb),
+		CreatedAt:          g.grantCreatedAt,
+		ExpiresAt:          g.grantExpiresAt,
+		RevokedAt:          g.grantDeletedAt,
+		Version:            g.grantVersion,
+	})
+}
+
+func (g *Grant) GetResourceID() uint64 {
+	return g.grantID
+}
+
+func (g *Grant) GetResourceType() ResourceType {
+	return g.grantResourceType
+}
+
+// GetResourceName describes the grant, e.g. "Project:42:read"
+func (g *Grant) GetResourceName() string {
+	return fmt.Sprintf("%s:%s:%s", g.grantTargetResourceType, g.grantTargetResourceID, FormatVerb(g.grantVerb))
+}
+
+func (g *Grant) GetResourceDescription() string {
+	return fmt.Sprintf("delegated by %d to %d", g.grantGrantorID, g.grantGranteeID)
+}
+
+func (g *Grant) GetResourceCreatedAt() time.Time {
+	return g.grantCreatedAt
+}
+
+func (g *Grant) GetResourceUpdatedAt() time.Time {
+	return g.grantUpdatedAt
+}
+
+func (g *Grant) GetResourceDeletedAt() time.Time {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	return g.grantDeletedAt
+}
+
+func (g *Grant) GetResourceVersion() uint64 {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	return g.grantVersion
+}
+
+func (g *Grant) SetResourceVersion(version uint64) *Grant {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.grantVersion = version
+	return g
+}
+
+// IsActive reports whether the grant has not been revoked. An active grant
+// may still have expired, see IsValidAt.
+func (g *Grant) IsActive() bool {
+	g.mux.RLock()
+	defer g.mux.RUnlock()
+	return g.grantDeletedAt.IsZero()
+}
+
+// IsValidAt reports whether the grant is neither revoked nor expired at t
+func (g *Grant) IsValidAt(t time.Time) bool {
+	return g.IsActive() && t.Before(g.grantExpiresAt)
+}
+
+// Revoke ends the grant; revoking twice keeps the first time
+func (g *Grant) Revoke() *Grant {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.grantDeletedAt.IsZero() {
+		g.grantDeletedAt = time.Now()
+		g.grantUpdatedAt = g.grantDeletedAt
+	}
+	return g
+}
+
+// SetParent records that the grantor's access came from parent, one
+// delegation step further down
+func (g *Grant) SetParent(parent *Grant) *Grant {
+	g.grantParentID = parent.GetResourceID()
+	g.grantDepth = parent.GetDepth() + 1
+	return g
+}
+
+func (g *Grant) GetGrantorID() uint64 {
+	return g.grantGrantorID
+}
+
+func (g *Grant) GetGranteeID() uint64 {
+	return g.grantGranteeID
+}
+
+// GetParentID returns the grant this one was re-delegated from, 0 if the
+// grantor delegated their own access
+func (g *Grant) GetParentID() uint64 {
+	return g.grantParentID
+}
+
+// GetDepth returns how many delegation steps separate the grantee from
+// access held through profiles
+func (g *Grant) GetDepth() int {
+	return g.grantDepth
+}
+
+func (g *Grant) GetExpiresAt() time.Time {
+	return g.grantExpiresAt
+}
+
+func (g *Grant) GetTargetResourceType() ResourceType {
+	return g.grantTargetResourceType
+}
+
+func (g *Grant) GetTargetResourceID() string {
+	return g.grantTargetResourceID
+}
+
+func (g *Grant) GetVerb() Verb {
+	return g.grantVerb
+}
+
+// Validate checks that the grant names a target, some verbs and an expiry
+func (g *Grant) Validate() error {
+	switch {
+	case g.grantGrantorID == 0 || g.grantGranteeID == 0:
+		return fmt.Errorf("grant needs a grantor and a grantee")
+	case g.grantGrantorID == g.grantGranteeID:
+		return fmt.Errorf("principal %d cannot delegate to itself", g.grantGrantorID)
+	case g.grantTargetResourceType == ResourceTypeNone:
+		return fmt.Errorf("TargetResourceType cannot be ResourceTypeNone")
+	case g.grantTargetResourceType == ResourceTypeAll && g.grantTargetResourceID != ResourceIDAll:
+		return fmt.Errorf("TargetResourceID must be %q for ResourceTypeAll", ResourceIDAll)
+	case g.grantVerb == 0:
+		return fmt.Errorf("grant needs at least one verb")
+	case g.grantExpiresAt.IsZero():
+		return fmt.Errorf("grant needs an expiry")
+	}
+	if g.grantTargetResourceID != ResourceIDAll {
+		if _, err := strconv.ParseUint(g.grantTargetResourceID, 10, 64); err != nil {
+			return fmt.Errorf("TargetResourceID must be %q or a resource ID, got %q", ResourceIDAll, g.grantTargetResourceID)
+		}
+	}
+	return nil
+}
+
+func (g *Grant) String() string {
+	return fmt.Sprintf("Grant: %s from %d to %d", g.GetResourceName(), g.grantGrantorID, g.grantGranteeID)
+}
@@ -18,6 +18,9 @@ type Profile struct {
 	profUpdatedAt    time.Time
 	profDeletedAt    time.Time
 	profRuleMap      map[uint32][]*Rule
+	profConflicts    map[uint64]SoDKind
+	profBreakGlass   *BreakGlass
+	profVersion      uint64
 }
 
 func (p *Profile) String() string {
@@ -39,6 +42,9 @@ func (p *Profile) MarshalJSON() ([]byte, error) {
 		UpdatedAt    time.Time          `json:"profile_updated_at"`
 		DeletedAt    time.Time          `json:"profile_deleted_at"`
 		RuleMap      map[uint32][]*Rule `json:"profile_rule_map"`
+		Conflicts    map[uint64]SoDKind `json:"profile_conflicts,omitempty"`
+		BreakGlass   *BreakGlass        `json:"profile_break_glass,omitempty"`
+		Version      uint64             `json:"profile_resource_version"`
 	}{
 		ID:           p.profID,
 		Name:         p.profName,
@@ -48,6 +54,9 @@ func (p *Profile) MarshalJSON() ([]byte, error) {
 		CreatedAt:    p.profCreatedAt,
 		UpdatedAt:    p.profUpdatedAt,
 		DeletedAt:    p.profDeletedAt,
+		Conflicts:    p.profConflicts,
+		BreakGlass:   p.profBreakGlass,
+		Version:      p.profVersion,
 	})
 }
 
@@ -61,6 +70,9 @@ func (p *Profile) UnmarshalJSON(data []byte) error {
 		UpdatedAt    time.Time          `json:"profile_updated_at"`
 		DeletedAt    time.Time          `json:"profile_deleted_at"`
 		RuleMap      map[uint32][]*Rule `json:"profile_rule_map"`
+		Conflicts    map[uint64]SoDKind `json:"profile_conflicts"`
+		BreakGlass   *BreakGlass        `json:"profile_break_glass"`
+		Version      uint64             `json:"profile_resource_version"`
 	}
 
 	if err := json.Unmarshal(data, &profile); err != nil {
@@ -75,6 +87,9 @@ func (p *Profile) UnmarshalJSON(data []byte) error {
 	p.profUpdatedAt = profile.UpdatedAt
 	p.profDeletedAt = profile.DeletedAt
 	p.profRuleMap = profile.RuleMap
+	p.profConflicts = profile.Conflicts
+	p.profBreakGlass = profile.BreakGlass
+	p.profVersion = profile.Version
 
 	return nil
 }
@@ -117,10 +132,40 @@ func (p *Profile) GetResourceDeletedAt() time.Time {
 	return p.profDeletedAt
 }
 
+func (p *Profile) GetResourceVersion() uint64 {
+	return p.profVersion
+}
+
+func (p *Profile) SetResourceVersion(version uint64) *Profile {
+	p.profVersion = version
+	return p
+}
+
 func (p *Profile) IsActive() bool {
 	return p.profDeletedAt.IsZero()
 }
 
+func (p *Profile) SoftDelete() *Profile {
+	p.profDeletedAt = time.Now()
+	return p
+}
+
+func (p *Profile) Restore() *Profile {
+	p.profDeletedAt = time.Time{}
+	return p
+}
+
+func (p *Profile) HasRule(ruleID uint64) bool {
+	for _, rules := range p.profRuleMap {
+		for _, rule := range rules {
+			if rule.GetResourceID() == ruleID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func NewProfile(name string, description string) *Profile {
 	return &Profile{
 		profID:           xxhash.Sum64String(fmt.Sprint(ResourceTypeProfile) + name + description),
@@ -133,13 +178,30 @@ func NewProfile(name string, description string) *Profile {
 	}
 }
 
+// NewProfileWithID builds a profile whose ID is already known, e.g. one
+// being loaded from a policy file.
+func NewProfileWithID(id uint64, name string, description string) *Profile {
+	p := NewProfile(name, description)
+	p.profID = id
+	return p
+}
+
+func (p *Profile) Update(name string, description string) *Profile {
+	p.profName = name
+	p.profDescription = description
+	p.profUpdatedAt = time.Now()
+	return p
+}
+
 func (p *Profile) UpdateName(name string) *Profile {
 	p.profName = name
+	p.profUpdatedAt = time.Now()
 	return p
 }
 
 func (p *Profile) UpdateDescription(description string) *Profile {
 	p.profDescription = description
+	p.profUpdatedAt = time.Now()
 	return p
 }
 
@@ -153,9 +215,116 @@ func (p *Profile) AddRule(rule *Rule) *Profile {
 }
 
 func (p *Profile) RemoveRule(ruleID uint64) *Profile {
-	for targetresourcetype, rule := range p.profRuleMap {
-		fmt.Print(targetresourcetype)
-		fmt.Println(rule)
+	for targetresourcetype, rules := range p.profRuleMap {
+		for i, rule := range rules {
+			if rule.GetResourceID() == ruleID {
+				p.profRuleMap[targetresourcetype] = append(rules[:i:i], rules[i+1:]...)
+				if len(p.profRuleMap[targetresourcetype]) == 0 {
+					delete(p.profRuleMap, targetresourcetype)
+				}
+				p.profUpdatedAt = time.Now()
+				return p
+			}
+		}
+	}
+	return p
+}
+
+// SetConflict declares how this profile is kept apart from another;
+// SoDNone lifts the constraint. Constraints are checked from either side,
+// so declaring them on one profile is enough.
+func (p *Profile) SetConflict(profileID uint64, kind SoDKind) *Profile {
+	if kind == SoDNone {
+		delete(p.profConflicts, profileID)
+	} else {
+		if p.profConflicts == nil {
+			p.profConflicts = make(map[uint64]SoDKind)
+		}
+		p.profConflicts[profileID] = kind
+	}
+	p.profUpdatedAt = time.Now()
+	return p
+}
+
+func (p *Profile) GetConflict(profileID uint64) SoDKind {
+	return p.profConflicts[profileID]
+}
+
+// GetConflicts returns a copy of the profile's constraints by profile ID
+func (p *Profile) GetConflicts() map[uint64]SoDKind {
+	conflicts := make(map[uint64]SoDKind, len(p.profConflicts))
+	for id, kind := range p.profConflicts {
+		conflicts[id] = kind
+	}
+	return conflicts
+}
+
+// SetBreakGlass makes the profile an emergency profile held for duration per
+// activation, keeping any activation state; a zero duration makes it an
+// ordinary profile again
+func (p *Profile) SetBreakGlass(duration time.Duration) *Profile {
+	switch {
+	case duration <= 0:
+		p.profBreakGlass = nil
+	case p.profBreakGlass == nil:
+		p.profBreakGlass = &BreakGlass{Duration: duration}
+	default:
+		p.profBreakGlass.Duration = duration
+	}
+	p.profUpdatedAt = time.Now()
+	return p
+}
+
+// GetBreakGlass returns a copy of the profile's emergency configuration and
+// state, and whether it is an emergency profile
+func (p *Profile) GetBreakGlass() (BreakGlass, bool) {
+	if p.profBreakGlass == nil {
+		return BreakGlass{}, false
+	}
+	return *p.profBreakGlass, true
+}
+
+// IsEmergency reports whether the profile is a break-glass profile
+func (p *Profile) IsEmergency() bool {
+	return p.profBreakGlass != nil
+}
+
+// ActivateBreakGlass records byID taking the profile on at t for reason,
+// until the configured duration has passed
+func (p *Profile) ActivateBreakGlass(byID uint64, reason string, t time.Time) *Profile {
+	if p.profBreakGlass == nil {
+		return p
+	}
+	p.profBreakGlass = &BreakGlass{
+		Duration:    p.profBreakGlass.Duration,
+		ActivatedBy: byID,
+		Reason:      reason,
+		ActivatedAt: t,
+		ExpiresAt:   t.Add(p.profBreakGlass.Duration),
+	}
+	p.profUpdatedAt = t
+	return p
+}
+
+// EndBreakGlass records the activation ending at t; ending twice keeps the
+// first time
+func (p *Profile) EndBreakGlass(t time.Time) *Profile {
+	if p.profBreakGlass != nil && p.profBreakGlass.EndedAt.IsZero() {
+		p.profBreakGlass.EndedAt = t
+		p.profUpdatedAt = t
+	}
+	return p
+}
+
+// AcknowledgeBreakGlass records byID's post-incident acknowledgement at t,
+// ending the activation if it is still in force
+func (p *Profile) AcknowledgeBreakGlass(byID uint64, note string, t time.Time) *Profile {
+	if p.profBreakGlass == nil {
+		return p
 	}
+	p.EndBreakGlass(t)
+	p.profBreakGlass.AcknowledgedBy = byID
+	p.profBreakGlass.AcknowledgedAt = t
+	p.profBreakGlass.Acknowledgement = note
 	return p
 }
@@ -1,6 +1,10 @@
 package core
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type ResourceType uint32
 
@@ -16,8 +20,26 @@ const (
 	ResourceTypePermission
 	ResourceTypeRule
 	ResourceTypeAll
+	// Profiles and users persist resource types by number, so new types go
+	// here, after ResourceTypeAll, keeping the numbers above unchanged
+	ResourceTypeServiceAccount
+	ResourceTypeGrant
+	ResourceTypeAccessRequest
 )
 
+// resourceTypeOrder lists the concrete resource types in declaration order
+var resourceTypeOrder = []ResourceType{
+	ResourceTypeUser, ResourceTypeProfile, ResourceTypeURL, ResourceTypeOrganization, ResourceTypeProject,
+	ResourceTypeRole, ResourceTypePermission, ResourceTypeRule, ResourceTypeServiceAccount, ResourceTypeGrant,
+	ResourceTypeAccessRequest,
+}
+
+// ResourceTypes returns every resource type but ResourceTypeNone and
+// ResourceTypeAll
+func ResourceTypes() []ResourceType {
+	return append([]ResourceType(nil), resourceTypeOrder...)
+}
+
 func (resourceType ResourceType) String() string {
 	switch resourceType {
 	case ResourceTypeUser:
@@ -36,6 +58,12 @@ func (resourceType ResourceType) String() string {
 		return "Permission"
 	case ResourceTypeRule:
 		return "Rule"
+	case ResourceTypeServiceAccount:
+		return "ServiceAccount"
+	case ResourceTypeGrant:
+		return "Grant"
+	case ResourceTypeAccessRequest:
+		return "AccessRequest"
 	case ResourceTypeNone:
 		return ""
 	default:
@@ -43,6 +71,16 @@ func (resourceType ResourceType) String() string {
 	}
 }
 
+// ParseResourceType is the inverse of ResourceType.String; case is ignored
+func ParseResourceType(s string) (ResourceType, error) {
+	for _, t := range append(ResourceTypes(), ResourceTypeNone, ResourceTypeAll) {
+		if strings.EqualFold(t.String(), s) {
+			return t, nil
+		}
+	}
+	return ResourceTypeNone, fmt.Errorf("unknown resource type %q", s)
+}
+
 type Resource interface {
 	GetResourceID() uint64
 	GetResourceName() string
@@ -51,5 +89,13 @@ type Resource interface {
 	GetResourceCreatedAt() time.Time
 	GetResourceUpdatedAt() time.Time
 	GetResourceDeletedAt() time.Time
+	GetResourceVersion() uint64
 	IsActive() bool
 }
+
+// Principal is anything requests can be made as: a User or a ServiceAccount
+type Principal interface {
+	Resource
+	GetProfiles() []Profile
+	HasProfile(profileID uint64) bool
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// baselineProfile is a profile with one rule on every type and one on
+// projects, as encoded before service accounts, grants and access requests
+// were added
+const baselineProfile = `{"profile_id":18418305856046361293,"profile_name":"admins","profile_description":"","profile_resource_type":3,"profile_created_at":"2026-10-18T22:53:39.740901062Z","profile_updated_at":"2026-10-18T22:53:39.740901193Z","profile_deleted_at":"0001-01-01T00:00:00Z","profile_rule_map":{"10":[{"id":2934380529260533062,"name":"rule-*","target_resource_type":"*","target_resource_id":"","verb":"read","action":"allow"}],"6":[{"id":7408768785441869316,"name":"rule-Project","target_resource_type":"Project","target_resource_id":"*","verb":"read","action":"allow"}]}}`
+
+func TestResourceType_BaselineJSONRoundTrip(t *testing.T) {
+	var p Profile
+	if err := json.Unmarshal([]byte(baselineProfile), &p); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if p.GetResourceType() != ResourceTypeProfile {
+		t.Errorf("profile_resource_type decoded as %s", p.GetResourceType())
+	}
+	if got := len(p.GetAssociatedRules(ResourceTypeAll)); got != 1 {
+		t.Errorf("Expected the rule on every type under ResourceTypeAll, got %d rules", got)
+	}
+	if got := len(p.GetAssociatedRules(ResourceTypeProject)); got != 1 {
+		t.Errorf("Expected the project rule under ResourceTypeProject, got %d rules", got)
+	}
+
+	raw, err := json.Marshal(&p)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for _, want := range []string{`"profile_resource_type":3`, `"profile_rule_map":{"10":`, `,"6":`} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("Expected %s in re-encoded profile %s", want, raw)
+		}
+	}
+
+	raw, _ = json.Marshal(NewUser("a", "b", "c@d"))
+	if !strings.Contains(string(raw), `"user_resource_type":2`) {
+		t.Errorf("User resource type renumbered: %s", raw)
+	}
+}
+
+func TestParseResourceType(t *testing.T) {
+	for _, rt := range append(ResourceTypes(), ResourceTypeAll) {
+		if got, err := ParseResourceType(rt.String()); err != nil || got != rt {
+			t.Errorf("ParseResourceType(%q) = %s, %v", rt.String(), got, err)
+		}
+	}
+	if _, err := ParseResourceType("Nope"); err == nil {
+		t.Errorf("Expected an error for an unknown type")
+	}
+}
@@ -3,6 +3,8 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
@@ -17,6 +19,10 @@ const (
 	VerbDelete
 	VerbList
 	VerbExecute
+	// VerbImpersonate lets a principal act as the user or service account
+	// a rule targets, see lib.RequestContext.ImpersonatorID. It is not part
+	// of VerbAll and must be granted by name.
+	VerbImpersonate
 	VerbAll = VerbRead | VerbCreate | VerbUpdate | VerbDelete | VerbList | VerbExecute
 )
 
@@ -34,11 +40,63 @@ func (v Verb) String() string {
 		return "list"
 	case VerbExecute:
 		return "execute"
+	case VerbImpersonate:
+		return "impersonate"
 	default:
 		return "*"
 	}
 }
 
+// verbOrder lists the single verbs in bit order
+var verbOrder = []Verb{VerbRead, VerbCreate, VerbUpdate, VerbDelete, VerbList, VerbExecute, VerbImpersonate}
+
+// Verbs returns the single verbs in bit order. VerbAll is all of them but
+// VerbImpersonate.
+func Verbs() []Verb {
+	return append([]Verb(nil), verbOrder...)
+}
+
+// FormatVerb renders any verb combination without losing information:
+// "*" for VerbAll, names joined by "|" otherwise (e.g. "read|list"), and ""
+// for no verb at all.
+func FormatVerb(v Verb) string {
+	if v == VerbAll {
+		return "*"
+	}
+	var names []string
+	for _, single := range verbOrder {
+		if v&single != 0 {
+			names = append(names, single.String())
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// ParseVerb is the inverse of FormatVerb
+func ParseVerb(s string) (Verb, error) {
+	if s == "*" {
+		return VerbAll, nil
+	}
+	var v Verb
+	if s == "" {
+		return v, nil
+	}
+	for _, name := range strings.Split(s, "|") {
+		found := false
+		for _, single := range verbOrder {
+			if single.String() == name {
+				v |= single
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown verb %q", name)
+		}
+	}
+	return v, nil
+}
+
 type Action uint8
 
 const (
@@ -60,6 +118,16 @@ func (a Action) String() string {
 	}
 }
 
+// ParseAction is the inverse of Action.String
+func ParseAction(s string) (Action, error) {
+	for _, a := range []Action{ActionAllow, ActionDeny, ActionAllowAndForwardToNextRule} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown action %q", s)
+}
+
 type ActionOption struct {
 	Action     Action `json:"action"`
 	NextRuleID uint64 `json:"next_rule_id"`
@@ -78,6 +146,7 @@ type Rule struct {
 	ruleVerb               Verb
 	ruleAction             Action
 	ruleForwardRuleID      uint64
+	ruleVersion            uint64
 }
 
 func (r *Rule) String() string {
@@ -92,21 +161,31 @@ func (r *Rule) JSON() string {
 func (r *Rule) MarshalJSON() ([]byte, error) {
 	// We map private fields to a public-facing map or anonymous struct
 	return json.Marshal(struct {
-		ID                 uint64 `json:"id"`
-		Name               string `json:"name"`
-		TargetResourceType string `json:"target_resource_type"`
-		TargetResourceID   string `json:"target_resource_id"`
-		Verb               string `json:"verb"`
-		Action             string `json:"action"`
-		ForwardRuleID      uint64 `json:"forward_rule_id,omitempty"`
+		ID                 uint64    `json:"id"`
+		Name               string    `json:"name"`
+		Description        string    `json:"description"`
+		TargetResourceType string    `json:"target_resource_type"`
+		TargetResourceID   string    `json:"target_resource_id"`
+		Verb               string    `json:"verb"`
+		Action             string    `json:"action"`
+		ForwardRuleID      uint64    `json:"forward_rule_id,omitempty"`
+		CreatedAt          time.Time `json:"created_at"`
+		UpdatedAt          time.Time `json:"updated_at"`
+		DeletedAt          time.Time `json:"deleted_at"`
+		Version            uint64    `json:"resource_version"`
 	}{
 		ID:                 r.ruleID,
 		Name:               r.ruleName,
+		Description:        r.ruleDescription,
 		TargetResourceType: r.ruleTargetResourceType.String(),
 		TargetResourceID:   r.ruleTargetResourceID,
-		Verb:               r.ruleVerb.String(),   // Good chance to use the string representation
-		Action:             r.ruleAction.String(), // for better JSON readability
+		Verb:               FormatVerb(r.ruleVerb), // Good chance to use the string representation
+		Action:             r.ruleAction.String(),  // for better JSON readability
 		ForwardRuleID:      r.ruleForwardRuleID,
+		CreatedAt:          r.ruleCreatedAt,
+		UpdatedAt:          r.ruleUpdatedAt,
+		DeletedAt:          r.ruleDeletedAt,
+		Version:            r.ruleVersion,
 	})
 }
 
@@ -142,6 +221,15 @@ func (r *Rule) GetResourceForwardRuleID() uint64 {
 	return r.ruleForwardRuleID
 }
 
+func (r *Rule) GetResourceVersion() uint64 {
+	return r.ruleVersion
+}
+
+func (r *Rule) SetResourceVersion(version uint64) *Rule {
+	r.ruleVersion = version
+	return r
+}
+
 func (r *Rule) IsActive() bool {
 	return r.ruleDeletedAt.IsZero()
 }
@@ -179,6 +267,15 @@ func NewEmptyRule(name string) *Rule {
 	return rule
 }
 
+// NewRuleWithID is NewEmptyRule for a rule whose ID is already known, e.g.
+// one being loaded from a policy file.
+func NewRuleWithID(id uint64, name string, description string) *Rule {
+	rule := NewEmptyRule(name)
+	rule.ruleID = id
+	rule.ruleDescription = description
+	return rule
+}
+
 // func (r *Rule) String() string {
 // 	return fmt.Sprintf("rule %d:%s:%s:%s:%s", r.ruleID, r.ruleTargetResourceType, r.ruleTargetResourceID, r.ruleVerb, r.ruleAction)
 // }
@@ -230,12 +327,25 @@ func (r *Rule) UpdateAction(actionOption ActionOption) (*Rule, error) {
 			return nil, fmt.Errorf("Invalid NextRuleID for ActionAllowAndForwardToNextRule")
 		}
 		r.ruleForwardRuleID = actionOption.NextRuleID
+	} else {
+		r.ruleForwardRuleID = 0
 	}
 	r.ruleAction = actionOption.Action
 	r.ruleUpdatedAt = time.Now()
 	return r, nil
 }
 
+// ClearForwardRule drops the forward link, turning a forwarding rule into a
+// plain allow. Used when the rule it pointed at no longer exists.
+func (r *Rule) ClearForwardRule() *Rule {
+	if r.ruleAction == ActionAllowAndForwardToNextRule {
+		r.ruleAction = ActionAllow
+	}
+	r.ruleForwardRuleID = 0
+	r.ruleUpdatedAt = time.Now()
+	return r
+}
+
 func (r *Rule) SetTargetResourceTypeAndID(targetResourceType ResourceType, targetResourceID string) (*Rule, error) {
 	// Create a real copy of the data, not just the pointer
 	temp := *r
@@ -318,16 +428,33 @@ func (r *Rule) GetTargetResourceID() string {
 	return r.ruleTargetResourceID
 }
 
+// GetRuleAsDSL returns the rule as one line of the policy language that
+// lib/policy parses, e.g.
+//
+//	rule 10:Project:*:read|list:allow name="read-projects"
 func (r *Rule) GetRuleAsDSL() string {
-	// ruleid:targetresourcetype:targetresourceID:verb:action
-	return fmt.Sprintf("rule %d:%s:%s:%s:%s", r.ruleID, r.ruleTargetResourceType, r.ruleTargetResourceID, r.ruleVerb, r.ruleAction)
+	// rule id:targettype:targetID:verb:action[:forwardID] name=".." [description=".."]
+	target := r.ruleTargetResourceID
+	if strings.ContainsAny(target, " \t:\"#") || strconv.Quote(target) != `"`+target+`"` {
+		target = strconv.Quote(target)
+	}
+	dsl := fmt.Sprintf("rule %d:%s:%s:%s:%s", r.ruleID, r.ruleTargetResourceType, target, FormatVerb(r.ruleVerb), r.ruleAction)
+	if r.ruleAction == ActionAllowAndForwardToNextRule || r.ruleForwardRuleID != 0 {
+		dsl += fmt.Sprintf(":%d", r.ruleForwardRuleID)
+	}
+	dsl += " name=" + strconv.Quote(r.ruleName)
+	if r.ruleDescription != "" {
+		dsl += " description=" + strconv.Quote(r.ruleDescription)
+	}
+	return dsl
 }
 
 func (r *Rule) IsValidRuleSyntax() (bool, error) {
 	if r.ruleResourceType == ResourceTypeRule { // only valid if this is a rule resourcetype, false otherwise
 		if r.ruleTargetResourceType == ResourceTypeAll {
-			if r.ruleTargetResourceID != "" {
-				return false, fmt.Errorf("TargetResourceID must be empty for ResourceTypeAll")
+			// A specific ID cannot name a resource of every type
+			if r.ruleTargetResourceID != "" && r.ruleTargetResourceID != ResourceIDAll {
+				return false, fmt.Errorf("TargetResourceID must be empty or %q for ResourceTypeAll", ResourceIDAll)
 			}
 		}
 		if r.ruleTargetResourceID != "" {
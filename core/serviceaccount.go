@@ -0,0 +1,266 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ServiceAccount is a non-human principal: a workload acting on its own
+// behalf. It holds profiles like a User, belongs to an owning user, and
+// authenticates with API keys.
+type ServiceAccount struct {
+	saID           uint64
+	saName         string
+	saDescription  string
+	saResourceType ResourceType
+	saCreatedAt    time.Time
+	saUpdatedAt    time.Time
+	saDeletedAt    time.Time
+	saOwnerID      uint64
+	saProfiles     []*Profile
+	saKeys         []*APIKey
+	saVersion      uint64
+	mux            sync.RWMutex
+}
+
+func (sa *ServiceAccount) MarshalJSON() ([]byte, error) {
+	sa.mux.RLock()
+	defer sa.mux.RUnlock()
+	return json.Marshal(struct {
+		ID           uint64       `json:"service_account_id"`
+		Name         string       `json:"service_account_name"`
+		Description  string       `json:"service_account_description"`
+		ResourceType ResourceType `json:"service_account_resource_type"`
+		CreatedAt    time.Time    `json:"service_account_created_at"`
+		UpdatedAt    time.Time    `json:"service_account_updated_at"`
+		DeletedAt    time.Time    `json:"service_account_deleted_at"`
+		OwnerID      uint64       `json:"service_account_owner_id"`
+		Profiles     []*Profile   `json:"service_account_profiles"`
+		Keys         []*APIKey    `json:"service_account_api_keys"`
+		Version      uint64       `json:"service_account_resource_version"`
+	}{
+		ID:           sa.saID,
+		Name:         sa.saName,
+		Description:  sa.saDescription,
+		ResourceType: sa.saResourceType,
+		CreatedAt:    sa.saCreatedAt,
+		UpdatedAt:    sa.saUpdatedAt,
+		DeletedAt:    sa.saDeletedAt,
+		OwnerID:      sa.saOwnerID,
+		Profiles:     sa.saProfiles,
+		Keys:         sa.saKeys,
+		Version:      sa.saVersion,
+	})
+}
+
+func NewServiceAccount(name string, description string, ownerID uint64) *ServiceAccount {
+	return &ServiceAccount{
+		saID:           xxhash.Sum64String(fmt.Sprint(ResourceTypeServiceAccount) + name + description),
+		saName:         name,
+		saDescription:  description,
+		saResourceType: ResourceTypeServiceAccount,
+		saCreatedAt:    time.Now(),
+		saUpdatedAt:    time.Now(),
+		saDeletedAt:    time.Time{},
+		saOwnerID:      ownerID,
+		saProfiles:     []*Profile{},
+		saKeys:         []*APIKey{},
+	}
+}
+
+// NewServiceAccountWithID builds a service account whose ID is already
+// known
+func NewServiceAccountWithID(id uint64, name string, description string, ownerID uint64) *ServiceAccount {
+	sa := NewServiceAccount(name, description, ownerID)
+	sa.saID = id
+	return sa
+}
+
+func (sa *ServiceAccount) GetResourceType() ResourceType {
+	return sa.saResourceType
+}
+
+func (sa *ServiceAccount) GetResourceID() uint64 {
+	return sa.saID
+}
+
+func (sa *ServiceAccount) GetResourceName() string {
+	return sa.saName
+}
+
+func (sa *ServiceAccount) GetResourceDescription() string {
+	return sa.saDescription
+}
+
+func (sa *ServiceAccount) GetResourceCreatedAt() time.Time {
+	return sa.saCreatedAt
+}
+
+func (sa *ServiceAccount) GetResourceUpdatedAt() time.Time {
+	return sa.saUpdatedAt
+}
+
+func (sa *ServiceAccount) GetResourceDeletedAt() time.Time {
+	return sa.saDeletedAt
+}
+
+func (sa *ServiceAccount) GetResourceVersion() uint64 {
+	sa.mux.RLock()
+	defer sa.mux.RUnlock()
+	return sa.saVersion
+}
+
+func (sa *ServiceAccount) SetResourceVersion(version uint64) *ServiceAccount {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	sa.saVersion = version
+	return sa
+}
+
+func (sa *ServiceAccount) IsActive() bool {
+	return sa.saDeletedAt.IsZero()
+}
+
+// GetOwnerID returns the ID of the user accountable for the account
+func (sa *ServiceAccount) GetOwnerID() uint64 {
+	return sa.saOwnerID
+}
+
+func (sa *ServiceAccount) Update(name string, description string, ownerID uint64) *ServiceAccount {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	sa.saName = name
+	sa.saDescription = description
+	sa.saOwnerID = ownerID
+	sa.saUpdatedAt = time.Now()
+	return sa
+}
+
+func (sa *ServiceAccount) SoftDelete() *ServiceAccount {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	sa.saDeletedAt = time.Now()
+	return sa
+}
+
+func (sa *ServiceAccount) Restore() *ServiceAccount {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	sa.saDeletedAt = time.Time{}
+	return sa
+}
+
+// --- Profiles ---
+
+func (sa *ServiceAccount) GetProfiles() []Profile {
+	sa.mux.RLock()
+	defer sa.mux.RUnlock()
+	profiles := []Profile{}
+	for _, profile := range sa.saProfiles {
+		profiles = append(profiles, *profile)
+	}
+	return profiles
+}
+
+// AddProfile grants profile, unless it statically conflicts with a
+// profile already held; that returns a *SoDViolation and changes nothing.
+func (sa *ServiceAccount) AddProfile(profile *Profile) (*ServiceAccount, error) {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	if err := checkStaticSoD(sa.saID, sa.saProfiles, profile); err != nil {
+		return sa, err
+	}
+	sa.saProfiles = append(sa.saProfiles, profile)
+	return sa, nil
+}
+
+func (sa *ServiceAccount) RemoveProfile(profile *Profile) *ServiceAccount {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	for i, p := range sa.saProfiles {
+		if p.GetResourceID() == profile.GetResourceID() {
+			sa.saProfiles = append(sa.saProfiles[:i], sa.saProfiles[i+1:]...)
+			return sa
+		}
+	}
+	return sa
+}
+
+func (sa *ServiceAccount) HasProfile(profileID uint64) bool {
+	sa.mux.RLock()
+	defer sa.mux.RUnlock()
+	for _, p := range sa.saProfiles {
+		if p.GetResourceID() == profileID {
+			return true
+		}
+	}
+	return false
+}
+
+// --- API keys ---
+
+// AddAPIKey attaches a key built by NewAPIKey
+func (sa *ServiceAccount) AddAPIKey(key *APIKey) *ServiceAccount {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	sa.saKeys = append(sa.saKeys, key)
+	sa.saUpdatedAt = time.Now()
+	return sa
+}
+
+// GetAPIKeys returns copies of the account's keys, including expired and
+// revoked ones
+func (sa *ServiceAccount) GetAPIKeys() []APIKey {
+	sa.mux.RLock()
+	defer sa.mux.RUnlock()
+	keys := make([]APIKey, 0, len(sa.saKeys))
+	for _, k := range sa.saKeys {
+		keys = append(keys, *k)
+	}
+	return keys
+}
+
+// HasAPIKey reports whether keyID belongs to the account
+func (sa *ServiceAccount) HasAPIKey(keyID string) bool {
+	sa.mux.RLock()
+	defer sa.mux.RUnlock()
+	return sa.findKey(keyID) != nil
+}
+
+// RevokeAPIKey makes keyID unusable from at onwards. A revocation already
+// due earlier is kept.
+func (sa *ServiceAccount) RevokeAPIKey(keyID string, at time.Time) *ServiceAccount {
+	sa.mux.Lock()
+	defer sa.mux.Unlock()
+	if k := sa.findKey(keyID); k != nil && (k.keyRevokedAt.IsZero() || at.Before(k.keyRevokedAt)) {
+		k.keyRevokedAt = at
+		sa.saUpdatedAt = time.Now()
+	}
+	return sa
+}
+
+// Authenticate reports whether secret matches keyID and the key is usable
+// at t
+func (sa *ServiceAccount) Authenticate(keyID, secret string, t time.Time) bool {
+	sa.mux.RLock()
+	defer sa.mux.RUnlock()
+	k := sa.findKey(keyID)
+	return k != nil && k.Matches(secret) && k.IsValidAt(t)
+}
+
+func (sa *ServiceAccount) findKey(keyID string) *APIKey {
+	for _, k := range sa.saKeys {
+		if k.keyID == keyID {
+			return k
+		}
+	}
+	return nil
+}
+
+func (sa *ServiceAccount) String() string {
+	return fmt.Sprintf("ServiceAccount: %s (owner %d)", sa.saName, sa.saOwnerID)
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SoDKind says how strictly two profiles are kept apart
+type SoDKind uint8
+
+const (
+	SoDNone SoDKind = iota
+	// SoDStatic profiles may never be held by the same principal
+	SoDStatic
+	// SoDDynamic profiles may be held together, but a principal may not
+	// draw on both within one session or request chain
+	SoDDynamic
+)
+
+func (k SoDKind) String() string {
+	switch k {
+	case SoDStatic:
+		return "static"
+	case SoDDynamic:
+		return "dynamic"
+	}
+	return "none"
+}
+
+// ErrSoDViolation matches every *SoDViolation through errors.Is
+var ErrSoDViolation = errors.New("separation of duties violation")
+
+// SoDViolation reports a principal that would hold, or use, two profiles
+// declared in conflict
+type SoDViolation struct {
+	Kind                 SoDKind
+	PrincipalID          uint64
+	ProfileID            uint64 // the profile being added or used
+	ConflictingProfileID uint64 // the profile already held or used
+}
+
+func (e *SoDViolation) Error() string {
+	if e.Kind == SoDDynamic {
+		return fmt.Sprintf("principal %d may not use profile %d after profile %d in the same session: %s",
+			e.PrincipalID, e.ProfileID, e.ConflictingProfileID, ErrSoDViolation)
+	}
+	return fmt.Sprintf("principal %d may not hold profile %d together with profile %d: %s",
+		e.PrincipalID, e.ProfileID, e.ConflictingProfileID, ErrSoDViolation)
+}
+
+func (e *SoDViolation) Is(target error) bool {
+	return target == ErrSoDViolation
+}
+
+// ConflictBetween returns the constraint between two profiles, as declared
+// on either of them
+func ConflictBetween(a, b *Profile) SoDKind {
+	if k := a.GetConflict(b.GetResourceID()); k != SoDNone {
+		return k
+	}
+	return b.GetConflict(a.GetResourceID())
+}
+
+// checkStaticSoD returns a violation if profile statically conflicts with
+// one of held
+func checkStaticSoD(principalID uint64, held []*Profile, profile *Profile) error {
+	for _, h := range held {
+		if ConflictBetween(h, profile) == SoDStatic {
+			return &SoDViolation{Kind: SoDStatic, PrincipalID: principalID, ProfileID: profile.GetResourceID(), ConflictingProfileID: h.GetResourceID()}
+		}
+	}
+	return nil
+}
@@ -19,20 +19,24 @@ type User struct {
 	userDeletedAt    time.Time
 	userEmail        string
 	userProfiles     []*Profile
+	userProfileEnds  map[uint64]time.Time // time-bound assignments by profile ID
+	userVersion      uint64
 	mux              sync.RWMutex
 }
 
 func (u *User) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		ID           uint64       `json:"user_id"`
-		Name         string       `json:"user_name"`
-		Description  string       `json:"user_description"`
-		ResourceType ResourceType `json:"user_resource_type"`
-		CreatedAt    time.Time    `json:"user_created_at"`
-		UpdatedAt    time.Time    `json:"user_updated_at"`
-		DeletedAt    time.Time    `json:"user_deleted_at"`
-		Email        string       `json:"user_email"`
-		Profiles     []*Profile   `json:"user_profiles"`
+		ID           uint64               `json:"user_id"`
+		Name         string               `json:"user_name"`
+		Description  string               `json:"user_description"`
+		ResourceType ResourceType         `json:"user_resource_type"`
+		CreatedAt    time.Time            `json:"user_created_at"`
+		UpdatedAt    time.Time            `json:"user_updated_at"`
+		DeletedAt    time.Time            `json:"user_deleted_at"`
+		Email        string               `json:"user_email"`
+		Profiles     []*Profile           `json:"user_profiles"`
+		ProfileEnds  map[uint64]time.Time `json:"user_profile_ends,omitempty"`
+		Version      uint64               `json:"user_resource_version"`
 	}{
 		ID:           u.userID,
 		Name:         u.userName,
@@ -43,6 +47,8 @@ func (u *User) MarshalJSON() ([]byte, error) {
 		DeletedAt:    u.userDeletedAt,
 		Email:        u.userEmail,
 		Profiles:     u.userProfiles,
+		ProfileEnds:  u.userProfileEnds,
+		Version:      u.GetResourceVersion(),
 	})
 }
 
@@ -74,6 +80,21 @@ func (u *User) GetResourceDeletedAt() time.Time {
 	return u.userDeletedAt
 }
 
+// GetResourceVersion returns the version stamped by the owning controller on
+// the last change; 0 means the user was never stored.
+func (u *User) GetResourceVersion() uint64 {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+	return u.userVersion
+}
+
+func (u *User) SetResourceVersion(version uint64) *User {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.userVersion = version
+	return u
+}
+
 func (u *User) IsActive() bool {
 	return u.userDeletedAt.IsZero()
 }
@@ -93,6 +114,14 @@ func NewUser(name string, description string, email string) *User {
 	return u
 }
 
+// NewUserWithID builds a user whose ID is already known, e.g. one being
+// loaded from a policy file.
+func NewUserWithID(id uint64, name string, description string, email string) *User {
+	u := NewUser(name, description, email)
+	u.userID = id
+	return u
+}
+
 func (u *User) GetProfiles() []Profile {
 	u.mux.RLock()
 	defer u.mux.RUnlock()
@@ -125,16 +154,77 @@ func (u *User) SoftDelete() *User {
 	return u
 }
 
-func (u *User) AddProfile(profile *Profile) *User {
+// AddProfile grants profile, unless it statically conflicts with a
+// profile already held; that returns a *SoDViolation and changes nothing.
+// A profile already held stays held once and no longer ends.
+func (u *User) AddProfile(profile *Profile) (*User, error) {
 	u.mux.Lock()
 	defer u.mux.Unlock()
+	if err := u.addProfile(profile); err != nil {
+		return u, err
+	}
+	delete(u.userProfileEnds, profile.GetResourceID())
+	return u, nil
+}
+
+// AddProfileUntil is AddProfile for an assignment that ends at until.
+// GetProfilesAt leaves the profile out from then on. For a profile already
+// held it only sets the end.
+func (u *User) AddProfileUntil(profile *Profile, until time.Time) (*User, error) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if err := u.addProfile(profile); err != nil {
+		return u, err
+	}
+	if u.userProfileEnds == nil {
+		u.userProfileEnds = make(map[uint64]time.Time)
+	}
+	u.userProfileEnds[profile.GetResourceID()] = until
+	return u, nil
+}
+
+// addProfile appends profile unless it is held or conflicts. Callers hold
+// the lock.
+func (u *User) addProfile(profile *Profile) error {
+	for _, p := range u.userProfiles {
+		if p.GetResourceID() == profile.GetResourceID() {
+			return nil
+		}
+	}
+	if err := checkStaticSoD(u.userID, u.userProfiles, profile); err != nil {
+		return err
+	}
 	u.userProfiles = append(u.userProfiles, profile)
-	return u
+	return nil
+}
+
+// GetProfileEnd returns when the assignment of profileID ends, zero if it
+// does not
+func (u *User) GetProfileEnd(profileID uint64) time.Time {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+	return u.userProfileEnds[profileID]
+}
+
+// GetProfilesAt is GetProfiles without the time-bound assignments that have
+// ended by t
+func (u *User) GetProfilesAt(t time.Time) []Profile {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+	userProfiles := []Profile{}
+	for _, profile := range u.userProfiles {
+		if end, ok := u.userProfileEnds[profile.GetResourceID()]; ok && !t.Before(end) {
+			continue
+		}
+		userProfiles = append(userProfiles, *profile)
+	}
+	return userProfiles
 }
 
 func (u *User) RemoveProfile(profile *Profile) *User {
 	u.mux.Lock()
 	defer u.mux.Unlock()
+	delete(u.userProfileEnds, profile.GetResourceID())
 	for i, p := range u.userProfiles {
 		if p.GetResourceID() == profile.GetResourceID() {
 			u.userProfiles = append(u.userProfiles[:i], u.userProfiles[i+1:]...)
@@ -144,6 +234,17 @@ func (u *User) RemoveProfile(profile *Profile) *User {
 	return u
 }
 
+func (u *User) HasProfile(profileID uint64) bool {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+	for _, p := range u.userProfiles {
+		if p.GetResourceID() == profileID {
+			return true
+		}
+	}
+	return false
+}
+
 func (u *User) GetEmail() string {
 	return u.userEmail
 }
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUser_ReassignedProfileIsHeldOnce(t *testing.T) {
+	u := NewUser("John", "User", "john@example.com")
+	p := NewProfile("readers", "read")
+	now := time.Now()
+
+	u.AddProfile(p)
+	u.AddProfileUntil(p, now.Add(time.Hour))
+	if got := len(u.GetProfiles()); got != 1 {
+		t.Fatalf("Expected the profile held once, got %d", got)
+	}
+	if got := len(u.GetProfilesAt(now.Add(2 * time.Hour))); got != 0 {
+		t.Errorf("Expected the time-bound profile to end, got %d profiles", got)
+	}
+
+	// Assigning it again for good clears the end
+	u.AddProfile(p)
+	if !u.GetProfileEnd(p.GetResourceID()).IsZero() {
+		t.Errorf("Expected no end after AddProfile")
+	}
+	if got := len(u.GetProfilesAt(now.Add(2 * time.Hour))); got != 1 {
+		t.Errorf("Expected the profile to be held after the old end, got %d profiles", got)
+	}
+}
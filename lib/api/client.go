@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib/policy"
+)
+
+// Client talks to a Server over HTTP
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client for the API rooted at baseURL, e.g.
+// "http://127.0.0.1:8080".
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is a non-2xx answer from the server
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// DecisionResponse is the body returned by POST /v1/authorize
+type DecisionResponse struct {
+	Allowed   bool   `json:"allowed"`
+	Effect    string `json:"effect"`
+	RuleID    uint64 `json:"rule_id"`
+	ProfileID uint64 `json:"profile_id"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error"`
+}
+
+// Authorize asks the server's Gatekeeper for a decision
+func (c *Client) Authorize(req AuthorizeRequest) (*DecisionResponse, error) {
+	var decision DecisionResponse
+	if err := c.do(http.MethodPost, "/v1/authorize", req, &decision); err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// Export reads every active rule, profile and user from the server
+func (c *Client) Export() (*policy.Document, error) {
+	doc := &policy.Document{Rules: []policy.RuleSpec{}, Profiles: []policy.ProfileSpec{}, Users: []policy.UserSpec{}}
+
+	err := c.list("/v1/rules", func(raw json.RawMessage) error {
+		var status struct {
+			DeletedAt time.Time `json:"deleted_at"`
+		}
+		var spec policy.RuleSpec
+		if err := json.Unmarshal(raw, &status); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &spec); err != nil {
+			return err
+		}
+		if status.DeletedAt.IsZero() {
+			doc.Rules = append(doc.Rules, spec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.list("/v1/profiles", func(raw json.RawMessage) error {
+		var p wireProfile
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return err
+		}
+		if p.DeletedAt.IsZero() {
+			doc.Profiles = append(doc.Profiles, p.spec())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.list("/v1/users", func(raw json.RawMessage) error {
+		var u struct {
+			ID          uint64        `json:"user_id"`
+			Name        string        `json:"user_name"`
+			Description string        `json:"user_description"`
+			Email       string        `json:"user_email"`
+			DeletedAt   time.Time     `json:"user_deleted_at"`
+			Profiles    []wireProfile `json:"user_profiles"`
+		}
+		if err := json.Unmarshal(raw, &u); err != nil {
+			return err
+		}
+		if !u.DeletedAt.IsZero() {
+			return nil
+		}
+		us := policy.UserSpec{ID: u.ID, Name: u.Name, Description: u.Description, Email: u.Email, ProfileIDs: []uint64{}}
+		for _, p := range u.Profiles {
+			if p.DeletedAt.IsZero() {
+				us.ProfileIDs = append(us.ProfileIDs, p.ID)
+			}
+		}
+		doc.Users = append(doc.Users, us)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Import creates every entity in doc on the server, keeping IDs, then
+// attaches rules and assigns profiles. Unlike policy.Load this is not atomic:
+// it stops at the first error and leaves what was created in place.
+func (c *Client) Import(doc *policy.Document) error {
+	for _, rs := range doc.Rules {
+		req := RuleRequest{
+			ID:                 rs.ID,
+			Name:               rs.Name,
+			Description:        rs.Description,
+			TargetResourceType: rs.TargetResourceType.String(),
+			TargetResourceID:   rs.TargetResourceID,
+			Verb:               core.FormatVerb(rs.Verb),
+			Action:             rs.Action.String(),
+			ForwardRuleID:      rs.ForwardRuleID,
+		}
+		if err := c.do(http.MethodPost, "/v1/rules", req, nil); err != nil {
+			return fmt.Errorf("rule %q: %w", rs.Name, err)
+		}
+	}
+	for _, ps := range doc.Profiles {
+		if err := c.do(http.MethodPost, "/v1/profiles", ProfileRequest{ID: ps.ID, Name: ps.Name, Description: ps.Description}, nil); err != nil {
+			return fmt.Errorf("profile %q: %w", ps.Name, err)
+		}
+		for _, ruleID := range ps.RuleIDs {
+			if err := c.do(http.MethodPut, fmt.Sprintf("/v1/profiles/%d/rules/%d", ps.ID, ruleID), nil, nil); err != nil {
+				return fmt.Errorf("profile %q: %w", ps.Name, err)
+			}
+		}
+	}
+	for _, us := range doc.Users {
+		if err := c.do(http.MethodPost, "/v1/users", UserRequest{ID: us.ID, Name: us.Name, Description: us.Description, Email: us.Email}, nil); err != nil {
+			return fmt.Errorf("user %q: %w", us.Name, err)
+		}
+		for _, profileID := range us.ProfileIDs {
+			if err := c.do(http.MethodPut, fmt.Sprintf("/v1/users/%d/profiles/%d", us.ID, profileID), nil, nil); err != nil {
+				return fmt.Errorf("user %q: %w", us.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// wireProfile is the subset of core.Profile JSON the client needs
+type wireProfile struct {
+	ID          uint64                       `json:"profile_id"`
+	Name        string                       `json:"profile_name"`
+	Description string                       `json:"profile_description"`
+	DeletedAt   time.Time                    `json:"profile_deleted_at"`
+	RuleMap     map[string][]json.RawMessage `json:"profile_rule_map"`
+}
+
+func (p wireProfile) spec() policy.ProfileSpec {
+	ps := policy.ProfileSpec{ID: p.ID, Name: p.Name, Description: p.Description, RuleIDs: []uint64{}}
+	for _, rules := range p.RuleMap {
+		for _, raw := range rules {
+			var rule struct {
+				ID        uint64    `json:"id"`
+				DeletedAt time.Time `json:"deleted_at"`
+			}
+			if json.Unmarshal(raw, &rule) == nil && rule.DeletedAt.IsZero() {
+				ps.RuleIDs = append(ps.RuleIDs, rule.ID)
+			}
+		}
+	}
+	return ps
+}
+
+// list walks every page of a collection
+func (c *Client) list(path string, each func(json.RawMessage) error) error {
+	token := ""
+	for {
+		var page ListResponse[json.RawMessage]
+		query := url.Values{"limit": {strconv.Itoa(maxPageSize)}}
+		if token != "" {
+			query.Set("continue", token)
+		}
+		if err := c.do(http.MethodGet, path+"?"+query.Encode(), nil, &page); err != nil {
+			return err
+		}
+		for _, raw := range page.Items {
+			if err := each(raw); err != nil {
+				return err
+			}
+		}
+		if page.Continue == "" {
+			return nil
+		}
+		token = page.Continue
+	}
+}
+
+func (c *Client) do(method, path string, body, out any) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, &payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var errBody ErrorBody
+		json.NewDecoder(resp.Body).Decode(&errBody)
+		return &Error{Status: resp.StatusCode, Code: errBody.Error.Code, Message: errBody.Error.Message}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,764 @@
+// Package api serves a versioned JSON REST API for managing users, profiles,
+// rules, delegated grants and access requests held by a controller, plus an
+// authorization decision endpoint backed by the Gatekeeper.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/controllers"
+	"github.com/farhansabbir/rbac/lib/middleware"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// ErrUnauthenticated is answered with a 401 by endpoints that act on the
+// caller's behalf when the caller's principal is unknown, see WithPrincipal
+var ErrUnauthenticated = errors.New("no authenticated principal")
+
+// Server routes /v1 requests to a controller and a Gatekeeper
+type Server struct {
+	ctrl      *controllers.Controller
+	gk        *lib.Gatekeeper
+	mux       *http.ServeMux
+	principal middleware.PrincipalExtractor
+}
+
+// Option configures a Server built by NewServer
+type Option func(*Server)
+
+// WithPrincipal names the principal making each request, for endpoints
+// that act on the caller's behalf, e.g. creating a grant. Behind
+// middleware.Middleware the principal it authorized is used instead.
+// Without either those endpoints answer 401.
+func WithPrincipal(extract middleware.PrincipalExtractor) Option {
+	return func(s *Server) {
+		s.principal = extract
+	}
+}
+
+// NewServer builds the API over ctrl. gk should evaluate against ctrl, e.g.
+// lib.NewGatekeeper(lib.WithStore(ctrl)).
+func NewServer(ctrl *controllers.Controller, gk *lib.Gatekeeper, opts ...Option) *Server {
+	s := &Server{ctrl: ctrl, gk: gk, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("GET /v1/users", s.listUsers)
+	s.mux.HandleFunc("POST /v1/users", s.createUser)
+	s.mux.HandleFunc("GET /v1/users/{id}", s.getUser)
+	s.mux.HandleFunc("PUT /v1/users/{id}", s.updateUser)
+	s.mux.HandleFunc("DELETE /v1/users/{id}", s.deleteUser)
+	s.mux.HandleFunc("PUT /v1/users/{id}/profiles/{profileID}", s.assignProfile)
+	s.mux.HandleFunc("DELETE /v1/users/{id}/profiles/{profileID}", s.unassignProfile)
+
+	s.mux.HandleFunc("GET /v1/profiles", s.listProfiles)
+	s.mux.HandleFunc("POST /v1/profiles", s.createProfile)
+	s.mux.HandleFunc("GET /v1/profiles/{id}", s.getProfile)
+	s.mux.HandleFunc("PUT /v1/profiles/{id}", s.updateProfile)
+	s.mux.HandleFunc("DELETE /v1/profiles/{id}", s.deleteProfile)
+	s.mux.HandleFunc("PUT /v1/profiles/{id}/rules/{ruleID}", s.attachRule)
+	s.mux.HandleFunc("DELETE /v1/profiles/{id}/rules/{ruleID}", s.detachRule)
+
+	s.mux.HandleFunc("GET /v1/rules", s.listRules)
+	s.mux.HandleFunc("POST /v1/rules", s.createRule)
+	s.mux.HandleFunc("GET /v1/rules/{id}", s.getRule)
+	s.mux.HandleFunc("PUT /v1/rules/{id}", s.updateRule)
+	s.mux.HandleFunc("DELETE /v1/rules/{id}", s.deleteRule)
+
+	s.mux.HandleFunc("GET /v1/grants", s.listGrants)
+	s.mux.HandleFunc("POST /v1/grants", s.createGrant)
+	s.mux.HandleFunc("GET /v1/grants/{id}", s.getGrant)
+	s.mux.HandleFunc("DELETE /v1/grants/{id}", s.revokeGrant)
+
+	s.mux.HandleFunc("GET /v1/access-requests", s.listAccessRequests)
+	s.mux.HandleFunc("POST /v1/access-requests", s.createAccessRequest)
+	s.mux.HandleFunc("GET /v1/access-requests/{id}", s.getAccessRequest)
+	s.mux.HandleFunc("POST /v1/access-requests/{id}/approve", s.decideAccessRequest)
+	s.mux.HandleFunc("POST /v1/access-requests/{id}/reject", s.decideAccessRequest)
+	s.mux.HandleFunc("POST /v1/access-requests/{id}/revoke", s.decideAccessRequest)
+
+	s.mux.HandleFunc("POST /v1/authorize", s.authorize)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Routes is the middleware route table for the endpoints that change users,
+// profiles and rules. Each is checked as the verb its method implies on the
+// user, profile or rule it changes; assigning profiles and attaching rules
+// update the user or profile. The server does not check these itself, so
+// wrap it:
+//
+//	authz, _ := middleware.New(gk, extract, api.Routes(), middleware.WithUnmatchedAllowed())
+//	handler := authz.Wrap(api.NewServer(ctrl, gk, api.WithPrincipal(extract)))
+//
+// The other endpoints only read, or act as the caller and check it
+// themselves, so they are left to pass unmatched.
+func Routes() []middleware.Route {
+	return []middleware.Route{
+		{Method: http.MethodPost, Pattern: "/v1/users", ResourceType: core.ResourceTypeUser},
+		{Method: http.MethodPut, Pattern: "/v1/users/{id}", ResourceType: core.ResourceTypeUser, IDParam: "id"},
+		{Method: http.MethodDelete, Pattern: "/v1/users/{id}", ResourceType: core.ResourceTypeUser, IDParam: "id"},
+		{Method: http.MethodPut, Pattern: "/v1/users/{id}/profiles/{profileID}", ResourceType: core.ResourceTypeUser, IDParam: "id", Verb: core.VerbUpdate},
+		{Method: http.MethodDelete, Pattern: "/v1/users/{id}/profiles/{profileID}", ResourceType: core.ResourceTypeUser, IDParam: "id", Verb: core.VerbUpdate},
+
+		{Method: http.MethodPost, Pattern: "/v1/profiles", ResourceType: core.ResourceTypeProfile},
+		{Method: http.MethodPut, Pattern: "/v1/profiles/{id}", ResourceType: core.ResourceTypeProfile, IDParam: "id"},
+		{Method: http.MethodDelete, Pattern: "/v1/profiles/{id}", ResourceType: core.ResourceTypeProfile, IDParam: "id"},
+		{Method: http.MethodPut, Pattern: "/v1/profiles/{id}/rules/{ruleID}", ResourceType: core.ResourceTypeProfile, IDParam: "id", Verb: core.VerbUpdate},
+		{Method: http.MethodDelete, Pattern: "/v1/profiles/{id}/rules/{ruleID}", ResourceType: core.ResourceTypeProfile, IDParam: "id", Verb: core.VerbUpdate},
+
+		{Method: http.MethodPost, Pattern: "/v1/rules", ResourceType: core.ResourceTypeRule},
+		{Method: http.MethodPut, Pattern: "/v1/rules/{id}", ResourceType: core.ResourceTypeRule, IDParam: "id"},
+		{Method: http.MethodDelete, Pattern: "/v1/rules/{id}", ResourceType: core.ResourceTypeRule, IDParam: "id"},
+	}
+}
+
+// --- Wire types ---
+
+// UserRequest is the body of POST and PUT /v1/users. ID may be set on POST
+// to keep an ID from elsewhere, e.g. an imported policy; 0 derives it.
+type UserRequest struct {
+	ID              uint64 `json:"id,omitempty"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	Email           string `json:"email"`
+	ResourceVersion uint64 `json:"resource_version,omitempty"` // PUT only; 0 skips the conflict check
+}
+
+// ProfileRequest is the body of POST and PUT /v1/profiles
+type ProfileRequest struct {
+	ID              uint64 `json:"id,omitempty"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	ResourceVersion uint64 `json:"resource_version,omitempty"`
+}
+
+// RuleRequest is the body of POST and PUT /v1/rules. Verbs use
+// core.FormatVerb syntax, e.g. "read|list" or "*".
+type RuleRequest struct {
+	ID                 uint64 `json:"id,omitempty"`
+	Name               string `json:"name"`
+	Description        string `json:"description"`
+	TargetResourceType string `json:"target_resource_type"`
+	TargetResourceID   string `json:"target_resource_id"`
+	Verb               string `json:"verb"`
+	Action             string `json:"action"`
+	ForwardRuleID      uint64 `json:"forward_rule_id,omitempty"`
+	ResourceVersion    uint64 `json:"resource_version,omitempty"`
+}
+
+// AuthorizeRequest is the body of POST /v1/authorize
+type AuthorizeRequest struct {
+	PrincipalID  uint64         `json:"principal_id"`
+	ResourceType string         `json:"resource_type"`
+	ResourceID   uint64         `json:"resource_id"`
+	Verb         string         `json:"verb"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	// CorrelationID is recorded in the audit log; it defaults to the
+	// X-Request-Id header
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// ImpersonatorID asks for the decision as if ImpersonatorID were acting
+	// as PrincipalID
+	ImpersonatorID uint64 `json:"impersonator_id,omitempty"`
+}
+
+// GrantRequest is the body of POST /v1/grants. The grantor is the caller,
+// who must hold every verb on the target; see
+// controllers.DelegationController.Delegate.
+type GrantRequest struct {
+	GranteeID          uint64    `json:"grantee_id"`
+	TargetResourceType string    `json:"target_resource_type"`
+	TargetResourceID   string    `json:"target_resource_id"`
+	Verb               string    `json:"verb"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+// AccessRequestBody is the body of POST /v1/access-requests, made for the
+// caller. Duration uses time.ParseDuration syntax, e.g. "8h".
+type AccessRequestBody struct {
+	ProfileID     uint64 `json:"profile_id"`
+	Justification string `json:"justification"`
+	Duration      string `json:"duration"`
+}
+
+// ListResponse wraps one page of a collection. Pass Continue back as the
+// continue query parameter to fetch the next page; it is empty on the last.
+type ListResponse[T any] struct {
+	Items           []T    `json:"items"`
+	Continue        string `json:"continue,omitempty"`
+	ResourceVersion uint64 `json:"resource_version"`
+}
+
+// ErrorBody is returned with every non-2xx status
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// --- Users ---
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, s.ctrl.GetUserController().ListUsers(), s.ctrl.ResourceVersion())
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var req UserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	user := core.NewUser(req.Name, req.Description, req.Email)
+	if req.ID != 0 {
+		user = core.NewUserWithID(req.ID, req.Name, req.Description, req.Email)
+	}
+	err := s.ctrl.Tx(func(tx *controllers.Tx) error {
+		return tx.InsertUser(user)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/users/%d", user.GetResourceID()))
+	writeJSON(w, http.StatusCreated, user)
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	user := s.ctrl.GetUserController().GetUser(id)
+	if user == nil {
+		writeError(w, fmt.Errorf("User with ID %d %w", id, controllers.ErrNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req UserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	user, err := s.ctrl.GetUserController().UpdateUser(id, req.ResourceVersion, req.Name, req.Description, req.Email)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	s.txByID(w, r, func(tx *controllers.Tx, id uint64) error { return tx.DeleteUser(id) })
+}
+
+func (s *Server) assignProfile(w http.ResponseWriter, r *http.Request) {
+	s.txByIDs(w, r, "profileID", func(tx *controllers.Tx, id, profileID uint64) error {
+		return tx.AssignProfile(id, profileID)
+	})
+}
+
+func (s *Server) unassignProfile(w http.ResponseWriter, r *http.Request) {
+	s.txByIDs(w, r, "profileID", func(tx *controllers.Tx, id, profileID uint64) error {
+		return tx.UnassignProfile(id, profileID)
+	})
+}
+
+// --- Profiles ---
+
+func (s *Server) listProfiles(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, s.ctrl.GetProfileController().ListProfiles(), s.ctrl.ResourceVersion())
+}
+
+func (s *Server) createProfile(w http.ResponseWriter, r *http.Request) {
+	var req ProfileRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	profile := core.NewProfile(req.Name, req.Description)
+	if req.ID != 0 {
+		profile = core.NewProfileWithID(req.ID, req.Name, req.Description)
+	}
+	err := s.ctrl.Tx(func(tx *controllers.Tx) error {
+		return tx.InsertProfile(profile)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/profiles/%d", profile.GetResourceID()))
+	writeJSON(w, http.StatusCreated, profile)
+}
+
+func (s *Server) getProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	profile := s.ctrl.GetProfileController().GetProfile(id)
+	if profile == nil {
+		writeError(w, fmt.Errorf("Profile with ID %d %w", id, controllers.ErrNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (s *Server) updateProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req ProfileRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	profile, err := s.ctrl.GetProfileController().UpdateProfile(id, req.ResourceVersion, req.Name, req.Description)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (s *Server) deleteProfile(w http.ResponseWriter, r *http.Request) {
+	s.txByID(w, r, func(tx *controllers.Tx, id uint64) error { return tx.DeleteProfile(id) })
+}
+
+func (s *Server) attachRule(w http.ResponseWriter, r *http.Request) {
+	s.txByIDs(w, r, "ruleID", func(tx *controllers.Tx, id, ruleID uint64) error {
+		return tx.AddRuleToProfile(id, ruleID)
+	})
+}
+
+func (s *Server) detachRule(w http.ResponseWriter, r *http.Request) {
+	s.txByIDs(w, r, "ruleID", func(tx *controllers.Tx, id, ruleID uint64) error {
+		return tx.RemoveRuleFromProfile(id, ruleID)
+	})
+}
+
+// --- Rules ---
+
+func (s *Server) listRules(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, s.ctrl.GetRuleController().ListRules(), s.ctrl.ResourceVersion())
+}
+
+func (s *Server) createRule(w http.ResponseWriter, r *http.Request) {
+	var req RuleRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	rule := core.NewRule(req.Name, req.Description, req.TargetResourceID, 0, core.ActionDeny)
+	if req.ID != 0 {
+		rule = core.NewRuleWithID(req.ID, req.Name, req.Description)
+	}
+	if err := applyRuleRequest(rule, req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.ctrl.GetRuleController().CreateRule(rule); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/rules/%d", rule.GetResourceID()))
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func (s *Server) getRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	rule := s.ctrl.GetRuleController().GetRule(id)
+	if rule == nil {
+		writeError(w, fmt.Errorf("Rule with ID %d %w", id, controllers.ErrNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (s *Server) updateRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	var req RuleRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	rule, err := s.ctrl.GetRuleController().UpdateRule(id, req.ResourceVersion, func(rule *core.Rule) error {
+		return applyRuleRequest(rule, req)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (s *Server) deleteRule(w http.ResponseWriter, r *http.Request) {
+	s.txByID(w, r, func(tx *controllers.Tx, id uint64) error { return tx.DeleteRule(id) })
+}
+
+// applyRuleRequest sets every field of req on rule
+func applyRuleRequest(rule *core.Rule, req RuleRequest) error {
+	targetType, err := core.ParseResourceType(req.TargetResourceType)
+	if err != nil {
+		return err
+	}
+	verb, err := core.ParseVerb(req.Verb)
+	if err != nil {
+		return err
+	}
+	action, err := core.ParseAction(req.Action)
+	if err != nil {
+		return err
+	}
+
+	rule.Update(req.Name, req.Description, req.TargetResourceID, verb, action)
+	if _, err := rule.UpdateAction(core.ActionOption{Action: action, NextRuleID: req.ForwardRuleID}); err != nil {
+		return err
+	}
+	_, err = rule.SetTargetResourceTypeAndID(targetType, req.TargetResourceID)
+	return err
+}
+
+// --- Grants ---
+
+func (s *Server) listGrants(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, s.ctrl.GetDelegationController().ListGrants(), s.ctrl.ResourceVersion())
+}
+
+func (s *Server) createGrant(w http.ResponseWriter, r *http.Request) {
+	grantorID, err := s.caller(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req GrantRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	targetType, err := core.ParseResourceType(req.TargetResourceType)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	verb, err := core.ParseVerb(req.Verb)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	grant, err := s.ctrl.GetDelegationController().Delegate(grantorID, req.GranteeID, targetType, req.TargetResourceID, verb, req.ExpiresAt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/grants/%d", grant.GetResourceID()))
+	writeJSON(w, http.StatusCreated, grant)
+}
+
+func (s *Server) getGrant(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	grant := s.ctrl.GetDelegationController().GetGrant(id)
+	if grant == nil {
+		writeError(w, fmt.Errorf("%s with ID %d %w", core.ResourceTypeGrant, id, controllers.ErrNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, grant)
+}
+
+// revokeGrant revokes on behalf of the caller, see
+// controllers.DelegationController.RevokeGrantAs
+func (s *Server) revokeGrant(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	revokerID, err := s.caller(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.ctrl.GetDelegationController().RevokeGrantAs(revokerID, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Access requests ---
+
+func (s *Server) listAccessRequests(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, s.ctrl.GetAccessRequestController().ListAccessRequests(), s.ctrl.ResourceVersion())
+}
+
+func (s *Server) createAccessRequest(w http.ResponseWriter, r *http.Request) {
+	requesterID, err := s.caller(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req AccessRequestBody
+	if !decode(w, r, &req) {
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ar, err := s.ctrl.GetAccessRequestController().RequestAccess(requesterID, req.ProfileID, req.Justification, duration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/access-requests/%d", ar.GetResourceID()))
+	writeJSON(w, http.StatusCreated, ar)
+}
+
+func (s *Server) getAccessRequest(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	ar := s.ctrl.GetAccessRequestController().GetAccessRequest(id)
+	if ar == nil {
+		writeError(w, fmt.Errorf("%s with ID %d %w", core.ResourceTypeAccessRequest, id, controllers.ErrNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, ar)
+}
+
+// decideAccessRequest serves approve, reject and revoke, told apart by the
+// last path segment, on behalf of the caller
+func (s *Server) decideAccessRequest(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	approverID, err := s.caller(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	arc := s.ctrl.GetAccessRequestController()
+	decide := arc.Approve
+	switch path.Base(r.URL.Path) {
+	case "reject":
+		decide = arc.Reject
+	case "revoke":
+		decide = arc.Revoke
+	}
+	ar, err := decide(id, approverID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ar)
+}
+
+// --- Authorization ---
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	var req AuthorizeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	resourceType, err := core.ParseResourceType(req.ResourceType)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	verb, err := core.ParseVerb(req.Verb)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	correlationID := req.CorrelationID
+	if correlationID == "" {
+		correlationID = r.Header.Get("X-Request-Id")
+	}
+
+	decision := s.gk.DecideContext(r.Context(), &lib.RequestContext{
+		PrincipalID:         req.PrincipalID,
+		RequestResourceType: resourceType,
+		RequestResourceID:   req.ResourceID,
+		RequestVerb:         verb,
+		Attributes:          req.Attributes,
+		ContextDT:           time.Now(),
+		CorrelationID:       correlationID,
+		ImpersonatorID:      req.ImpersonatorID,
+	})
+	writeJSON(w, http.StatusOK, decision)
+}
+
+// --- Helpers ---
+
+// caller returns the principal making r: the one middleware.Middleware let
+// through, or else the one WithPrincipal names
+func (s *Server) caller(r *http.Request) (uint64, error) {
+	if decision, ok := middleware.DecisionFromContext(r.Context()); ok {
+		return decision.PrincipalID, nil
+	}
+	if s.principal == nil {
+		return 0, ErrUnauthenticated
+	}
+	id, err := s.principal(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return id, nil
+}
+
+func (s *Server) txByID(w http.ResponseWriter, r *http.Request, fn func(tx *controllers.Tx, id uint64) error) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := s.ctrl.Tx(func(tx *controllers.Tx) error { return fn(tx, id) }); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) txByIDs(w http.ResponseWriter, r *http.Request, second string, fn func(tx *controllers.Tx, id, other uint64) error) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	other, ok := pathID(w, r, second)
+	if !ok {
+		return
+	}
+	if err := s.ctrl.Tx(func(tx *controllers.Tx) error { return fn(tx, id, other) }); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeList sorts items by ID and writes the page selected by the limit and
+// continue query parameters.
+func writeList[T core.Resource](w http.ResponseWriter, r *http.Request, items []T, resourceVersion uint64) {
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeStatus(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid limit %q", v))
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+	var after uint64
+	if v := r.URL.Query().Get("continue"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeStatus(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid continue token %q", v))
+			return
+		}
+		after = n
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].GetResourceID() < items[j].GetResourceID() })
+	start := sort.Search(len(items), func(i int) bool { return items[i].GetResourceID() > after })
+	end := min(start+limit, len(items))
+
+	page := ListResponse[T]{Items: items[start:end], ResourceVersion: resourceVersion}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if end < len(items) {
+		page.Continue = strconv.FormatUint(items[end-1].GetResourceID(), 10)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, name string) (uint64, bool) {
+	id, err := strconv.ParseUint(r.PathValue(name), 10, 64)
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid %s %q", name, r.PathValue(name)))
+		return 0, false
+	}
+	return id, true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeStatus(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps controller errors onto HTTP statuses
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		writeStatus(w, http.StatusUnauthorized, "unauthorized", err.Error())
+	case errors.Is(err, controllers.ErrNotFound):
+		writeStatus(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, controllers.ErrConflict):
+		writeStatus(w, http.StatusConflict, "conflict", err.Error())
+	case errors.Is(err, controllers.ErrAlreadyExists):
+		writeStatus(w, http.StatusConflict, "already_exists", err.Error())
+	case errors.Is(err, controllers.ErrInactive):
+		writeStatus(w, http.StatusUnprocessableEntity, "inactive", err.Error())
+	case errors.Is(err, controllers.ErrNotDelegable), errors.Is(err, controllers.ErrDelegationDepth):
+		writeStatus(w, http.StatusForbidden, "not_delegable", err.Error())
+	case errors.Is(err, controllers.ErrNotRevoker):
+		writeStatus(w, http.StatusForbidden, "not_revoker", err.Error())
+	case errors.Is(err, controllers.ErrNotApprover):
+		writeStatus(w, http.StatusForbidden, "not_approver", err.Error())
+	case errors.Is(err, controllers.ErrInvalidState):
+		writeStatus(w, http.StatusConflict, "invalid_state", err.Error())
+	case errors.Is(err, controllers.ErrBreakGlassDenied):
+		writeStatus(w, http.StatusForbidden, "break_glass_denied", err.Error())
+	case errors.Is(err, controllers.ErrAcknowledgementRequired):
+		writeStatus(w, http.StatusConflict, "acknowledgement_required", err.Error())
+	case errors.Is(err, core.ErrSoDViolation):
+		writeStatus(w, http.StatusConflict, "sod_violation", err.Error())
+	default:
+		writeStatus(w, http.StatusBadRequest, "bad_request", err.Error())
+	}
+}
+
+func writeStatus(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorBody{Error: ErrorDetail{Code: code, Message: message}})
+}
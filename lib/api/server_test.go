@@ -0,0 +1,316 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/controllers"
+	"github.com/farhansabbir/rbac/lib/middleware"
+)
+
+// principalHeader names the caller in tests, see doAs
+const principalHeader = "X-Principal"
+
+func headerPrincipal(r *http.Request) (uint64, error) {
+	return strconv.ParseUint(r.Header.Get(principalHeader), 10, 64)
+}
+
+func newTestServer() *httptest.Server {
+	ctrl := controllers.New()
+	return httptest.NewServer(NewServer(ctrl, lib.NewGatekeeper(lib.WithStore(ctrl)), WithPrincipal(headerPrincipal)))
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path string, body any, out any) int {
+	t.Helper()
+	return doAs(t, srv, 0, method, path, body, out)
+}
+
+// doAs makes the request as principal; 0 leaves the caller unauthenticated
+func doAs(t *testing.T, srv *httptest.Server, principal uint64, method, path string, body any, out any) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, srv.URL+path, &buf)
+	if principal != 0 {
+		req.Header.Set(principalHeader, strconv.FormatUint(principal, 10))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func TestServer_OnboardAndAuthorize(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	var user, profile, rule struct {
+		UserID    uint64 `json:"user_id"`
+		ProfileID uint64 `json:"profile_id"`
+		ID        uint64 `json:"id"`
+	}
+	if code := do(t, srv, "POST", "/v1/users", UserRequest{Name: "John", Email: "john@example.com"}, &user); code != http.StatusCreated {
+		t.Fatalf("create user: %d", code)
+	}
+	do(t, srv, "POST", "/v1/profiles", ProfileRequest{Name: "readers"}, &profile)
+	code := do(t, srv, "POST", "/v1/rules", RuleRequest{
+		Name: "read-projects", TargetResourceType: "Project", TargetResourceID: "*", Verb: "read|list", Action: "allow",
+	}, &rule)
+	if code != http.StatusCreated {
+		t.Fatalf("create rule: %d", code)
+	}
+
+	if code := do(t, srv, "PUT", fmt.Sprintf("/v1/profiles/%d/rules/%d", profile.ProfileID, rule.ID), nil, nil); code != http.StatusNoContent {
+		t.Fatalf("attach rule: %d", code)
+	}
+	if code := do(t, srv, "PUT", fmt.Sprintf("/v1/users/%d/profiles/%d", user.UserID, profile.ProfileID), nil, nil); code != http.StatusNoContent {
+		t.Fatalf("assign profile: %d", code)
+	}
+
+	var decision struct {
+		Allowed bool   `json:"allowed"`
+		RuleID  uint64 `json:"rule_id"`
+	}
+	do(t, srv, "POST", "/v1/authorize", AuthorizeRequest{PrincipalID: user.UserID, ResourceType: "Project", ResourceID: 42, Verb: "list"}, &decision)
+	if !decision.Allowed || decision.RuleID != rule.ID {
+		t.Errorf("Expected ALLOW by rule %d, got %+v", rule.ID, decision)
+	}
+	do(t, srv, "POST", "/v1/authorize", AuthorizeRequest{PrincipalID: user.UserID, ResourceType: "Project", ResourceID: 42, Verb: "delete"}, &decision)
+	if decision.Allowed {
+		t.Errorf("Expected DENY for delete")
+	}
+}
+
+func TestServer_RoutesGuardMutations(t *testing.T) {
+	ctrl := controllers.New()
+	var admin, member *core.User
+	err := ctrl.Tx(func(tx *controllers.Tx) error {
+		admin, _ = tx.CreateUser("Ada", "Admin", "ada@example.com")
+		member, _ = tx.CreateUser("Max", "Member", "max@example.com")
+		admins, _ := tx.CreateProfile("admins", "")
+		everything := core.NewEmptyRule("everything")
+		everything.UpdateVerb(core.VerbAll)
+		everything.SetTargetResourceTypeAndID(core.ResourceTypeAll, core.ResourceIDAll)
+		everything.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+		tx.CreateRule(everything)
+		tx.AddRuleToProfile(admins.GetResourceID(), everything.GetResourceID())
+		return tx.AssignProfile(admin.GetResourceID(), admins.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	authz, err := middleware.New(gk, headerPrincipal, Routes(), middleware.WithUnmatchedAllowed())
+	if err != nil {
+		t.Fatalf("middleware.New: %v", err)
+	}
+	srv := httptest.NewServer(authz.Wrap(NewServer(ctrl, gk, WithPrincipal(headerPrincipal))))
+	defer srv.Close()
+
+	create := UserRequest{Name: "Eve", Email: "eve@example.com"}
+	if code := do(t, srv, "POST", "/v1/users", create, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an anonymous create, got %d", code)
+	}
+	if code := doAs(t, srv, member.GetResourceID(), "POST", "/v1/users", create, nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a create the caller is not allowed, got %d", code)
+	}
+	assign := fmt.Sprintf("/v1/users/%d/profiles/%d", member.GetResourceID(), admin.GetProfiles()[0].GetResourceID())
+	if code := doAs(t, srv, member.GetResourceID(), "PUT", assign, nil, nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for self-assigning a profile, got %d", code)
+	}
+	if member.HasProfile(admin.GetProfiles()[0].GetResourceID()) {
+		t.Errorf("Expected the forbidden assignment not to happen")
+	}
+
+	if code := doAs(t, srv, admin.GetResourceID(), "POST", "/v1/users", create, nil); code != http.StatusCreated {
+		t.Errorf("Expected the admin to create users, got %d", code)
+	}
+	if code := do(t, srv, "GET", "/v1/users", nil, nil); code != http.StatusOK {
+		t.Errorf("Expected reads to pass unmatched, got %d", code)
+	}
+}
+
+func TestServer_ErrorsAndConflicts(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	var errBody ErrorBody
+	if code := do(t, srv, "GET", "/v1/users/1", nil, &errBody); code != http.StatusNotFound || errBody.Error.Code != "not_found" {
+		t.Errorf("Expected 404 not_found, got %d %+v", code, errBody)
+	}
+
+	var user struct {
+		UserID  uint64 `json:"user_id"`
+		Version uint64 `json:"user_resource_version"`
+	}
+	do(t, srv, "POST", "/v1/users", UserRequest{Name: "Jane", Email: "jane@example.com"}, &user)
+	if code := do(t, srv, "POST", "/v1/users", UserRequest{Name: "Jane", Email: "jane@example.com"}, nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate user, got %d", code)
+	}
+
+	path := fmt.Sprintf("/v1/users/%d", user.UserID)
+	if code := do(t, srv, "PUT", path, UserRequest{Name: "Jane", Description: "a", ResourceVersion: user.Version}, nil); code != http.StatusOK {
+		t.Fatalf("Expected first update to succeed, got %d", code)
+	}
+	if code := do(t, srv, "PUT", path, UserRequest{Name: "Jane", Description: "b", ResourceVersion: user.Version}, &errBody); code != http.StatusConflict || errBody.Error.Code != "conflict" {
+		t.Errorf("Expected 409 conflict for stale version, got %d %+v", code, errBody)
+	}
+	if code := do(t, srv, "POST", "/v1/rules", RuleRequest{Name: "bad", TargetResourceType: "Project", Verb: "fly", Action: "allow"}, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown verb, got %d", code)
+	}
+}
+
+func TestServer_Pagination(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	for i := 0; i < 5; i++ {
+		do(t, srv, "POST", "/v1/users", UserRequest{Name: fmt.Sprint("user-", i)}, nil)
+	}
+
+	seen := 0
+	token := ""
+	for pages := 0; pages < 10; pages++ {
+		var page ListResponse[json.RawMessage]
+		do(t, srv, "GET", "/v1/users?limit=2&continue="+token, nil, &page)
+		seen += len(page.Items)
+		if page.Continue == "" {
+			break
+		}
+		token = page.Continue
+	}
+	if seen != 5 {
+		t.Errorf("Expected to page through 5 users, saw %d", seen)
+	}
+}
+
+func TestServer_Grants(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	var lead, member, profile, rule struct {
+		UserID    uint64 `json:"user_id"`
+		ProfileID uint64 `json:"profile_id"`
+		ID        uint64 `json:"id"`
+	}
+	do(t, srv, "POST", "/v1/users", UserRequest{Name: "Lena", Email: "lena@example.com"}, &lead)
+	do(t, srv, "POST", "/v1/users", UserRequest{Name: "Max", Email: "max@example.com"}, &member)
+	do(t, srv, "POST", "/v1/profiles", ProfileRequest{Name: "readers"}, &profile)
+	do(t, srv, "POST", "/v1/rules", RuleRequest{Name: "read-projects", TargetResourceType: "Project", TargetResourceID: "*", Verb: "read", Action: "allow"}, &rule)
+	do(t, srv, "PUT", fmt.Sprintf("/v1/profiles/%d/rules/%d", profile.ProfileID, rule.ID), nil, nil)
+	do(t, srv, "PUT", fmt.Sprintf("/v1/users/%d/profiles/%d", lead.UserID, profile.ProfileID), nil, nil)
+
+	req := GrantRequest{GranteeID: member.UserID, TargetResourceType: "Project", TargetResourceID: "42", Verb: "read", ExpiresAt: time.Now().Add(time.Hour)}
+	if code := do(t, srv, "POST", "/v1/grants", req, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a caller, got %d", code)
+	}
+	back := req
+	back.GranteeID = lead.UserID
+	if code := doAs(t, srv, member.UserID, "POST", "/v1/grants", back, nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a caller delegating access it lacks, got %d", code)
+	}
+	var grant struct {
+		ID        uint64 `json:"id"`
+		GrantorID uint64 `json:"grantor_id"`
+	}
+	if code := doAs(t, srv, lead.UserID, "POST", "/v1/grants", req, &grant); code != http.StatusCreated || grant.GrantorID != lead.UserID {
+		t.Fatalf("create grant: %d %+v", code, grant)
+	}
+	req.Verb = "delete"
+	if code := doAs(t, srv, lead.UserID, "POST", "/v1/grants", req, nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for access the grantor lacks, got %d", code)
+	}
+
+	var decision struct {
+		Allowed bool   `json:"allowed"`
+		GrantID uint64 `json:"grant_id"`
+	}
+	authorize := AuthorizeRequest{PrincipalID: member.UserID, ResourceType: "Project", ResourceID: 42, Verb: "read"}
+	do(t, srv, "POST", "/v1/authorize", authorize, &decision)
+	if !decision.Allowed || decision.GrantID != grant.ID {
+		t.Errorf("Expected ALLOW through grant %d, got %+v", grant.ID, decision)
+	}
+
+	revoke := fmt.Sprintf("/v1/grants/%d", grant.ID)
+	if code := do(t, srv, "DELETE", revoke, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 revoking without a caller, got %d", code)
+	}
+	if code := doAs(t, srv, member.UserID, "DELETE", revoke, nil, nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the grantee revoking, got %d", code)
+	}
+	if code := doAs(t, srv, lead.UserID, "DELETE", revoke, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("revoke grant: %d", code)
+	}
+	decision.Allowed = false
+	do(t, srv, "POST", "/v1/authorize", authorize, &decision)
+	if decision.Allowed {
+		t.Errorf("Expected DENY after revocation")
+	}
+}
+
+func TestServer_AccessRequests(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	var requester, approver, admins, payments, rule struct {
+		UserID    uint64 `json:"user_id"`
+		ProfileID uint64 `json:"profile_id"`
+		ID        uint64 `json:"id"`
+	}
+	do(t, srv, "POST", "/v1/users", UserRequest{Name: "Pat", Email: "pat@example.com"}, &requester)
+	do(t, srv, "POST", "/v1/users", UserRequest{Name: "Ada", Email: "ada@example.com"}, &approver)
+	do(t, srv, "POST", "/v1/profiles", ProfileRequest{Name: "payments"}, &payments)
+	do(t, srv, "POST", "/v1/profiles", ProfileRequest{Name: "profile-admins"}, &admins)
+	do(t, srv, "POST", "/v1/rules", RuleRequest{Name: "manage-profiles", TargetResourceType: "Profile", TargetResourceID: "*", Verb: "update", Action: "allow"}, &rule)
+	do(t, srv, "PUT", fmt.Sprintf("/v1/profiles/%d/rules/%d", admins.ProfileID, rule.ID), nil, nil)
+	do(t, srv, "PUT", fmt.Sprintf("/v1/users/%d/profiles/%d", approver.UserID, admins.ProfileID), nil, nil)
+
+	var ar struct {
+		ID          uint64 `json:"id"`
+		RequesterID uint64 `json:"requester_id"`
+		State       string `json:"state"`
+	}
+	body := AccessRequestBody{ProfileID: payments.ProfileID, Justification: "quarter close", Duration: "8h"}
+	if code := do(t, srv, "POST", "/v1/access-requests", body, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 requesting without a caller, got %d", code)
+	}
+	forOther := map[string]any{"requester_id": approver.UserID, "profile_id": payments.ProfileID, "justification": "on their behalf", "duration": "8h"}
+	if code := doAs(t, srv, requester.UserID, "POST", "/v1/access-requests", forOther, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 requesting for someone else, got %d", code)
+	}
+	if code := doAs(t, srv, requester.UserID, "POST", "/v1/access-requests", body, &ar); code != http.StatusCreated || ar.State != "pending" || ar.RequesterID != requester.UserID {
+		t.Fatalf("create access request: %d %+v", code, ar)
+	}
+
+	approve := fmt.Sprintf("/v1/access-requests/%d/approve", ar.ID)
+	if code := do(t, srv, "POST", approve, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a caller, got %d", code)
+	}
+	if code := doAs(t, srv, requester.UserID, "POST", approve, nil, nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for self-approval, got %d", code)
+	}
+	if code := doAs(t, srv, approver.UserID, "POST", approve, nil, &ar); code != http.StatusOK || ar.State != "approved" {
+		t.Fatalf("approve: %d %+v", code, ar)
+	}
+	if code := doAs(t, srv, approver.UserID, "POST", fmt.Sprintf("/v1/access-requests/%d/reject", ar.ID), nil, nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 rejecting an approved request, got %d", code)
+	}
+	if code := doAs(t, srv, approver.UserID, "POST", fmt.Sprintf("/v1/access-requests/%d/revoke", ar.ID), nil, &ar); code != http.StatusOK || ar.State != "revoked" {
+		t.Errorf("revoke: %d %+v", code, ar)
+	}
+}
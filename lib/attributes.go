@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// AttributeProvider looks up attributes of the resource a request targets,
+// e.g. its owner, from wherever they live. It should return promptly once
+// ctx is done.
+type AttributeProvider interface {
+	ResourceAttributes(ctx context.Context, resourceType core.ResourceType, resourceID uint64) (map[string]any, error)
+}
+
+// WithAttributeProvider fetches resource attributes before each evaluation
+// and merges them into the request's Attributes; attributes set by the
+// caller win. Decisions are not cached while a provider is configured,
+// since they may depend on attributes that change without a policy change.
+func WithAttributeProvider(p AttributeProvider) GatekeeperOption {
+	return func(g *Gatekeeper) {
+		g.attributes = p
+	}
+}
+
+// withResourceAttributes returns a copy of requestcontext carrying the
+// provider's attributes, leaving the caller's request untouched
+func (g *Gatekeeper) withResourceAttributes(ctx context.Context, requestcontext *RequestContext) (*RequestContext, error) {
+	fetched, err := g.attributes.ResourceAttributes(ctx, requestcontext.RequestResourceType, requestcontext.RequestResourceID)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]any, len(fetched)+len(requestcontext.Attributes))
+	for k, v := range fetched {
+		merged[k] = v
+	}
+	for k, v := range requestcontext.Attributes {
+		merged[k] = v
+	}
+	rc := *requestcontext
+	rc.Attributes = merged
+	return &rc, nil
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// AuditRecord is one Gatekeeper decision: who asked to do what, what was
+// decided and by which rule, and how long it took
+type AuditRecord struct {
+	Time          time.Time
+	CorrelationID string
+	Request       RequestContext
+	Decision      Decision
+	Latency       time.Duration
+}
+
+func (rec AuditRecord) MarshalJSON() ([]byte, error) {
+	var errText string
+	if rec.Decision.Err != nil {
+		errText = rec.Decision.Err.Error()
+	}
+	return json.Marshal(struct {
+		Time          time.Time      `json:"time"`
+		CorrelationID string         `json:"correlation_id"`
+		PrincipalID   uint64         `json:"principal_id"`
+		Impersonator  uint64         `json:"impersonator_id,omitempty"`
+		ResourceType  string         `json:"resource_type"`
+		ResourceID    uint64         `json:"resource_id"`
+		Verb          string         `json:"verb"`
+		Attributes    map[string]any `json:"attributes,omitempty"`
+		Allowed       bool           `json:"allowed"`
+		Effect        string         `json:"effect"`
+		RuleID        uint64         `json:"rule_id,omitempty"`
+		ProfileID     uint64         `json:"profile_id,omitempty"`
+		GrantID       uint64         `json:"grant_id,omitempty"`
+		Reason        string         `json:"reason"`
+		Error         string         `json:"error,omitempty"`
+		BreakGlass    bool           `json:"break_glass,omitempty"`
+		LatencyNS     int64          `json:"latency_ns"`
+	}{
+		Time:          rec.Time,
+		CorrelationID: rec.CorrelationID,
+		PrincipalID:   rec.Request.PrincipalID,
+		Impersonator:  rec.Request.ImpersonatorID,
+		ResourceType:  rec.Request.RequestResourceType.String(),
+		ResourceID:    rec.Request.RequestResourceID,
+		Verb:          core.FormatVerb(rec.Request.RequestVerb),
+		Attributes:    rec.Request.Attributes,
+		Allowed:       rec.Decision.Allowed,
+		Effect:        rec.Decision.Effect.String(),
+		RuleID:        rec.Decision.RuleID,
+		ProfileID:     rec.Decision.ProfileID,
+		GrantID:       rec.Decision.GrantID,
+		Reason:        rec.Decision.Reason,
+		Error:         errText,
+		BreakGlass:    rec.Decision.BreakGlass,
+		LatencyNS:     rec.Latency.Nanoseconds(),
+	})
+}
+
+// AuditSink receives a record for every decision. Write is called on the
+// authorization path, so it must not block; see lib/audit for sinks that
+// buffer and persist records in the background.
+type AuditSink interface {
+	Write(rec AuditRecord)
+}
+
+// WithAuditSink sends every decision the Gatekeeper makes to sink
+func WithAuditSink(sink AuditSink) GatekeeperOption {
+	return func(g *Gatekeeper) {
+		g.audit = sink
+	}
+}
+
+// NewCorrelationID returns a random 128-bit ID in hex
+func NewCorrelationID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
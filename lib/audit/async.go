@@ -0,0 +1,183 @@
+// Package audit persists Gatekeeper decisions.
+//
+// AsyncSink implements lib.AuditSink: it queues records without blocking and
+// hands them in batches to a BatchWriter on a background goroutine.
+// FileSink is a BatchWriter that appends JSON lines to a file, rotating it by
+// size or age and optionally gzipping rotated files.
+//
+//	file, err := audit.NewFileSink("/var/log/rbac/audit.jsonl",
+//		audit.WithMaxSize(100<<20), audit.WithRotateEvery(24*time.Hour), audit.WithCompression())
+//	sink := audit.NewAsyncSink(file)
+//	defer sink.Close()
+//	gk := lib.NewGatekeeper(lib.WithStore(ctrl), lib.WithAuditSink(sink))
+package audit
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/farhansabbir/rbac/lib"
+)
+
+const (
+	defaultBufferSize    = 4096
+	defaultBatchSize     = 256
+	defaultFlushInterval = time.Second
+)
+
+// BatchWriter persists records. It is only called from one goroutine.
+type BatchWriter interface {
+	WriteBatch(records []lib.AuditRecord) error
+	Close() error
+}
+
+// AsyncOption configures an AsyncSink built by NewAsyncSink
+type AsyncOption func(*AsyncSink)
+
+// WithBufferSize sets how many records may wait to be written. When the
+// buffer is full new records are dropped and counted, see Dropped.
+func WithBufferSize(size int) AsyncOption {
+	return func(s *AsyncSink) {
+		if size > 0 {
+			s.bufferSize = size
+		}
+	}
+}
+
+// WithBatchSize sets the most records handed to the writer at once
+func WithBatchSize(size int) AsyncOption {
+	return func(s *AsyncSink) {
+		if size > 0 {
+			s.batchSize = size
+		}
+	}
+}
+
+// WithFlushInterval sets how long a partial batch may wait before it is
+// written
+func WithFlushInterval(d time.Duration) AsyncOption {
+	return func(s *AsyncSink) {
+		if d > 0 {
+			s.flushInterval = d
+		}
+	}
+}
+
+// WithErrorHandler is called with every error the writer returns. By
+// default errors are printed to stderr.
+func WithErrorHandler(fn func(error)) AsyncOption {
+	return func(s *AsyncSink) {
+		s.onError = fn
+	}
+}
+
+// AsyncSink batches records in the background so that auditing never
+// blocks an authorization
+type AsyncSink struct {
+	writer        BatchWriter
+	bufferSize    int
+	batchSize     int
+	flushInterval time.Duration
+	onError       func(error)
+
+	mux     sync.RWMutex // guards closed against concurrent Write and Close
+	closed  bool
+	records chan lib.AuditRecord
+	done    chan struct{}
+	dropped uint64
+	err     error // from the writer's Close
+}
+
+// NewAsyncSink starts a sink that writes to w. Call Close to flush it.
+func NewAsyncSink(w BatchWriter, opts ...AsyncOption) *AsyncSink {
+	s := &AsyncSink{
+		writer:        w,
+		bufferSize:    defaultBufferSize,
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		onError: func(err error) {
+			fmt.Fprintf(os.Stderr, "audit: %v\n", err)
+		},
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.records = make(chan lib.AuditRecord, s.bufferSize)
+	go s.run()
+	return s
+}
+
+// Write queues rec. It never blocks: if the buffer is full, or the sink is
+// closed, rec is dropped.
+func (s *AsyncSink) Write(rec lib.AuditRecord) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	select {
+	case s.records <- rec:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns how many records were lost to a full buffer or a closed
+// sink
+func (s *AsyncSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close writes every queued record, then closes the writer. It is safe to
+// call more than once.
+func (s *AsyncSink) Close() error {
+	s.mux.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.records)
+	}
+	s.mux.Unlock()
+
+	<-s.done
+	return s.err
+}
+
+func (s *AsyncSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]lib.AuditRecord, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.writer.WriteBatch(batch); err != nil {
+			s.onError(err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case rec, ok := <-s.records:
+			if !ok {
+				flush()
+				s.err = s.writer.Close()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
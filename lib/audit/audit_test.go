@@ -0,0 +1,250 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+func record(id string) lib.AuditRecord {
+	return lib.AuditRecord{
+		Time:          time.Now(),
+		CorrelationID: id,
+		Request:       lib.RequestContext{PrincipalID: 7, RequestResourceType: core.ResourceTypeProject, RequestResourceID: 42, RequestVerb: core.VerbRead | core.VerbList},
+		Decision:      lib.Decision{Allowed: true, Effect: lib.EffectAllow, RuleID: 3, ProfileID: 5, Reason: "allowed by rule 3 in profile 5"},
+		Latency:       1500 * time.Nanosecond,
+	}
+}
+
+// readLines decodes every JSON line of path, gunzipping .gz files
+func readLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var sc *bufio.Scanner
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc = bufio.NewScanner(zr)
+	} else {
+		sc = bufio.NewScanner(f)
+	}
+	var lines []map[string]any
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestFileSink_RecordFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteBatch([]lib.AuditRecord{record("abc")}); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	lines := readLines(t, path)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+	want := map[string]any{
+		"correlation_id": "abc",
+		"principal_id":   float64(7),
+		"resource_type":  "Project",
+		"resource_id":    float64(42),
+		"verb":           "read|list",
+		"allowed":        true,
+		"effect":         "allow",
+		"rule_id":        float64(3),
+		"profile_id":     float64(5),
+		"latency_ns":     float64(1500),
+	}
+	for key, value := range want {
+		if lines[0][key] != value {
+			t.Errorf("%s = %v, want %v", key, lines[0][key], value)
+		}
+	}
+}
+
+func TestFileSink_RotatesBySizeAndCompresses(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	clock := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	sink, err := NewFileSink(path, WithMaxSize(1), WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := sink.WriteBatch([]lib.AuditRecord{record(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl.gz"))
+	if len(rotated) != 2 {
+		t.Fatalf("got rotated files %v, want 2", rotated)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl")); len(leftovers) != 0 {
+		t.Errorf("uncompressed rotated files left behind: %v", leftovers)
+	}
+	var ids []any
+	for _, name := range append(rotated, path) {
+		for _, line := range readLines(t, name) {
+			ids = append(ids, line["correlation_id"])
+		}
+	}
+	if len(ids) != 3 || ids[0] != "a" || ids[1] != "b" || ids[2] != "c" {
+		t.Errorf("records across files = %v, want [a b c]", ids)
+	}
+}
+
+func TestFileSink_RotatesByTime(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	clock := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	sink, err := NewFileSink(path, WithRotateEvery(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.now = func() time.Time { return clock }
+	sink.opened = clock
+
+	sink.WriteBatch([]lib.AuditRecord{record("a")})
+	clock = clock.Add(30 * time.Minute)
+	sink.WriteBatch([]lib.AuditRecord{record("b")})
+	clock = clock.Add(31 * time.Minute)
+	sink.WriteBatch([]lib.AuditRecord{record("c")})
+	sink.Close()
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	if len(rotated) != 1 || len(readLines(t, rotated[0])) != 2 || len(readLines(t, path)) != 1 {
+		t.Fatalf("want one rotated file with 2 records and 1 record in %s, got %v", path, rotated)
+	}
+}
+
+func TestFileSink_KeepsWritingWhenRotationFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	sink, err := NewFileSink(path, WithMaxSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+
+	if err := sink.WriteBatch([]lib.AuditRecord{record("a")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b", "c"} {
+		if err := sink.WriteBatch([]lib.AuditRecord{record(id)}); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("batch %s: expected the failed rotation to be reported, got %v", id, err)
+		}
+	}
+	sink.Close()
+
+	if rotated, _ := filepath.Glob(filepath.Join(dir, "audit-*")); len(rotated) != 0 {
+		t.Errorf("got rotated files %v, want none", rotated)
+	}
+	if lines := readLines(t, path); len(lines) != 3 {
+		t.Errorf("got %d records in %s, want all 3", len(lines), path)
+	}
+}
+
+// memoryWriter records batches; it blocks while gate is held
+type memoryWriter struct {
+	gate    sync.Mutex
+	mux     sync.Mutex
+	batches [][]lib.AuditRecord
+	closed  bool
+}
+
+func (m *memoryWriter) WriteBatch(records []lib.AuditRecord) error {
+	m.gate.Lock()
+	defer m.gate.Unlock()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.batches = append(m.batches, append([]lib.AuditRecord(nil), records...))
+	return nil
+}
+
+func (m *memoryWriter) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestAsyncSink_BatchesAndFlushesOnClose(t *testing.T) {
+	w := &memoryWriter{}
+	sink := NewAsyncSink(w, WithBatchSize(2), WithFlushInterval(time.Hour))
+	for _, id := range []string{"a", "b", "c"} {
+		sink.Write(record(id))
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.closed {
+		t.Error("Close did not close the writer")
+	}
+	if len(w.batches) != 2 || len(w.batches[0]) != 2 || len(w.batches[1]) != 1 {
+		t.Fatalf("got batches %v, want sizes [2 1]", w.batches)
+	}
+
+	sink.Write(record("late"))
+	if sink.Dropped() != 1 {
+		t.Errorf("write after Close: dropped = %d, want 1", sink.Dropped())
+	}
+}
+
+func TestAsyncSink_NeverBlocks(t *testing.T) {
+	w := &memoryWriter{}
+	w.gate.Lock() // the writer is stuck
+	sink := NewAsyncSink(w, WithBufferSize(2), WithBatchSize(1))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			sink.Write(record("x"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked on a stuck writer")
+	}
+	if sink.Dropped() == 0 {
+		t.Error("expected records to be dropped while the writer was stuck")
+	}
+
+	w.gate.Unlock()
+	sink.Close()
+}
@@ -0,0 +1,200 @@
+package audit
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/farhansabbir/rbac/lib"
+)
+
+// FileOption configures a FileSink built by NewFileSink
+type FileOption func(*FileSink)
+
+// WithMaxSize rotates the file before a batch would take it past size bytes
+func WithMaxSize(size int64) FileOption {
+	return func(f *FileSink) {
+		f.maxSize = size
+	}
+}
+
+// WithRotateEvery rotates the file once it has been open for d
+func WithRotateEvery(d time.Duration) FileOption {
+	return func(f *FileSink) {
+		f.rotateEvery = d
+	}
+}
+
+// WithCompression gzips rotated files
+func WithCompression() FileOption {
+	return func(f *FileSink) {
+		f.compress = true
+	}
+}
+
+// FileSink appends records as JSON lines to a file. A rotated file is
+// renamed with the time it was rotated, e.g. audit.jsonl becomes
+// audit-20260102T150405.000.jsonl (.gz with compression), and a new file is
+// started at the original path.
+type FileSink struct {
+	path        string
+	maxSize     int64
+	rotateEvery time.Duration
+	compress    bool
+	now         func() time.Time
+	rename      func(oldpath, newpath string) error
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string, opts ...FileOption) (*FileSink, error) {
+	f := &FileSink{path: path, now: time.Now, rename: os.Rename}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteBatch appends records, rotating first if the batch would exceed the
+// size limit or the file is due for time-based rotation. A failed rotation
+// is reported, but the batch is still written, to the new file or else to
+// the one that could not be rotated.
+func (f *FileSink) WriteBatch(records []lib.AuditRecord) error {
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("encode audit record %s: %w", rec.CorrelationID, err)
+		}
+	}
+
+	var rotateErr error
+	if f.file != nil && f.dueForRotation(int64(buf.Len())) {
+		rotateErr = f.rotate()
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return errors.Join(rotateErr, err)
+		}
+	}
+	n, err := io.WriteString(f.file, buf.String())
+	f.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+// Close closes the current file
+func (f *FileSink) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func (f *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), f.now()
+	return nil
+}
+
+func (f *FileSink) dueForRotation(next int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+next > f.maxSize {
+		return true
+	}
+	return f.rotateEvery > 0 && f.now().Sub(f.opened) >= f.rotateEvery
+}
+
+// rotate moves the file aside and opens a new one at f.path. If the file
+// cannot be moved it is reopened, so writing carries on there. f.file is nil
+// when neither could be opened; WriteBatch retries then.
+func (f *FileSink) rotate() error {
+	closeErr := f.file.Close()
+	f.file = nil
+	rotated := f.rotatedName()
+	if err := f.rename(f.path, rotated); err != nil {
+		return errors.Join(closeErr, fmt.Errorf("rotate audit log: %w", err), f.open())
+	}
+	if err := f.open(); err != nil {
+		return errors.Join(closeErr, err)
+	}
+	if f.compress {
+		if err := gzipFile(rotated); err != nil {
+			return errors.Join(closeErr, fmt.Errorf("compress rotated audit log: %w", err))
+		}
+	}
+	return closeErr
+}
+
+// rotatedName returns an unused name for the file being rotated out
+func (f *FileSink) rotatedName() string {
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	stamp := f.now().UTC().Format("20060102T150405.000")
+
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s-%s%s", base, stamp, ext)
+		if i > 0 {
+			name = fmt.Sprintf("%s-%s-%d%s", base, stamp, i, ext)
+		}
+		if !exists(name) && !exists(name+".gz") {
+			return name
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// VersionedStore is a PolicyStore that can tell when its state changed.
+// controllers.Controller implements it.
+type VersionedStore interface {
+	PolicyStore
+	// ResourceVersion returns a number that changes with every committed
+	// change. It is called outside View.
+	ResourceVersion() uint64
+}
+
+// WithDecisionCache remembers decisions for up to ttl, keeping at most size
+// of them. A cached decision is only reused while the store's resource
+// version is unchanged, so any policy change invalidates the cache at once.
+// Caching needs a VersionedStore (see WithStore); requests carrying
+// Attributes are never cached, nor are decisions reached through a delegated
+// grant, which expire on their own. A decision for a principal holding a
+// time-bound assignment is not reused once the assignment has ended.
+func WithDecisionCache(ttl time.Duration, size int) GatekeeperOption {
+	return func(g *Gatekeeper) {
+		if ttl > 0 && size > 0 {
+			g.cache = &decisionCache{ttl: ttl, size: size, entries: make(map[cacheKey]cacheEntry)}
+		}
+	}
+}
+
+type cacheKey struct {
+	principalID  uint64
+	resourceType core.ResourceType
+	resourceID   uint64
+	verb         core.Verb
+	impersonator uint64
+}
+
+type cacheEntry struct {
+	decision Decision
+	version  uint64
+	expires  time.Time
+	until    time.Time // end of the first time-bound assignment ahead, if any
+}
+
+type decisionCache struct {
+	ttl     time.Duration
+	size    int
+	mux     sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+func newCacheKey(rc *RequestContext) cacheKey {
+	return cacheKey{rc.PrincipalID, rc.RequestResourceType, rc.RequestResourceID, rc.RequestVerb, rc.ImpersonatorID}
+}
+
+// get returns the decision stored for key as of version. at is the time the
+// request is decided for, which must be before the entry's until.
+func (c *decisionCache) get(key cacheKey, version uint64, now, at time.Time) (Decision, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.version != version || now.After(entry.expires) || (!entry.until.IsZero() && !at.Before(entry.until)) {
+		return Decision{}, false
+	}
+	return entry.decision, true
+}
+
+// put stores d as of version for the TTL. It is not served for requests
+// decided at or after until, when a time-bound assignment it may rest on
+// ends. When the cache is full, stale entries are dropped first, then
+// arbitrary ones.
+func (c *decisionCache) put(key cacheKey, version uint64, now, until time.Time, d Decision) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		for k, entry := range c.entries {
+			if entry.version != version || now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{decision: d, version: version, expires: now.Add(c.ttl), until: until}
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+// newApprovalDesk returns a requester, two approvers allowed to update every
+// profile, and a payments profile allowing project creation
+func newApprovalDesk(t *testing.T, ctrl *Controller) (requester, first, second *core.User, payments *core.Profile) {
+	err := ctrl.Tx(func(tx *Tx) error {
+		requester, _ = tx.CreateUser("Pat", "Payments", "pat@example.com")
+		first, _ = tx.CreateUser("Ada", "Approver", "ada@example.com")
+		second, _ = tx.CreateUser("Bo", "Approver", "bo@example.com")
+		payments, _ = tx.CreateProfile("payments", "")
+		admins, _ := tx.CreateProfile("profile-admins", "")
+
+		create := newRule("create-projects", core.ResourceTypeProject, core.VerbCreate, core.ActionAllow)
+		manage := newRule("manage-profiles", core.ResourceTypeProfile, core.VerbUpdate, core.ActionAllow)
+		// A failed step fails every later one, so the last error covers all
+		tx.CreateRule(create)
+		tx.CreateRule(manage)
+		tx.AddRuleToProfile(payments.GetResourceID(), create.GetResourceID())
+		tx.AddRuleToProfile(admins.GetResourceID(), manage.GetResourceID())
+		tx.AssignProfile(first.GetResourceID(), admins.GetResourceID())
+		return tx.AssignProfile(second.GetResourceID(), admins.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return requester, first, second, payments
+}
+
+func TestAccessRequest_QuorumApprovalIsTimeBound(t *testing.T) {
+	ctrl := New(WithApprovalQuorum(2))
+	requester, first, second, payments := newApprovalDesk(t, ctrl)
+	arc := ctrl.GetAccessRequestController()
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+
+	_, version := ctrl.List(core.ResourceTypeAccessRequest)
+	w, err := ctrl.Watch(core.ResourceTypeAccessRequest, version)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+
+	ar, err := arc.RequestAccess(requester.GetResourceID(), payments.GetResourceID(), "quarter close", time.Hour)
+	if err != nil {
+		t.Fatalf("RequestAccess failed: %v", err)
+	}
+	if _, err := arc.RequestAccess(requester.GetResourceID(), payments.GetResourceID(), "again", time.Hour); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for a second open request, got %v", err)
+	}
+	if _, err := arc.Approve(ar.GetResourceID(), requester.GetResourceID()); !errors.Is(err, ErrNotApprover) {
+		t.Errorf("Expected ErrNotApprover for self-approval, got %v", err)
+	}
+
+	if _, err := arc.Approve(ar.GetResourceID(), first.GetResourceID()); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if _, err := arc.Approve(ar.GetResourceID(), first.GetResourceID()); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for a repeated approval, got %v", err)
+	}
+	if ar.GetState() != core.AccessRequestPending || requester.HasProfile(payments.GetResourceID()) {
+		t.Fatalf("Expected one approval of two to leave the request pending, got %s", ar.GetState())
+	}
+
+	if _, err := arc.Approve(ar.GetResourceID(), second.GetResourceID()); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if ar.GetState() != core.AccessRequestApproved {
+		t.Fatalf("Expected quorum to approve, got %s", ar.GetState())
+	}
+	create := func(at time.Time) lib.Decision {
+		return gk.Decide(&lib.RequestContext{PrincipalID: requester.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbCreate, ContextDT: at})
+	}
+	if d := create(time.Now()); !d.Allowed {
+		t.Errorf("Expected the approved profile to allow, got %+v", d)
+	}
+	end := ar.GetExpiresAt()
+	if d := create(end); d.Allowed {
+		t.Errorf("Expected the assignment to end at %s, got %+v", end, d)
+	}
+
+	if n := arc.ExpireAccessRequests(end); n != 1 {
+		t.Fatalf("Expected 1 request to expire, got %d", n)
+	}
+	if ar.GetState() != core.AccessRequestExpired || requester.HasProfile(payments.GetResourceID()) {
+		t.Errorf("Expected the request expired and the profile unassigned, got %s", ar.GetState())
+	}
+
+	// Created, approved twice and expired
+	for _, want := range []EventType{EventAdded, EventModified, EventModified, EventModified} {
+		if ev := <-w.ResultChan(); ev.Type != want || ev.ID != ar.GetResourceID() {
+			t.Fatalf("Expected %s for the request, got %s", want, ev)
+		}
+	}
+}
+
+func TestAccessRequest_CachedAssignmentEnds(t *testing.T) {
+	ctrl := New()
+	requester, approver, _, payments := newApprovalDesk(t, ctrl)
+	arc := ctrl.GetAccessRequestController()
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl), lib.WithDecisionCache(time.Hour, 10))
+
+	ar, err := arc.RequestAccess(requester.GetResourceID(), payments.GetResourceID(), "quarter close", time.Hour)
+	if err != nil {
+		t.Fatalf("RequestAccess failed: %v", err)
+	}
+	if _, err := arc.Approve(ar.GetResourceID(), approver.GetResourceID()); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+
+	rc := &lib.RequestContext{PrincipalID: requester.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbCreate}
+	if d := gk.Decide(rc); !d.Allowed {
+		t.Fatalf("Expected the approved profile to allow, got %+v", d)
+	}
+	rc.ContextDT = ar.GetExpiresAt()
+	if d := gk.Decide(rc); d.Allowed {
+		t.Errorf("Expected the assignment to end, even with caching on, got %+v", d)
+	}
+}
+
+func TestAccessRequest_RejectRevokeAndPendingExpiry(t *testing.T) {
+	ctrl := New(WithAccessRequestTTL(time.Minute))
+	requester, approver, _, payments := newApprovalDesk(t, ctrl)
+	arc := ctrl.GetAccessRequestController()
+
+	ar, _ := arc.RequestAccess(requester.GetResourceID(), payments.GetResourceID(), "incident 12", time.Hour)
+	if _, err := arc.Reject(ar.GetResourceID(), approver.GetResourceID()); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if _, err := arc.Approve(ar.GetResourceID(), approver.GetResourceID()); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Expected ErrInvalidState approving a rejected request, got %v", err)
+	}
+
+	ar, _ = arc.RequestAccess(requester.GetResourceID(), payments.GetResourceID(), "incident 13", time.Hour)
+	if _, err := arc.Approve(ar.GetResourceID(), approver.GetResourceID()); err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if _, err := arc.Revoke(ar.GetResourceID(), approver.GetResourceID()); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if ar.GetState() != core.AccessRequestRevoked || requester.HasProfile(payments.GetResourceID()) {
+		t.Errorf("Expected the request revoked and the profile unassigned, got %s", ar.GetState())
+	}
+
+	ar, _ = arc.RequestAccess(requester.GetResourceID(), payments.GetResourceID(), "incident 14", time.Hour)
+	if n := arc.ExpireAccessRequests(time.Now().Add(2 * time.Minute)); n != 1 || ar.GetState() != core.AccessRequestExpired {
+		t.Errorf("Expected the undecided request to expire, got %d closed and %s", n, ar.GetState())
+	}
+}
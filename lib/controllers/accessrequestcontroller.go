@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+const (
+	defaultApprovalQuorum   = 1
+	defaultAccessRequestTTL = 72 * time.Hour
+)
+
+var (
+	// ErrNotApprover is returned when a principal may not decide an access
+	// request: it may not update the requested profile, or it is the
+	// requester
+	ErrNotApprover = errors.New("not an approver")
+	// ErrInvalidState is returned when an access request is not in the
+	// state a transition starts from, e.g. approving a rejected request
+	ErrInvalidState = errors.New("invalid access request state")
+)
+
+// WithApprovalQuorum sets how many distinct approvers new access requests
+// need. The default is 1.
+func WithApprovalQuorum(quorum int) Option {
+	return func(c *Controller) {
+		if quorum > 0 {
+			c.approvalQuorum = quorum
+		}
+	}
+}
+
+// WithAccessRequestTTL sets how long an access request may stay pending
+// before it expires. The default is 72 hours.
+func WithAccessRequestTTL(ttl time.Duration) Option {
+	return func(c *Controller) {
+		if ttl > 0 {
+			c.accessRequestTTL = ttl
+		}
+	}
+}
+
+// AccessRequestController manages access requests and their events
+type AccessRequestController struct {
+	id       uint64
+	ctrl     *Controller
+	mux      sync.RWMutex
+	requests map[uint64]*core.AccessRequest
+	events   chan Event
+}
+
+// --- AccessRequestController Methods ---
+
+// RequestAccess opens a request by a user for a profile, to be held for
+// duration once approved
+func (arc *AccessRequestController) RequestAccess(requesterID, profileID uint64, justification string, duration time.Duration) (*core.AccessRequest, error) {
+	ar := core.NewAccessRequest(requesterID, profileID, justification, duration, arc.ctrl.approvalQuorum, time.Now().Add(arc.ctrl.accessRequestTTL))
+	if err := arc.ctrl.Tx(func(tx *Tx) error { return tx.CreateAccessRequest(ar) }); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+// Approve records approverID's approval. Approvers are the principals the
+// Gatekeeper allows to update the requested profile, other than the
+// requester. The approval that reaches the request's quorum assigns the
+// profile until the request's duration has passed.
+func (arc *AccessRequestController) Approve(id, approverID uint64) (*core.AccessRequest, error) {
+	return arc.decide(id, approverID, func(tx *Tx, at time.Time) error {
+		return tx.ApproveAccessRequest(id, approverID, at)
+	})
+}
+
+// Reject closes a pending request; one approver's rejection is enough
+func (arc *AccessRequestController) Reject(id, approverID uint64) (*core.AccessRequest, error) {
+	return arc.decide(id, approverID, func(tx *Tx, at time.Time) error {
+		return tx.RejectAccessRequest(id, approverID, at)
+	})
+}
+
+// Revoke ends an approved request's assignment before it runs out
+func (arc *AccessRequestController) Revoke(id, approverID uint64) (*core.AccessRequest, error) {
+	return arc.decide(id, approverID, func(tx *Tx, at time.Time) error {
+		return tx.RevokeAccessRequest(id, approverID, at)
+	})
+}
+
+// decide checks that approverID may decide request id, outside any
+// transaction since the Gatekeeper reads controller state, then runs stage
+func (arc *AccessRequestController) decide(id, approverID uint64, stage func(tx *Tx, at time.Time) error) (*core.AccessRequest, error) {
+	ar := arc.GetAccessRequest(id)
+	if ar == nil {
+		return nil, fmt.Errorf("%s with ID %d %w", core.ResourceTypeAccessRequest, id, ErrNotFound)
+	}
+	now := time.Now()
+	if approverID == ar.GetRequesterID() {
+		return nil, fmt.Errorf("principal %d cannot decide its own %s %d: %w", approverID, core.ResourceTypeAccessRequest, id, ErrNotApprover)
+	}
+	d := lib.NewGatekeeper(lib.WithStore(arc.ctrl)).Decide(&lib.RequestContext{
+		PrincipalID:         approverID,
+		RequestResourceType: core.ResourceTypeProfile,
+		RequestResourceID:   ar.GetProfileID(),
+		RequestVerb:         core.VerbUpdate,
+		ContextDT:           now,
+	})
+	if !d.Allowed {
+		return nil, fmt.Errorf("principal %d may not decide %s %d: %w (%s)", approverID, core.ResourceTypeAccessRequest, id, ErrNotApprover, d.Reason)
+	}
+
+	if err := arc.ctrl.Tx(func(tx *Tx) error { return stage(tx, now) }); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+// ExpireAccessRequests closes every request that is due at now: pending
+// ones not decided in time, and approved ones whose assignment ran out,
+// which are unassigned. It returns how many were closed. The controller
+// calls it on every garbage collector interval while running. In between,
+// the Gatekeeper leaves an ended assignment out of decisions made after it
+// ends, and does not reuse cached decisions made before.
+func (arc *AccessRequestController) ExpireAccessRequests(now time.Time) int {
+	closed := 0
+	for _, ar := range arc.ListAccessRequests() {
+		if !ar.IsDueAt(now) {
+			continue
+		}
+		err := arc.ctrl.Tx(func(tx *Tx) error { return tx.ExpireAccessRequest(ar.GetResourceID(), now) })
+		if err == nil {
+			closed++
+		}
+	}
+	return closed
+}
+
+func (arc *AccessRequestController) GetAccessRequest(id uint64) *core.AccessRequest {
+	arc.mux.RLock()
+	defer arc.mux.RUnlock()
+	return arc.requests[id]
+}
+
+func (arc *AccessRequestController) ListAccessRequests() []*core.AccessRequest {
+	arc.mux.RLock()
+	defer arc.mux.RUnlock()
+
+	list := make([]*core.AccessRequest, 0, len(arc.requests))
+	for _, ar := range arc.requests {
+		list = append(list, ar)
+	}
+	return list
+}
+
+func (arc *AccessRequestController) put(ar *core.AccessRequest) {
+	arc.mux.Lock()
+	defer arc.mux.Unlock()
+	arc.requests[ar.GetResourceID()] = ar
+}
+
+func (arc *AccessRequestController) remove(id uint64) {
+	arc.mux.Lock()
+	defer arc.mux.Unlock()
+	delete(arc.requests, id)
+}
+
+// startExpiry closes due access requests and ends lapsed break-glass
+// activations every gcInterval
+func (c *Controller) startExpiry(ctx context.Context) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.gcInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.arcinstance.ExpireAccessRequests(now)
+				c.pcinstance.EndBreakGlass(now)
+			}
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+var (
+	// ErrBreakGlassDenied is returned when a principal may not activate or
+	// acknowledge an emergency profile
+	ErrBreakGlassDenied = errors.New("break glass not allowed")
+	// ErrAcknowledgementRequired is returned when an emergency profile is
+	// activated again before its last activation was acknowledged
+	ErrAcknowledgementRequired = errors.New("break glass awaits post-incident acknowledgement")
+)
+
+// ConfigureBreakGlass makes a profile an emergency profile, taken on for
+// duration per activation; 0 makes it an ordinary profile again
+func (pc *ProfileController) ConfigureBreakGlass(profileID uint64, duration time.Duration) error {
+	return pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.SetBreakGlass(profileID, duration)
+	})
+}
+
+// ActivateBreakGlass assigns an emergency profile to userID for the
+// profile's duration. The user must be allowed to execute the profile, and
+// must give a reason. Decisions the profile takes part in are flagged with
+// lib.Decision.BreakGlass, and activation publishes a high-priority event.
+// The profile cannot be activated again until AcknowledgeBreakGlass.
+func (pc *ProfileController) ActivateBreakGlass(profileID, userID uint64, reason string) error {
+	now := time.Now()
+	if err := pc.authorizeBreakGlass(profileID, userID, core.VerbExecute, now); err != nil {
+		return err
+	}
+	return pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.ActivateBreakGlass(profileID, userID, reason, now)
+	})
+}
+
+// AcknowledgeBreakGlass records the post-incident review of an emergency
+// profile's last activation, ending it if still in force. The reviewer must
+// be allowed to update the profile.
+func (pc *ProfileController) AcknowledgeBreakGlass(profileID, principalID uint64, note string) error {
+	now := time.Now()
+	if err := pc.authorizeBreakGlass(profileID, principalID, core.VerbUpdate, now); err != nil {
+		return err
+	}
+	return pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.AcknowledgeBreakGlass(profileID, principalID, note, now)
+	})
+}
+
+// EndBreakGlass records every emergency activation that ran out by now and
+// unassigns it, publishing a high-priority event each. It returns how many
+// ended. The controller calls it on every garbage collector interval while
+// running; the Gatekeeper stops counting the profile on time regardless.
+func (pc *ProfileController) EndBreakGlass(now time.Time) int {
+	ended := 0
+	for _, p := range pc.ListProfiles() {
+		bg, ok := p.GetBreakGlass()
+		if !ok || bg.ActivatedAt.IsZero() || !bg.EndedAt.IsZero() || now.Before(bg.ExpiresAt) {
+			continue
+		}
+		if pc.ctrl.Tx(func(tx *Tx) error { return tx.EndBreakGlass(p.GetResourceID(), now) }) == nil {
+			ended++
+		}
+	}
+	return ended
+}
+
+// authorizeBreakGlass asks the Gatekeeper, outside any transaction, whether
+// principalID may apply verb to the profile
+func (pc *ProfileController) authorizeBreakGlass(profileID, principalID uint64, verb core.Verb, now time.Time) error {
+	d := lib.NewGatekeeper(lib.WithStore(pc.ctrl)).Decide(&lib.RequestContext{
+		PrincipalID:         principalID,
+		RequestResourceType: core.ResourceTypeProfile,
+		RequestResourceID:   profileID,
+		RequestVerb:         verb,
+		ContextDT:           now,
+	})
+	if !d.Allowed {
+		return fmt.Errorf("principal %d may not %s emergency profile %d: %w (%s)", principalID, verb, profileID, ErrBreakGlassDenied, d.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+func TestBreakGlass_ActivateExpireAcknowledge(t *testing.T) {
+	ctrl := New()
+	pc := ctrl.GetProfileController()
+	var oncall, reviewer, bystander *core.User
+	var emergency *core.Profile
+	err := ctrl.Tx(func(tx *Tx) error {
+		oncall, _ = tx.CreateUser("Olu", "Oncall", "olu@example.com")
+		reviewer, _ = tx.CreateUser("Rae", "Reviewer", "rae@example.com")
+		bystander, _ = tx.CreateUser("Bex", "Bystander", "bex@example.com")
+		emergency, _ = tx.CreateProfile("incident-admin", "")
+		responders, _ := tx.CreateProfile("responders", "")
+		admins, _ := tx.CreateProfile("profile-admins", "")
+
+		wipe := newRule("delete-projects", core.ResourceTypeProject, core.VerbDelete, core.ActionAllow)
+		breakGlass := newRule("break-glass", core.ResourceTypeProfile, core.VerbExecute, core.ActionAllow)
+		manage := newRule("manage-profiles", core.ResourceTypeProfile, core.VerbUpdate, core.ActionAllow)
+		tx.CreateRule(wipe)
+		tx.CreateRule(breakGlass)
+		tx.CreateRule(manage)
+		tx.AddRuleToProfile(emergency.GetResourceID(), wipe.GetResourceID())
+		tx.AddRuleToProfile(responders.GetResourceID(), breakGlass.GetResourceID())
+		tx.AddRuleToProfile(admins.GetResourceID(), manage.GetResourceID())
+		tx.AssignProfile(oncall.GetResourceID(), responders.GetResourceID())
+		return tx.AssignProfile(reviewer.GetResourceID(), admins.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	id := emergency.GetResourceID()
+	if err := pc.ActivateBreakGlass(id, oncall.GetResourceID(), "db down"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an ordinary profile, got %v", err)
+	}
+	if err := pc.ConfigureBreakGlass(id, time.Hour); err != nil {
+		t.Fatalf("ConfigureBreakGlass failed: %v", err)
+	}
+
+	_, version := ctrl.List(core.ResourceTypeProfile)
+	w, err := ctrl.Watch(core.ResourceTypeProfile, version)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+
+	if err := pc.ActivateBreakGlass(id, bystander.GetResourceID(), "db down"); !errors.Is(err, ErrBreakGlassDenied) {
+		t.Errorf("Expected ErrBreakGlassDenied for an unauthorized user, got %v", err)
+	}
+	if err := pc.ActivateBreakGlass(id, oncall.GetResourceID(), " "); err == nil {
+		t.Errorf("Expected activation without a reason to fail")
+	}
+	if err := pc.ActivateBreakGlass(id, oncall.GetResourceID(), "db down"); err != nil {
+		t.Fatalf("ActivateBreakGlass failed: %v", err)
+	}
+	if ev := <-w.ResultChan(); ev.ID != id || ev.Priority != PriorityHigh {
+		t.Errorf("Expected a high-priority event for the activation, got %s", ev)
+	}
+
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	wipe := func(at time.Time) lib.Decision {
+		return gk.Decide(&lib.RequestContext{PrincipalID: oncall.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbDelete, ContextDT: at})
+	}
+	if d := wipe(time.Now()); !d.Allowed || !d.BreakGlass || d.ProfileID != id {
+		t.Errorf("Expected an allow flagged as break glass, got %+v", d)
+	}
+	bg, _ := emergency.GetBreakGlass()
+	if d := wipe(bg.ExpiresAt); d.Allowed {
+		t.Errorf("Expected the activation to end at %s, got %+v", bg.ExpiresAt, d)
+	}
+
+	if n := pc.EndBreakGlass(bg.ExpiresAt); n != 1 || oncall.HasProfile(id) {
+		t.Fatalf("Expected the activation ended and unassigned, got %d ended", n)
+	}
+	if ev := <-w.ResultChan(); ev.ID != id || ev.Priority != PriorityHigh {
+		t.Errorf("Expected a high-priority event for the end, got %s", ev)
+	}
+	if err := pc.ActivateBreakGlass(id, oncall.GetResourceID(), "db down again"); !errors.Is(err, ErrAcknowledgementRequired) {
+		t.Errorf("Expected ErrAcknowledgementRequired before acknowledgement, got %v", err)
+	}
+	if err := pc.AcknowledgeBreakGlass(id, oncall.GetResourceID(), "reviewed"); !errors.Is(err, ErrBreakGlassDenied) {
+		t.Errorf("Expected ErrBreakGlassDenied acknowledging without update on the profile, got %v", err)
+	}
+	if err := pc.AcknowledgeBreakGlass(id, reviewer.GetResourceID(), "postmortem PM-7"); err != nil {
+		t.Fatalf("AcknowledgeBreakGlass failed: %v", err)
+	}
+	if err := pc.ActivateBreakGlass(id, oncall.GetResourceID(), "db down again"); err != nil {
+		t.Errorf("Expected activation after acknowledgement, got %v", err)
+	}
+}
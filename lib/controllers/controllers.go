@@ -4,53 +4,138 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/farhansabbir/rbac/core"
 )
 
+const defaultEventBuffer = 100
+
 var (
 	globalController *Controller
 	initOnce         sync.Once
-	wg               sync.WaitGroup
 )
 
-// Controller is the main entry point (Singleton)
+// Option configures a Controller built by New
+type Option func(*Controller)
+
+// WithEventBuffer sets the capacity of each sub-controller's event channel.
+// Writers never wait for the event loop: an event published while its
+// channel is full is dropped and counted in Stats.EventsDropped. Watchers
+// have their own buffers, see Watch.
+func WithEventBuffer(size int) Option {
+	return func(c *Controller) {
+		if size > 0 {
+			c.eventBuffer = size
+		}
+	}
+}
+
+// Controller owns one independent policy world: its users, service
+// accounts, profiles, rules, delegated grants, access requests and the
+// background loop that processes their events.
 type Controller struct {
-	ucinstance *UserController
-	pcinstance *ProfileController
-	rcinstance *RuleController
-	ctx        context.Context
-	cancel     context.CancelFunc
+	ucinstance    *UserController
+	pcinstance    *ProfileController
+	rcinstance    *RuleController
+	sacinstance   *ServiceAccountController
+	dcinstance    *DelegationController
+	arcinstance   *AccessRequestController
+	eventBuffer   int
+	eventsDropped uint64 // atomic, see publish
+
+	// state serialises writes across all sub-controllers so that readers
+	// holding it (see View) never observe a partially applied change.
+	state   sync.RWMutex
+	version uint64 // last resource version handed out, guarded by state
+
+	// history keeps recent committed events for Watch; compacted is the
+	// newest version dropped from it. Both are guarded by state.
+	history     []Event
+	historySize int
+	compacted   uint64
+	watchMux    sync.Mutex
+	watchers    map[*Watcher]struct{}
+
+	retention  time.Duration
+	gcInterval time.Duration
+
+	delegationDepth  int
+	approvalQuorum   int
+	accessRequestTTL time.Duration
+
+	lifecycle sync.Mutex // guards running, ctx, cancel
+	running   bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New returns a stopped controller with empty state. Call Start to run its
+// background processes.
+func New(opts ...Option) *Controller {
+	c := &Controller{
+		eventBuffer: defaultEventBuffer,
+		historySize: defaultHistorySize,
+		gcInterval:  defaultGCInterval,
+		watchers:    make(map[*Watcher]struct{}),
+
+		delegationDepth:  defaultDelegationDepth,
+		approvalQuorum:   defaultApprovalQuorum,
+		accessRequestTTL: defaultAccessRequestTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.ucinstance = &UserController{
+		id:     xxhash.Sum64String("user_controller"),
+		ctrl:   c,
+		users:  make(map[uint64]*core.User),
+		events: make(chan Event, c.eventBuffer), // Buffered channel
+	}
+	c.pcinstance = &ProfileController{
+		id:       xxhash.Sum64String("profile_controller"),
+		ctrl:     c,
+		profiles: make(map[uint64]*core.Profile),
+		events:   make(chan Event, c.eventBuffer), // Buffered channel
+	}
+	c.rcinstance = &RuleController{
+		id:     xxhash.Sum64String("rule_controller"),
+		ctrl:   c,
+		rules:  make(map[uint64]*core.Rule),
+		events: make(chan Event, c.eventBuffer), // Buffered channel
+	}
+	c.sacinstance = &ServiceAccountController{
+		id:       xxhash.Sum64String("service_account_controller"),
+		ctrl:     c,
+		accounts: make(map[uint64]*core.ServiceAccount),
+		keys:     make(map[string]uint64),
+		events:   make(chan Event, c.eventBuffer), // Buffered channel
+	}
+	c.dcinstance = &DelegationController{
+		id:     xxhash.Sum64String("delegation_controller"),
+		ctrl:   c,
+		grants: make(map[uint64]*core.Grant),
+		events: make(chan Event, c.eventBuffer), // Buffered channel
+	}
+	c.arcinstance = &AccessRequestController{
+		id:       xxhash.Sum64String("access_request_controller"),
+		ctrl:     c,
+		requests: make(map[uint64]*core.AccessRequest),
+		events:   make(chan Event, c.eventBuffer), // Buffered channel
+	}
+	return c
 }
 
-// GetController initializes the system once and returns the singleton
+// GetController returns the process-wide default controller, creating and
+// starting it on first use. Code that needs isolation should use New instead.
 func GetController() *Controller {
 	initOnce.Do(func() {
-		ctx, cancel := context.WithCancel(context.Background())
-
-		globalController = &Controller{
-			ctx:    ctx,
-			cancel: cancel,
-			ucinstance: &UserController{
-				id:     xxhash.Sum64String("user_controller_singleton"),
-				users:  make(map[uint64]*core.User),
-				events: make(chan string, 100), // Buffered channel
-			},
-			pcinstance: &ProfileController{
-				id:       xxhash.Sum64String("profile_controller_singleton"),
-				profiles: make(map[uint64]*core.Profile),
-				events:   make(chan string, 100), // Buffered channel
-			},
-			rcinstance: &RuleController{
-				id:     xxhash.Sum64String("rule_controller_singleton"),
-				rules:  make(map[uint64]*core.Rule),
-				events: make(chan string, 100), // Buffered channel
-			},
-		}
-
-		// Start background processes
-		globalController.startEventLoop()
+		globalController = New()
+		globalController.Start()
 		fmt.Println("System Controller initialized")
 	})
 	return globalController
@@ -71,32 +156,171 @@ func (c *Controller) GetRuleController() *RuleController {
 	return c.rcinstance
 }
 
+// GetServiceAccountController returns the sub-controller
+func (c *Controller) GetServiceAccountController() *ServiceAccountController {
+	return c.sacinstance
+}
+
+// GetDelegationController returns the sub-controller
+func (c *Controller) GetDelegationController() *DelegationController {
+	return c.dcinstance
+}
+
+// GetAccessRequestController returns the sub-controller
+func (c *Controller) GetAccessRequestController() *AccessRequestController {
+	return c.arcinstance
+}
+
+// View runs fn while holding the controller's state steady: no transaction or
+// single-entity write can land until fn returns. It implements lib.PolicyStore.
+func (c *Controller) View(fn func()) {
+	c.state.RLock()
+	defer c.state.RUnlock()
+	fn()
+}
+
+// GetUserByID resolves a principal for the Gatekeeper
+func (c *Controller) GetUserByID(id uint64) (*core.User, error) {
+	if user := c.ucinstance.GetUser(id); user != nil {
+		return user, nil
+	}
+	return nil, fmt.Errorf("User with ID %d %w", id, ErrNotFound)
+}
+
+// GetRuleByID resolves a forward-chain link for the Gatekeeper
+func (c *Controller) GetRuleByID(id uint64) (*core.Rule, error) {
+	if rule := c.rcinstance.GetRule(id); rule != nil {
+		return rule, nil
+	}
+	return nil, fmt.Errorf("Rule with ID %d %w", id, ErrNotFound)
+}
+
+// ResourceVersion returns the version of the most recent committed change
+func (c *Controller) ResourceVersion() uint64 {
+	c.state.RLock()
+	defer c.state.RUnlock()
+	return c.version
+}
+
+// stamp gives ev and the entity it carries the next resource version and
+// records it for watchers. Callers hold the state lock.
+func (c *Controller) stamp(ev *Event) {
+	c.version++
+	switch obj := ev.Object.(type) {
+	case *core.User:
+		obj.SetResourceVersion(c.version)
+	case *core.Profile:
+		obj.SetResourceVersion(c.version)
+	case *core.Rule:
+		obj.SetResourceVersion(c.version)
+	case *core.ServiceAccount:
+		obj.SetResourceVersion(c.version)
+	case *core.Grant:
+		obj.SetResourceVersion(c.version)
+	case *core.AccessRequest:
+		obj.SetResourceVersion(c.version)
+	}
+	ev.ResourceVersion = c.version
+	c.record(*ev)
+}
+
+// Start launches the background processes. It fails if the controller is
+// already running.
+func (c *Controller) Start() error {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	if c.running {
+		return fmt.Errorf("controller is already running")
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.running = true
+	c.startEventLoop()
+	c.startGarbageCollector(c.ctx)
+	c.startExpiry(c.ctx)
+	return nil
+}
+
+// Stop safely shuts down all background loops. Events published while the
+// controller is stopped stay buffered until the next Start.
+func (c *Controller) Stop() {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	if !c.running {
+		return
+	}
+	c.cancel()  // Trigger context cancellation
+	c.wg.Wait() // Wait for goroutines to finish
+	c.running = false
+	fmt.Println("All systems stopped.")
+}
+
+// Restart stops the controller if it is running and starts it again. State
+// is kept across restarts.
+func (c *Controller) Restart() error {
+	c.Stop()
+	return c.Start()
+}
+
+// IsRunning reports whether the background processes are active
+func (c *Controller) IsRunning() bool {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+	return c.running
+}
+
 // StartEventLoop runs in the background
 func (c *Controller) startEventLoop() {
-	wg.Add(1)
+	ctx := c.ctx
+	c.wg.Add(1)
 	go func() {
-		defer wg.Done()
+		defer c.wg.Done()
 		fmt.Println("Controller event loop running...")
 
 		for {
 			select {
-			case <-c.ctx.Done():
+			case <-ctx.Done():
 				fmt.Println("Controller event loop received shutdown signal")
 				return
-			case msg, ok := <-c.ucinstance.events:
-				if !ok {
-					return
-				}
+			case msg := <-c.ucinstance.events:
+				fmt.Printf("[EVENT LOG]: %s\n", msg)
+			case msg := <-c.pcinstance.events:
+				fmt.Printf("[EVENT LOG]: %s\n", msg)
+			case msg := <-c.rcinstance.events:
+				fmt.Printf("[EVENT LOG]: %s\n", msg)
+			case msg := <-c.sacinstance.events:
+				fmt.Printf("[EVENT LOG]: %s\n", msg)
+			case msg := <-c.dcinstance.events:
+				fmt.Printf("[EVENT LOG]: %s\n", msg)
+			case msg := <-c.arcinstance.events:
 				fmt.Printf("[EVENT LOG]: %s\n", msg)
 			}
 		}
 	}()
 }
 
-// Stop safely shuts down all background loops
-func (c *Controller) Stop() {
-	c.cancel() // Trigger context cancellation
-	close(c.ucinstance.events)
-	wg.Wait() // Wait for goroutines to finish
-	fmt.Println("All systems stopped.")
+// publish hands ev to the event loop without blocking the caller. When the
+// buffer is full the event is dropped rather than stalling a state change.
+func (c *Controller) publish(ev Event) {
+	var events chan Event
+	switch ev.Kind {
+	case core.ResourceTypeUser:
+		events = c.ucinstance.events
+	case core.ResourceTypeProfile:
+		events = c.pcinstance.events
+	case core.ResourceTypeServiceAccount:
+		events = c.sacinstance.events
+	case core.ResourceTypeGrant:
+		events = c.dcinstance.events
+	case core.ResourceTypeAccessRequest:
+		events = c.arcinstance.events
+	default:
+		events = c.rcinstance.events
+	}
+	select {
+	case events <- ev:
+	default:
+		atomic.AddUint64(&c.eventsDropped, 1)
+	}
 }
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNew_IndependentInstances(t *testing.T) {
+	a := New()
+	b := New()
+
+	user, _ := a.GetUserController().CreateUser("John", "User", "john@example.com")
+
+	if a.GetUserController().GetUser(user.GetResourceID()) == nil {
+		t.Fatalf("Expected user in controller A")
+	}
+	if b.GetUserController().GetUser(user.GetResourceID()) != nil {
+		t.Errorf("Expected controller B to be unaffected by controller A")
+	}
+
+	if _, err := a.GetUserController().CreateUser("John", "User", "john@example.com"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists creating the same user twice, got %v", err)
+	}
+	if a.GetUserController().GetUser(user.GetResourceID()) != user {
+		t.Errorf("Expected the existing user to be kept")
+	}
+}
+
+func TestController_Lifecycle(t *testing.T) {
+	ctrl := New(WithEventBuffer(4))
+
+	if ctrl.IsRunning() {
+		t.Fatalf("Expected new controller to be stopped")
+	}
+	if err := ctrl.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := ctrl.Start(); err == nil {
+		t.Errorf("Expected error when starting a running controller")
+	}
+
+	ctrl.Stop()
+	ctrl.Stop() // Stop is idempotent
+	if ctrl.IsRunning() {
+		t.Fatalf("Expected controller to be stopped")
+	}
+
+	// Publishing while stopped must never block, even past the buffer size.
+	for i := 0; i < 10; i++ {
+		ctrl.GetUserController().CreateUser("User", "bulk", string(rune('a'+i))+"@example.com")
+	}
+	if got := ctrl.Stats().EventsDropped; got != 6 {
+		t.Errorf("Expected the 6 events past the buffer to be counted as dropped, got %d", got)
+	}
+
+	if err := ctrl.Restart(); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	if !ctrl.IsRunning() {
+		t.Errorf("Expected controller to be running after Restart")
+	}
+	if got := len(ctrl.GetUserController().ListUsers()); got != 10 {
+		t.Errorf("Expected state to survive restart, got %d users", got)
+	}
+	ctrl.Stop()
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+// newTeam gives a lead read and update on every project and returns the
+// lead, two team members and the lead's profile
+func newTeam(t *testing.T, ctrl *Controller) (lead, member, other *core.User, profile *core.Profile) {
+	err := ctrl.Tx(func(tx *Tx) error {
+		lead, _ = tx.CreateUser("Lena", "Lead", "lena@example.com")
+		member, _ = tx.CreateUser("Max", "Member", "max@example.com")
+		other, _ = tx.CreateUser("Olga", "Member", "olga@example.com")
+		profile, _ = tx.CreateProfile("project-editors", "")
+		rule := newRule("edit-projects", core.ResourceTypeProject, core.VerbRead|core.VerbUpdate, core.ActionAllow)
+		if err := tx.CreateRule(rule); err != nil {
+			return err
+		}
+		if err := tx.AddRuleToProfile(profile.GetResourceID(), rule.GetResourceID()); err != nil {
+			return err
+		}
+		return tx.AssignProfile(lead.GetResourceID(), profile.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return lead, member, other, profile
+}
+
+func decideProject(gk *lib.Gatekeeper, principal *core.User, id uint64, verb core.Verb) lib.Decision {
+	return gk.Decide(&lib.RequestContext{PrincipalID: principal.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: id, RequestVerb: verb})
+}
+
+func TestDelegation_GrantIsSubsetOfGrantor(t *testing.T) {
+	ctrl := New()
+	lead, member, _, _ := newTeam(t, ctrl)
+	dc := ctrl.GetDelegationController()
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	expires := time.Now().Add(time.Hour)
+
+	grant, err := dc.Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeProject, "42", core.VerbRead, expires)
+	if err != nil {
+		t.Fatalf("Delegate failed: %v", err)
+	}
+	if d := decideProject(gk, member, 42, core.VerbRead); !d.Allowed || d.GrantID != grant.GetResourceID() {
+		t.Errorf("Expected read on 42 through the grant, got %+v", d)
+	}
+	if d := decideProject(gk, member, 42, core.VerbUpdate); d.Allowed {
+		t.Errorf("Expected update to stay with the lead, got %+v", d)
+	}
+	if d := decideProject(gk, member, 43, core.VerbRead); d.Allowed {
+		t.Errorf("Expected the grant to cover project 42 only, got %+v", d)
+	}
+
+	if _, err := dc.Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeProject, "42", core.VerbDelete, expires); !errors.Is(err, ErrNotDelegable) {
+		t.Errorf("Expected ErrNotDelegable for access the lead lacks, got %v", err)
+	}
+	if _, err := dc.Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeAll, core.ResourceIDAll, core.VerbRead, expires); !errors.Is(err, ErrNotDelegable) {
+		t.Errorf("Expected ErrNotDelegable for a wider target than the lead holds, got %v", err)
+	}
+	if _, err := dc.Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeProject, "42", core.VerbRead, time.Now().Add(-time.Minute)); err == nil {
+		t.Errorf("Expected a grant expiring in the past to be rejected")
+	}
+
+	// Expired grants stop allowing without anyone revoking them
+	later := &lib.RequestContext{PrincipalID: member.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 42, RequestVerb: core.VerbRead, ContextDT: expires.Add(time.Second)}
+	if d := gk.Decide(later); d.Allowed {
+		t.Errorf("Expected an expired grant to deny, got %+v", d)
+	}
+}
+
+func TestDelegation_CachedGrantExpires(t *testing.T) {
+	ctrl := New()
+	lead, member, _, _ := newTeam(t, ctrl)
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl), lib.WithDecisionCache(time.Hour, 10))
+	expires := time.Now().Add(time.Hour)
+	if _, err := ctrl.GetDelegationController().Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeProject, "42", core.VerbRead, expires); err != nil {
+		t.Fatalf("Delegate failed: %v", err)
+	}
+
+	rc := &lib.RequestContext{PrincipalID: member.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 42, RequestVerb: core.VerbRead}
+	if d := gk.Decide(rc); !d.Allowed {
+		t.Fatalf("Expected read through the grant, got %+v", d)
+	}
+	rc.ContextDT = expires.Add(time.Second)
+	if d := gk.Decide(rc); d.Allowed {
+		t.Errorf("Expected the grant to deny once expired, even with caching on, got %+v", d)
+	}
+}
+
+func TestDelegation_RedelegationAndRevocation(t *testing.T) {
+	ctrl := New(WithMaxDelegationDepth(2))
+	lead, member, other, profile := newTeam(t, ctrl)
+	dc := ctrl.GetDelegationController()
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	expires := time.Now().Add(time.Hour)
+
+	first, err := dc.Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeProject, core.ResourceIDAll, core.VerbRead, expires)
+	if err != nil {
+		t.Fatalf("Delegate failed: %v", err)
+	}
+	second, err := dc.Delegate(member.GetResourceID(), other.GetResourceID(), core.ResourceTypeProject, "7", core.VerbRead, expires)
+	if err != nil {
+		t.Fatalf("Re-delegation failed: %v", err)
+	}
+	if second.GetParentID() != first.GetResourceID() || second.GetDepth() != 2 {
+		t.Errorf("Expected second grant to hang off the first at depth 2, got parent %d depth %d", second.GetParentID(), second.GetDepth())
+	}
+	if _, err := dc.Delegate(other.GetResourceID(), lead.GetResourceID(), core.ResourceTypeProject, "7", core.VerbRead, expires); !errors.Is(err, ErrDelegationDepth) {
+		t.Errorf("Expected ErrDelegationDepth beyond depth 2, got %v", err)
+	}
+	if d := decideProject(gk, other, 7, core.VerbRead); !d.Allowed {
+		t.Fatalf("Expected read through two grants, got %+v", d)
+	}
+
+	// Grantees may not revoke; grantors upstream may
+	third, err := dc.Delegate(member.GetResourceID(), other.GetResourceID(), core.ResourceTypeProject, "8", core.VerbRead, expires)
+	if err != nil {
+		t.Fatalf("Re-delegation failed: %v", err)
+	}
+	if err := dc.RevokeGrantAs(other.GetResourceID(), third.GetResourceID()); !errors.Is(err, ErrNotRevoker) {
+		t.Errorf("Expected ErrNotRevoker for the grantee, got %v", err)
+	}
+	if err := dc.RevokeGrantAs(lead.GetResourceID(), third.GetResourceID()); err != nil || third.IsActive() || !first.IsActive() {
+		t.Errorf("Expected the upstream grantor to revoke only the third grant, got %v", err)
+	}
+
+	// Losing the lead's access takes every downstream grant with it
+	if err := ctrl.GetUserController().UnassignProfile(lead.GetResourceID(), profile.GetResourceID()); err != nil {
+		t.Fatalf("UnassignProfile failed: %v", err)
+	}
+	if d := decideProject(gk, other, 7, core.VerbRead); d.Allowed {
+		t.Errorf("Expected downstream grant to stop once the lead lost access, got %+v", d)
+	}
+
+	if err := dc.RevokeGrantAs(lead.GetResourceID(), first.GetResourceID()); err != nil {
+		t.Fatalf("RevokeGrantAs failed: %v", err)
+	}
+	if first.IsActive() || second.IsActive() {
+		t.Errorf("Expected revocation to cascade to re-delegated grants")
+	}
+}
+
+func TestDelegation_DefaultForbidsRedelegation(t *testing.T) {
+	ctrl := New()
+	lead, member, other, _ := newTeam(t, ctrl)
+	dc := ctrl.GetDelegationController()
+	expires := time.Now().Add(time.Hour)
+
+	if _, err := dc.Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeProject, "7", core.VerbRead, expires); err != nil {
+		t.Fatalf("Delegate failed: %v", err)
+	}
+	if _, err := dc.Delegate(member.GetResourceID(), other.GetResourceID(), core.ResourceTypeProject, "7", core.VerbRead, expires); !errors.Is(err, ErrDelegationDepth) {
+		t.Errorf("Expected ErrDelegationDepth by default, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+var (
+	// ErrConflict matches every *ConflictError via errors.Is
+	ErrConflict = errors.New("resource version conflict")
+
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrInactive      = errors.New("is not active")
+)
+
+// ConflictError is returned when an update names a resource version that is
+// no longer current, i.e. someone else changed the entity first.
+type ConflictError struct {
+	Kind     core.ResourceType
+	ID       uint64
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict: %s %d is at version %d, expected %d", e.Kind, e.ID, e.Actual, e.Expected)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// checkVersion returns a *ConflictError when expected is set and differs
+// from the entity's current version. An expected version of 0 skips the check.
+func checkVersion(res core.Resource, expected uint64) error {
+	if expected == 0 || res.GetResourceVersion() == expected {
+		return nil
+	}
+	return &ConflictError{
+		Kind:     res.GetResourceType(),
+		ID:       res.GetResourceID(),
+		Expected: expected,
+		Actual:   res.GetResourceVersion(),
+	}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// EventType describes what happened to an entity
+type EventType string
+
+const (
+	EventAdded    EventType = "ADDED"
+	EventModified EventType = "MODIFIED"
+	EventDeleted  EventType = "DELETED"
+)
+
+// EventPriority marks events that need a person's attention
+type EventPriority string
+
+const (
+	PriorityNormal EventPriority = ""
+	PriorityHigh   EventPriority = "high" // e.g. break-glass activations
+)
+
+// Event is a single state change published by a controller
+type Event struct {
+	Type            EventType         `json:"type"`
+	Priority        EventPriority     `json:"priority,omitempty"`
+	Kind            core.ResourceType `json:"kind"`
+	ID              uint64            `json:"id"`
+	ResourceVersion uint64            `json:"resource_version"`
+	Object          core.Resource     `json:"object"`
+	Time            time.Time         `json:"time"`
+}
+
+func newEvent(eventType EventType, object core.Resource) Event {
+	return Event{
+		Type:   eventType,
+		Kind:   object.GetResourceType(),
+		ID:     object.GetResourceID(),
+		Object: object,
+		Time:   time.Now(),
+	}
+}
+
+func (e Event) String() string {
+	if e.Priority == PriorityHigh {
+		return fmt.Sprintf("[HIGH PRIORITY] %s %s: %s (ID: %d, version: %d)", e.Kind, e.Type, e.Object.GetResourceName(), e.ID, e.ResourceVersion)
+	}
+	return fmt.Sprintf("%s %s: %s (ID: %d, version: %d)", e.Kind, e.Type, e.Object.GetResourceName(), e.ID, e.ResourceVersion)
+}
@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+const (
+	// EventPurged is published when an entity is removed for good, either
+	// by the garbage collector or by Purge.
+	EventPurged EventType = "PURGED"
+
+	defaultGCInterval = time.Minute
+)
+
+// WithRetention enables the garbage collector: soft-deleted users, profiles
+// and rules are purged once they have been deleted for longer than
+// retention. A zero retention (the default) keeps them forever.
+func WithRetention(retention time.Duration) Option {
+	return func(c *Controller) {
+		c.retention = retention
+	}
+}
+
+// WithGCInterval sets how often the garbage collector runs, and how often
+// due access requests and lapsed break-glass activations are closed
+func WithGCInterval(interval time.Duration) Option {
+	return func(c *Controller) {
+		if interval > 0 {
+			c.gcInterval = interval
+		}
+	}
+}
+
+// Purge removes the user, service account, profile, rule, grant or access
+// request with id immediately, whether or not it was soft-deleted, and drops
+// every reference to it: grants re-delegated from grants, grants from and to
+// principals, API keys of service accounts, the service accounts a user
+// owns, access requests for users and profiles, profile memberships for
+// profiles, profile attachments and forward links for rules. It is meant
+// for compliance deletions that cannot wait for the retention period.
+func (c *Controller) Purge(id uint64) error {
+	c.state.Lock()
+	events, err := c.purge(id)
+	c.state.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, ev := range events {
+		c.publish(ev)
+	}
+	return nil
+}
+
+// CollectGarbage purges every entity soft-deleted before now minus the
+// retention period and returns how many were purged. The background
+// collector calls it on every tick; it does nothing when retention is unset.
+func (c *Controller) CollectGarbage(now time.Time) int {
+	if c.retention <= 0 {
+		return 0
+	}
+	cutoff := now.Add(-c.retention)
+
+	c.state.Lock()
+	var expired []uint64
+	for _, res := range c.softDeleted() {
+		if res.GetResourceDeletedAt().Before(cutoff) {
+			expired = append(expired, res.GetResourceID())
+		}
+	}
+	// An entity may already be gone with another, e.g. a service account
+	// with its owner; it is not counted again
+	var events []Event
+	purged := 0
+	for _, id := range expired {
+		evs, err := c.purge(id)
+		if err == nil {
+			events = append(events, evs...)
+			purged++
+		}
+	}
+	c.state.Unlock()
+
+	for _, ev := range events {
+		c.publish(ev)
+	}
+	return purged
+}
+
+// startGarbageCollector runs CollectGarbage every gcInterval until ctx ends
+func (c *Controller) startGarbageCollector(ctx context.Context) {
+	if c.retention <= 0 {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.gcInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.CollectGarbage(now)
+			}
+		}
+	}()
+}
+
+// softDeleted lists every soft-deleted entity. Callers hold the state lock.
+func (c *Controller) softDeleted() []core.Resource {
+	var list []core.Resource
+	for _, u := range c.ucinstance.ListUsers() {
+		if !u.IsActive() {
+			list = append(list, u)
+		}
+	}
+	for _, p := range c.pcinstance.ListProfiles() {
+		if !p.IsActive() {
+			list = append(list, p)
+		}
+	}
+	for _, r := range c.rcinstance.ListRules() {
+		if !r.IsActive() {
+			list = append(list, r)
+		}
+	}
+	for _, sa := range c.sacinstance.ListServiceAccounts() {
+		if !sa.IsActive() {
+			list = append(list, sa)
+		}
+	}
+	for _, g := range c.dcinstance.ListGrants() {
+		if !g.IsActive() {
+			list = append(list, g)
+		}
+	}
+	for _, ar := range c.arcinstance.ListAccessRequests() {
+		if !ar.IsActive() {
+			list = append(list, ar)
+		}
+	}
+	return list
+}
+
+// purge removes id and its references, returning the stamped events.
+// Callers hold the state lock.
+func (c *Controller) purge(id uint64) ([]Event, error) {
+	var events []Event
+	emit := func(eventType EventType, res core.Resource) {
+		ev := newEvent(eventType, res)
+		c.stamp(&ev)
+		events = append(events, ev)
+	}
+
+	// A purged grant takes every grant re-delegated from it, as RevokeGrant
+	// does
+	purgeGrant := func(g *core.Grant) {
+		pending := []*core.Grant{g}
+		for len(pending) > 0 {
+			g := pending[0]
+			pending = pending[1:]
+			if c.dcinstance.GetGrant(g.GetResourceID()) == nil {
+				continue
+			}
+			c.dcinstance.remove(g.GetResourceID())
+			emit(EventPurged, g)
+			pending = append(pending, c.dcinstance.children(g.GetResourceID())...)
+		}
+	}
+
+	// Grants from or to a purged principal go with it
+	purgeGrants := func(principalID uint64) {
+		for _, g := range c.dcinstance.ListGrants() {
+			if g.GetGrantorID() == principalID || g.GetGranteeID() == principalID {
+				purgeGrant(g)
+			}
+		}
+	}
+
+	// Access requests by a purged user or for a purged profile go with it
+	purgeAccessRequests := func(match func(ar *core.AccessRequest) bool) {
+		for _, ar := range c.arcinstance.ListAccessRequests() {
+			if match(ar) {
+				c.arcinstance.remove(ar.GetResourceID())
+				emit(EventPurged, ar)
+			}
+		}
+	}
+
+	// A purged service account takes its grants and API keys with it
+	purgeServiceAccount := func(sa *core.ServiceAccount) {
+		purgeGrants(sa.GetResourceID())
+		c.sacinstance.remove(sa.GetResourceID())
+		emit(EventPurged, sa)
+	}
+
+	if u := c.ucinstance.GetUser(id); u != nil {
+		for _, sa := range c.sacinstance.ListServiceAccounts() {
+			if sa.GetOwnerID() == id {
+				purgeServiceAccount(sa)
+			}
+		}
+		purgeGrants(id)
+		purgeAccessRequests(func(ar *core.AccessRequest) bool { return ar.GetRequesterID() == id })
+		c.ucinstance.remove(id)
+		emit(EventPurged, u)
+		return events, nil
+	}
+
+	if sa := c.sacinstance.GetServiceAccount(id); sa != nil {
+		purgeServiceAccount(sa)
+		return events, nil
+	}
+
+	if g := c.dcinstance.GetGrant(id); g != nil {
+		purgeGrant(g)
+		return events, nil
+	}
+
+	if ar := c.arcinstance.GetAccessRequest(id); ar != nil {
+		c.arcinstance.remove(id)
+		emit(EventPurged, ar)
+		return events, nil
+	}
+
+	if p := c.pcinstance.GetProfile(id); p != nil {
+		purgeAccessRequests(func(ar *core.AccessRequest) bool { return ar.GetProfileID() == id })
+		for _, u := range c.ucinstance.ListUsers() {
+			if u.HasProfile(id) {
+				emit(EventModified, u.RemoveProfile(p))
+			}
+		}
+		for _, sa := range c.sacinstance.ListServiceAccounts() {
+			if sa.HasProfile(id) {
+				emit(EventModified, sa.RemoveProfile(p))
+			}
+		}
+		for _, other := range c.pcinstance.ListProfiles() {
+			if other.GetConflict(id) != core.SoDNone {
+				emit(EventModified, other.SetConflict(id, core.SoDNone))
+			}
+		}
+		c.pcinstance.remove(id)
+		emit(EventPurged, p)
+		return events, nil
+	}
+
+	if r := c.rcinstance.GetRule(id); r != nil {
+		for _, p := range c.pcinstance.ListProfiles() {
+			if p.HasRule(id) {
+				emit(EventModified, p.RemoveRule(id))
+			}
+		}
+		for _, other := range c.rcinstance.ListRules() {
+			if other.GetResourceForwardRuleID() == id {
+				emit(EventModified, other.ClearForwardRule())
+			}
+		}
+		c.rcinstance.remove(id)
+		emit(EventPurged, r)
+		return events, nil
+	}
+
+	return nil, fmt.Errorf("Entity with ID %d %w", id, ErrNotFound)
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+func TestCollectGarbage_PurgesExpiredAndCleansReferences(t *testing.T) {
+	ctrl := New(WithRetention(time.Hour))
+
+	target := newRule("read-projects", core.ResourceTypeProject, core.VerbRead, core.ActionAllow)
+	forward := core.NewEmptyRule("forward-to-read")
+	forward.UpdateVerb(core.VerbRead)
+	forward.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+	forward.UpdateAction(core.ActionOption{Action: core.ActionAllowAndForwardToNextRule, NextRuleID: target.GetResourceID()})
+
+	var user *core.User
+	var profile *core.Profile
+	err := ctrl.Tx(func(tx *Tx) error {
+		user, _ = tx.CreateUser("John", "User", "john@example.com")
+		profile, _ = tx.CreateProfile("readers", "read")
+		tx.CreateRule(target)
+		tx.CreateRule(forward)
+		tx.AddRuleToProfile(profile.GetResourceID(), target.GetResourceID())
+		return tx.AssignProfile(user.GetResourceID(), profile.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("Tx failed: %v", err)
+	}
+
+	ctrl.GetRuleController().DeleteRule(target.GetResourceID())
+	ctrl.GetProfileController().DeleteProfile(profile.GetResourceID())
+
+	if n := ctrl.CollectGarbage(time.Now()); n != 0 {
+		t.Fatalf("Expected nothing purged inside the retention period, got %d", n)
+	}
+
+	w, _ := ctrl.Watch(core.ResourceTypeAll, ctrl.ResourceVersion())
+	defer w.Stop()
+
+	if n := ctrl.CollectGarbage(time.Now().Add(2 * time.Hour)); n != 2 {
+		t.Fatalf("Expected 2 entities purged, got %d", n)
+	}
+	if ctrl.GetRuleController().GetRule(target.GetResourceID()) != nil {
+		t.Errorf("Expected rule to be purged")
+	}
+	if user.HasProfile(profile.GetResourceID()) {
+		t.Errorf("Expected purged profile to be removed from the user")
+	}
+	if forward.GetResourceForwardRuleID() != 0 {
+		t.Errorf("Expected forward link to purged rule to be cleared")
+	}
+
+	purged := 0
+	for len(w.ResultChan()) > 0 {
+		if ev := <-w.ResultChan(); ev.Type == EventPurged {
+			purged++
+		}
+	}
+	if purged != 2 {
+		t.Errorf("Expected 2 PURGED events, got %d", purged)
+	}
+}
+
+func TestPurge_ActiveEntityForCompliance(t *testing.T) {
+	ctrl := New()
+	user, _ := ctrl.GetUserController().CreateUser("Jane", "User", "jane@example.com")
+
+	if err := ctrl.Purge(user.GetResourceID()); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if ctrl.GetUserController().GetUser(user.GetResourceID()) != nil {
+		t.Errorf("Expected user to be gone after Purge")
+	}
+	if err := ctrl.Purge(user.GetResourceID()); err == nil {
+		t.Errorf("Expected error purging an unknown ID")
+	}
+}
+
+func TestPurge_UserTakesOwnedServiceAccounts(t *testing.T) {
+	ctrl := New()
+	sa := newDeployBot(t, ctrl)
+	sac := ctrl.GetServiceAccountController()
+	key, err := sac.IssueAPIKey(sa.GetResourceID(), time.Time{})
+	if err != nil {
+		t.Fatalf("IssueAPIKey failed: %v", err)
+	}
+
+	if err := ctrl.Purge(sa.GetOwnerID()); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if sac.GetServiceAccount(sa.GetResourceID()) != nil {
+		t.Errorf("Expected the owner's service account to be purged with it")
+	}
+	if _, err := ctrl.AuthenticateAPIKey(key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected the service account's API key to stop working, got %v", err)
+	}
+}
+
+func TestCollectGarbage_CountsOnlyPurgesThatHappen(t *testing.T) {
+	ctrl := New(WithRetention(time.Hour))
+	sa := newDeployBot(t, ctrl)
+	ctrl.GetServiceAccountController().DeleteServiceAccount(sa.GetResourceID())
+	ctrl.GetUserController().DeleteUser(sa.GetOwnerID())
+
+	// The account goes with its owner, so purging it on its own fails
+	if n := ctrl.CollectGarbage(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Errorf("Expected 1 purge, got %d", n)
+	}
+	if ctrl.GetServiceAccountController().GetServiceAccount(sa.GetResourceID()) != nil {
+		t.Errorf("Expected the service account to be purged with its owner")
+	}
+}
+
+func TestPurge_UserTakesRedelegatedGrants(t *testing.T) {
+	ctrl := New(WithMaxDelegationDepth(2))
+	lead, member, other, _ := newTeam(t, ctrl)
+	dc := ctrl.GetDelegationController()
+	expires := time.Now().Add(time.Hour)
+	first, err := dc.Delegate(lead.GetResourceID(), member.GetResourceID(), core.ResourceTypeProject, core.ResourceIDAll, core.VerbRead, expires)
+	if err != nil {
+		t.Fatalf("Delegate failed: %v", err)
+	}
+	second, err := dc.Delegate(member.GetResourceID(), other.GetResourceID(), core.ResourceTypeProject, "7", core.VerbRead, expires)
+	if err != nil {
+		t.Fatalf("Re-delegation failed: %v", err)
+	}
+
+	if err := ctrl.Purge(lead.GetResourceID()); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	for _, g := range []*core.Grant{first, second} {
+		if dc.GetGrant(g.GetResourceID()) != nil {
+			t.Errorf("Expected grant %d to be purged with the lead", g.GetResourceID())
+		}
+	}
+}
@@ -6,9 +6,89 @@ import (
 	"github.com/farhansabbir/rbac/core"
 )
 
+// ProfileController manages profile state and events
 type ProfileController struct {
 	id       uint64
+	ctrl     *Controller
 	mux      sync.RWMutex
 	profiles map[uint64]*core.Profile
-	events   chan string
+	events   chan Event
+}
+
+// --- ProfileController Methods ---
+
+func (pc *ProfileController) CreateProfile(name, description string) (*core.Profile, error) {
+	var p *core.Profile
+	err := pc.ctrl.Tx(func(tx *Tx) error {
+		var err error
+		p, err = tx.CreateProfile(name, description)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (pc *ProfileController) GetProfile(id uint64) *core.Profile {
+	pc.mux.RLock()
+	defer pc.mux.RUnlock()
+	return pc.profiles[id]
+}
+
+// UpdateProfile changes a profile's name and description, guarded by
+// expectedVersion like UserController.UpdateUser.
+func (pc *ProfileController) UpdateProfile(id, expectedVersion uint64, name, description string) (*core.Profile, error) {
+	err := pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.UpdateProfile(id, expectedVersion, name, description)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pc.GetProfile(id), nil
+}
+
+func (pc *ProfileController) DeleteProfile(id uint64) bool {
+	return pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.DeleteProfile(id)
+	}) == nil
+}
+
+// AddRule attaches an existing rule to an existing profile
+func (pc *ProfileController) AddRule(profileID, ruleID uint64) error {
+	return pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.AddRuleToProfile(profileID, ruleID)
+	})
+}
+
+// RemoveRule detaches a rule from a profile
+func (pc *ProfileController) RemoveRule(profileID, ruleID uint64) error {
+	return pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.RemoveRuleFromProfile(profileID, ruleID)
+	})
+}
+
+func (pc *ProfileController) ListProfiles() []*core.Profile {
+	pc.mux.RLock()
+	defer pc.mux.RUnlock()
+
+	list := make([]*core.Profile, 0, len(pc.profiles))
+	for _, p := range pc.profiles {
+		list = append(list, p)
+	}
+	return list
+}
+
+// put stores p; callers hold the controller state lock
+func (pc *ProfileController) put(p *core.Profile) {
+	pc.mux.Lock()
+	pc.profiles[p.GetResourceID()] = p
+	pc.mux.Unlock()
+}
+
+// remove deletes id from the store; callers hold the controller state lock
+func (pc *ProfileController) remove(id uint64) {
+	pc.mux.Lock()
+	delete(pc.profiles, id)
+	pc.mux.Unlock()
 }
@@ -6,9 +6,70 @@ import (
 	"github.com/farhansabbir/rbac/core"
 )
 
+// RuleController manages rule state and events
 type RuleController struct {
 	id     uint64
+	ctrl   *Controller
 	mux    sync.RWMutex
 	rules  map[uint64]*core.Rule
-	events chan string
+	events chan Event
+}
+
+// --- RuleController Methods ---
+
+// CreateRule registers a syntactically valid rule
+func (rc *RuleController) CreateRule(rule *core.Rule) error {
+	return rc.ctrl.Tx(func(tx *Tx) error {
+		return tx.CreateRule(rule)
+	})
+}
+
+func (rc *RuleController) GetRule(id uint64) *core.Rule {
+	rc.mux.RLock()
+	defer rc.mux.RUnlock()
+	return rc.rules[id]
+}
+
+// UpdateRule applies mutate to a copy of the rule and, if the result is
+// valid and expectedVersion still matches (0 skips the check), commits it.
+// mutate may use any of the core.Rule mutators.
+func (rc *RuleController) UpdateRule(id, expectedVersion uint64, mutate func(rule *core.Rule) error) (*core.Rule, error) {
+	err := rc.ctrl.Tx(func(tx *Tx) error {
+		return tx.UpdateRule(id, expectedVersion, mutate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rc.GetRule(id), nil
+}
+
+func (rc *RuleController) DeleteRule(id uint64) bool {
+	return rc.ctrl.Tx(func(tx *Tx) error {
+		return tx.DeleteRule(id)
+	}) == nil
+}
+
+func (rc *RuleController) ListRules() []*core.Rule {
+	rc.mux.RLock()
+	defer rc.mux.RUnlock()
+
+	list := make([]*core.Rule, 0, len(rc.rules))
+	for _, r := range rc.rules {
+		list = append(list, r)
+	}
+	return list
+}
+
+// put stores r; callers hold the controller state lock
+func (rc *RuleController) put(r *core.Rule) {
+	rc.mux.Lock()
+	rc.rules[r.GetResourceID()] = r
+	rc.mux.Unlock()
+}
+
+// remove deletes id from the store; callers hold the controller state lock
+func (rc *RuleController) remove(id uint64) {
+	rc.mux.Lock()
+	delete(rc.rules, id)
+	rc.mux.Unlock()
 }
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+// newDeployBot returns a service account holding a read-projects profile
+func newDeployBot(t *testing.T, ctrl *Controller) *core.ServiceAccount {
+	var sa *core.ServiceAccount
+	err := ctrl.Tx(func(tx *Tx) error {
+		owner, err := tx.CreateUser("John", "User", "john@example.com")
+		if err != nil {
+			return err
+		}
+		if sa, err = tx.CreateServiceAccount("deploy-bot", "CI deployments", owner.GetResourceID()); err != nil {
+			return err
+		}
+		profile, err := tx.CreateProfile("readers", "read projects")
+		if err != nil {
+			return err
+		}
+		rule := newRule("read-projects", core.ResourceTypeProject, core.VerbRead, core.ActionAllow)
+		if err := tx.CreateRule(rule); err != nil {
+			return err
+		}
+		if err := tx.AddRuleToProfile(profile.GetResourceID(), rule.GetResourceID()); err != nil {
+			return err
+		}
+		return tx.AssignProfile(sa.GetResourceID(), profile.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return sa
+}
+
+func TestServiceAccount_APIKeyResolvesToPrincipal(t *testing.T) {
+	ctrl := New()
+	sa := newDeployBot(t, ctrl)
+	sac := ctrl.GetServiceAccountController()
+
+	key, err := sac.IssueAPIKey(sa.GetResourceID(), time.Time{})
+	if err != nil {
+		t.Fatalf("IssueAPIKey failed: %v", err)
+	}
+	principal, err := ctrl.AuthenticateAPIKey(key)
+	if err != nil || principal.GetResourceID() != sa.GetResourceID() {
+		t.Fatalf("Expected key to resolve to %d, got %v (%v)", sa.GetResourceID(), principal, err)
+	}
+
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	ctx, _ := lib.NewRequestContext(principal.GetResourceID(), core.ResourceTypeProject, 42, core.VerbRead, nil)
+	if d := gk.Decide(ctx); !d.Allowed {
+		t.Errorf("Expected service account to be allowed, got %s", d.Reason)
+	}
+
+	last := byte('0')
+	if key[len(key)-1] == '0' {
+		last = '1'
+	}
+	for name, presented := range map[string]string{
+		"malformed":    "not-a-key",
+		"wrong secret": key[:len(key)-1] + string(last),
+		"unknown id":   core.APIKeyPrefix + "_0000000000000000_" + key[len(key)-64:],
+	} {
+		if _, err := ctrl.AuthenticateAPIKey(presented); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("%s: expected ErrInvalidAPIKey, got %v", name, err)
+		}
+	}
+
+	if !sac.DeleteServiceAccount(sa.GetResourceID()) {
+		t.Fatalf("DeleteServiceAccount failed")
+	}
+	if _, err := ctrl.AuthenticateAPIKey(key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected keys of a deleted account to fail, got %v", err)
+	}
+}
+
+func TestServiceAccount_RotateAndRevokeEmitEvents(t *testing.T) {
+	ctrl := New()
+	sa := newDeployBot(t, ctrl)
+	sac := ctrl.GetServiceAccountController()
+
+	expired, err := sac.IssueAPIKey(sa.GetResourceID(), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("IssueAPIKey failed: %v", err)
+	}
+	if _, err := ctrl.AuthenticateAPIKey(expired); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected expired key to fail, got %v", err)
+	}
+
+	old, _ := sac.IssueAPIKey(sa.GetResourceID(), time.Time{})
+	oldID, _, _ := core.ParseAPIKey(old)
+	_, version := ctrl.List(core.ResourceTypeServiceAccount)
+
+	w, err := ctrl.Watch(core.ResourceTypeServiceAccount, version)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+
+	// A grace period keeps the old key usable while clients switch over
+	rotated, err := sac.RotateAPIKey(sa.GetResourceID(), oldID, time.Time{}, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateAPIKey failed: %v", err)
+	}
+	for _, key := range []string{old, rotated} {
+		if _, err := ctrl.AuthenticateAPIKey(key); err != nil {
+			t.Errorf("Expected key to work during grace period, got %v", err)
+		}
+	}
+
+	if err := sac.RevokeAPIKey(sa.GetResourceID(), oldID); err != nil {
+		t.Fatalf("RevokeAPIKey failed: %v", err)
+	}
+	if err := sac.RevokeAPIKey(sa.GetResourceID(), "feedfacefeedface"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown key, got %v", err)
+	}
+	if _, err := ctrl.AuthenticateAPIKey(old); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected revoked key to fail, got %v", err)
+	}
+	if _, err := ctrl.AuthenticateAPIKey(rotated); err != nil {
+		t.Errorf("Expected rotated key to keep working, got %v", err)
+	}
+
+	// Rotation issues and schedules a revocation; revoking is one more change
+	for i := 0; i < 3; i++ {
+		ev := <-w.ResultChan()
+		if ev.Type != EventModified || ev.Object.GetResourceID() != sa.GetResourceID() {
+			t.Fatalf("Expected MODIFIED event for the account, got %s", ev)
+		}
+	}
+}
+
+func TestServiceAccount_OwnerMustBeActive(t *testing.T) {
+	ctrl := New()
+	owner, _ := ctrl.GetUserController().CreateUser("John", "User", "john@example.com")
+	ctrl.GetUserController().DeleteUser(owner.GetResourceID())
+
+	_, err := ctrl.GetServiceAccountController().CreateServiceAccount("bot", "", owner.GetResourceID())
+	if !errors.Is(err, ErrInactive) {
+		t.Errorf("Expected ErrInactive for a deleted owner, got %v", err)
+	}
+	if _, err := ctrl.GetServiceAccountController().CreateServiceAccount("bot", "", 12345); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown owner, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// ErrInvalidAPIKey is returned for API keys that are malformed, unknown,
+// expired or revoked, or whose service account is not active. The cases are
+// deliberately not told apart.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// ServiceAccountController manages service account state, API keys and
+// events
+type ServiceAccountController struct {
+	id       uint64
+	ctrl     *Controller
+	mux      sync.RWMutex
+	accounts map[uint64]*core.ServiceAccount
+	keys     map[string]uint64 // API key ID -> account ID
+	events   chan Event
+}
+
+// --- ServiceAccountController Methods ---
+
+// CreateServiceAccount registers a service account owned by an active user
+func (sc *ServiceAccountController) CreateServiceAccount(name, description string, ownerID uint64) (*core.ServiceAccount, error) {
+	var sa *core.ServiceAccount
+	err := sc.ctrl.Tx(func(tx *Tx) error {
+		var err error
+		sa, err = tx.CreateServiceAccount(name, description, ownerID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+func (sc *ServiceAccountController) GetServiceAccount(id uint64) *core.ServiceAccount {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return sc.accounts[id]
+}
+
+func (sc *ServiceAccountController) DeleteServiceAccount(id uint64) bool {
+	return sc.ctrl.Tx(func(tx *Tx) error {
+		return tx.DeleteServiceAccount(id)
+	}) == nil
+}
+
+// AssignProfile grants an existing profile to a service account
+func (sc *ServiceAccountController) AssignProfile(accountID, profileID uint64) error {
+	return sc.ctrl.Tx(func(tx *Tx) error {
+		return tx.AssignProfile(accountID, profileID)
+	})
+}
+
+// IssueAPIKey adds a key to the account, expiring at expiresAt (zero for
+// never), and returns the key to hand to the client. It cannot be recovered
+// later.
+func (sc *ServiceAccountController) IssueAPIKey(accountID uint64, expiresAt time.Time) (string, error) {
+	var presented string
+	err := sc.ctrl.Tx(func(tx *Tx) error {
+		var err error
+		presented, err = tx.IssueAPIKey(accountID, expiresAt)
+		return err
+	})
+	return presented, err
+}
+
+// RotateAPIKey issues a replacement for keyID and revokes keyID once grace
+// has passed, so clients can switch over without an outage
+func (sc *ServiceAccountController) RotateAPIKey(accountID uint64, keyID string, expiresAt time.Time, grace time.Duration) (string, error) {
+	var presented string
+	err := sc.ctrl.Tx(func(tx *Tx) error {
+		var err error
+		presented, err = tx.RotateAPIKey(accountID, keyID, expiresAt, grace)
+		return err
+	})
+	return presented, err
+}
+
+// RevokeAPIKey makes keyID unusable immediately
+func (sc *ServiceAccountController) RevokeAPIKey(accountID uint64, keyID string) error {
+	return sc.ctrl.Tx(func(tx *Tx) error {
+		return tx.RevokeAPIKey(accountID, keyID, time.Now())
+	})
+}
+
+func (sc *ServiceAccountController) ListServiceAccounts() []*core.ServiceAccount {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+
+	list := make([]*core.ServiceAccount, 0, len(sc.accounts))
+	for _, sa := range sc.accounts {
+		list = append(list, sa)
+	}
+	return list
+}
+
+// put stores sa and indexes its keys; callers hold the controller state
+// lock
+func (sc *ServiceAccountController) put(sa *core.ServiceAccount) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	sc.accounts[sa.GetResourceID()] = sa
+	for _, k := range sa.GetAPIKeys() {
+		sc.keys[k.GetID()] = sa.GetResourceID()
+	}
+}
+
+// addKey attaches key to the stored account; callers hold the controller
+// state lock
+func (sc *ServiceAccountController) addKey(accountID uint64, key *core.APIKey) *core.ServiceAccount {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	sc.keys[key.GetID()] = accountID
+	return sc.accounts[accountID].AddAPIKey(key)
+}
+
+// remove deletes id and its key index entries; callers hold the controller
+// state lock
+func (sc *ServiceAccountController) remove(id uint64) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	delete(sc.accounts, id)
+	for keyID, owner := range sc.keys {
+		if owner == id {
+			delete(sc.keys, keyID)
+		}
+	}
+}
+
+// AuthenticateAPIKey resolves a presented API key to its active service
+// account, which can then be used as the PrincipalID of a request
+func (c *Controller) AuthenticateAPIKey(presented string) (*core.ServiceAccount, error) {
+	keyID, secret, err := core.ParseAPIKey(presented)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	c.sacinstance.mux.RLock()
+	sa := c.sacinstance.accounts[c.sacinstance.keys[keyID]]
+	c.sacinstance.mux.RUnlock()
+
+	if sa == nil || !sa.IsActive() || !sa.Authenticate(keyID, secret, time.Now()) {
+		return nil, ErrInvalidAPIKey
+	}
+	return sa, nil
+}
+
+// GetPrincipalByID resolves a user or service account for the Gatekeeper
+func (c *Controller) GetPrincipalByID(id uint64) (core.Principal, error) {
+	if u := c.ucinstance.GetUser(id); u != nil {
+		return u, nil
+	}
+	if sa := c.sacinstance.GetServiceAccount(id); sa != nil {
+		return sa, nil
+	}
+	return nil, fmt.Errorf("Principal with ID %d %w", id, ErrNotFound)
+}
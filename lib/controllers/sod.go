@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// EventViolation is published when a transaction is refused for breaking a
+// separation-of-duties constraint. Nothing was changed; Object is the
+// principal the refused change was for.
+const EventViolation EventType = "VIOLATION"
+
+// SetConflict declares a separation-of-duties constraint between two
+// profiles; core.SoDNone lifts it. A static constraint is refused with a
+// *core.SoDViolation while any principal holds both profiles.
+func (pc *ProfileController) SetConflict(profileID, otherID uint64, kind core.SoDKind) error {
+	return pc.ctrl.Tx(func(tx *Tx) error {
+		return tx.SetProfileConflict(profileID, otherID, kind)
+	})
+}
+
+// reportViolation publishes an EventViolation when err is a
+// *core.SoDViolation for a principal that exists, and returns err. The
+// event takes a resource version of its own so watchers can resume past it.
+func (c *Controller) reportViolation(err error) error {
+	var violation *core.SoDViolation
+	if !errors.As(err, &violation) {
+		return err
+	}
+	principal, lookupErr := c.GetPrincipalByID(violation.PrincipalID)
+	if lookupErr != nil {
+		return err
+	}
+
+	ev := newEvent(EventViolation, principal)
+	c.state.Lock()
+	c.version++
+	ev.ResourceVersion = c.version
+	c.record(ev)
+	c.state.Unlock()
+	c.publish(ev)
+	return err
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+func TestSoD_StaticConflictRefusesAssignment(t *testing.T) {
+	ctrl := New()
+	pc := ctrl.GetProfileController()
+	initiator, _ := pc.CreateProfile("payments-initiator", "")
+	approver, _ := pc.CreateProfile("payments-approver", "")
+	user, _ := ctrl.GetUserController().CreateUser("Pat", "Payments", "pat@example.com")
+
+	if err := pc.SetConflict(initiator.GetResourceID(), approver.GetResourceID(), core.SoDStatic); err != nil {
+		t.Fatalf("SetConflict failed: %v", err)
+	}
+	if approver.GetConflict(initiator.GetResourceID()) != core.SoDStatic {
+		t.Errorf("Expected the constraint on both profiles")
+	}
+	if err := ctrl.GetUserController().AssignProfile(user.GetResourceID(), initiator.GetResourceID()); err != nil {
+		t.Fatalf("AssignProfile failed: %v", err)
+	}
+
+	_, version := ctrl.List(core.ResourceTypeUser)
+	w, err := ctrl.Watch(core.ResourceTypeUser, version)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+
+	err = ctrl.GetUserController().AssignProfile(user.GetResourceID(), approver.GetResourceID())
+	var violation *core.SoDViolation
+	if !errors.As(err, &violation) || violation.ProfileID != approver.GetResourceID() || violation.ConflictingProfileID != initiator.GetResourceID() {
+		t.Fatalf("Expected a SoD violation, got %v", err)
+	}
+	if user.HasProfile(approver.GetResourceID()) {
+		t.Errorf("Expected the refused profile not to be assigned")
+	}
+	if ev := <-w.ResultChan(); ev.Type != EventViolation || ev.ID != user.GetResourceID() {
+		t.Errorf("Expected a VIOLATION event for the user, got %s", ev)
+	}
+
+	// Both sides staged in one transaction are caught too
+	err = ctrl.Tx(func(tx *Tx) error {
+		other, err := tx.CreateUser("Quinn", "Payments", "quinn@example.com")
+		if err != nil {
+			return err
+		}
+		if err := tx.AssignProfile(other.GetResourceID(), approver.GetResourceID()); err != nil {
+			return err
+		}
+		return tx.AssignProfile(other.GetResourceID(), initiator.GetResourceID())
+	})
+	if !errors.Is(err, core.ErrSoDViolation) {
+		t.Errorf("Expected ErrSoDViolation within a transaction, got %v", err)
+	}
+}
+
+func TestSoD_StaticConflictNeedsNoCurrentHolder(t *testing.T) {
+	ctrl := New()
+	pc := ctrl.GetProfileController()
+	initiator, _ := pc.CreateProfile("payments-initiator", "")
+	approver, _ := pc.CreateProfile("payments-approver", "")
+	user, _ := ctrl.GetUserController().CreateUser("Pat", "Payments", "pat@example.com")
+	ctrl.GetUserController().AssignProfile(user.GetResourceID(), initiator.GetResourceID())
+	ctrl.GetUserController().AssignProfile(user.GetResourceID(), approver.GetResourceID())
+
+	if err := pc.SetConflict(initiator.GetResourceID(), approver.GetResourceID(), core.SoDStatic); !errors.Is(err, core.ErrSoDViolation) {
+		t.Errorf("Expected ErrSoDViolation while Pat holds both, got %v", err)
+	}
+	if err := pc.SetConflict(initiator.GetResourceID(), approver.GetResourceID(), core.SoDDynamic); err != nil {
+		t.Errorf("Expected a dynamic constraint to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"sync/atomic"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// Stats is a point-in-time summary of a controller, e.g. for metrics
+type Stats struct {
+	ResourceVersion uint64
+	Users           EntityCount
+	Profiles        EntityCount
+	Rules           EntityCount
+	ServiceAccounts EntityCount
+	Grants          EntityCount // revoked grants count as deleted
+	AccessRequests  EntityCount // closed requests count as deleted
+
+	// EventQueueDepth holds how many events wait for the event loop, per
+	// kind; each queue holds at most EventQueueCapacity.
+	EventQueueDepth    map[core.ResourceType]int
+	EventQueueCapacity int
+	EventsDropped      uint64 // events lost to a full queue since New
+	Watchers           int
+}
+
+// EntityCount splits the entities of one kind by state
+type EntityCount struct {
+	Active  int
+	Deleted int // soft-deleted, awaiting garbage collection
+}
+
+func (ec *EntityCount) add(r core.Resource) {
+	if r.IsActive() {
+		ec.Active++
+	} else {
+		ec.Deleted++
+	}
+}
+
+// Stats summarises the controller's current state
+func (c *Controller) Stats() Stats {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	s := Stats{
+		ResourceVersion: c.version,
+		EventQueueDepth: map[core.ResourceType]int{
+			core.ResourceTypeUser:           len(c.ucinstance.events),
+			core.ResourceTypeProfile:        len(c.pcinstance.events),
+			core.ResourceTypeRule:           len(c.rcinstance.events),
+			core.ResourceTypeServiceAccount: len(c.sacinstance.events),
+			core.ResourceTypeGrant:          len(c.dcinstance.events),
+			core.ResourceTypeAccessRequest:  len(c.arcinstance.events),
+		},
+		EventQueueCapacity: c.eventBuffer,
+		EventsDropped:      atomic.LoadUint64(&c.eventsDropped),
+	}
+	for _, u := range c.ucinstance.ListUsers() {
+		s.Users.add(u)
+	}
+	for _, p := range c.pcinstance.ListProfiles() {
+		s.Profiles.add(p)
+	}
+	for _, r := range c.rcinstance.ListRules() {
+		s.Rules.add(r)
+	}
+	for _, sa := range c.sacinstance.ListServiceAccounts() {
+		s.ServiceAccounts.add(sa)
+	}
+	for _, g := range c.dcinstance.ListGrants() {
+		s.Grants.add(g)
+	}
+	for _, ar := range c.arcinstance.ListAccessRequests() {
+		s.AccessRequests.add(ar)
+	}
+
+	c.watchMux.Lock()
+	s.Watchers = len(c.watchers)
+	c.watchMux.Unlock()
+	return s
+}
@@ -0,0 +1,1268 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// Tx stages changes to users, service accounts, profiles, rules, grants and
+// access requests. Nothing staged is visible outside the transaction until Controller.Tx
+// commits it, and then every change lands at once.
+type Tx struct {
+	ctrl  *Controller
+	view  *txView // staging view, used to reject bad changes early
+	ops   []txOp
+	err   error
+	users map[uint64]*core.User
+	profs map[uint64]*core.Profile
+	rules map[uint64]*core.Rule
+	accts map[uint64]*core.ServiceAccount
+}
+
+// txOp is one staged change. check validates it against v and records its
+// effect there; apply performs it on live state and returns its event.
+type txOp struct {
+	check func(v *txView) error
+	apply func() Event
+}
+
+// txView overlays the effects of already-checked ops on live state
+type txView struct {
+	ctrl    *Controller
+	tx      *Tx
+	created map[uint64]core.Resource
+	deleted map[uint64]bool
+	linked  map[[2]uint64]bool         // (owner, member) -> attached
+	keys    map[string]uint64          // staged API key ID -> account ID
+	sod     map[[2]uint64]core.SoDKind // staged constraints by sodPair
+}
+
+// Tx runs fn against a new transaction and commits its staged changes
+// atomically. If fn returns an error, or any staged change fails validation,
+// nothing is applied and no events are published, except an EventViolation
+// when the failure is a *core.SoDViolation. fn must not call other mutating
+// controller methods.
+func (c *Controller) Tx(fn func(tx *Tx) error) error {
+	tx := &Tx{
+		ctrl:  c,
+		users: make(map[uint64]*core.User),
+		profs: make(map[uint64]*core.Profile),
+		rules: make(map[uint64]*core.Rule),
+		accts: make(map[uint64]*core.ServiceAccount),
+	}
+	tx.view = tx.newView()
+	return c.reportViolation(tx.run(fn))
+}
+
+func (tx *Tx) run(fn func(tx *Tx) error) error {
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+	return tx.commit()
+}
+
+func (tx *Tx) commit() error {
+	c := tx.ctrl
+	c.state.Lock()
+
+	// Re-validate from scratch: live state may have moved since staging.
+	view := tx.newView()
+	for _, op := range tx.ops {
+		if err := op.check(view); err != nil {
+			c.state.Unlock()
+			return err
+		}
+	}
+
+	events := make([]Event, 0, len(tx.ops))
+	for _, op := range tx.ops {
+		ev := op.apply()
+		c.stamp(&ev)
+		events = append(events, ev)
+	}
+	c.state.Unlock()
+
+	for _, ev := range events {
+		c.publish(ev)
+	}
+	return nil
+}
+
+func (tx *Tx) stage(op txOp) error {
+	if tx.err != nil {
+		return tx.err
+	}
+	if err := op.check(tx.view); err != nil {
+		return tx.fail(err)
+	}
+	tx.ops = append(tx.ops, op)
+	return nil
+}
+
+// fail records err as the transaction's outcome and returns it
+func (tx *Tx) fail(err error) error {
+	if tx.err == nil {
+		tx.err = err
+	}
+	return tx.err
+}
+
+func (tx *Tx) newView() *txView {
+	return &txView{
+		ctrl:    tx.ctrl,
+		tx:      tx,
+		created: make(map[uint64]core.Resource),
+		deleted: make(map[uint64]bool),
+		linked:  make(map[[2]uint64]bool),
+		keys:    make(map[string]uint64),
+		sod:     make(map[[2]uint64]core.SoDKind),
+	}
+}
+
+// --- Staging API ---
+
+// CreateUser stages a new user
+func (tx *Tx) CreateUser(name, description, email string) (*core.User, error) {
+	u := core.NewUser(name, description, email)
+	if err := tx.InsertUser(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// InsertUser stages an already built user, keeping its ID
+func (tx *Tx) InsertUser(u *core.User) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error { return v.create(u) },
+		apply: func() Event {
+			tx.ctrl.ucinstance.put(u)
+			return newEvent(EventAdded, u)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	tx.users[u.GetResourceID()] = u
+	return nil
+}
+
+// CreateProfile stages a new profile
+func (tx *Tx) CreateProfile(name, description string) (*core.Profile, error) {
+	p := core.NewProfile(name, description)
+	if err := tx.InsertProfile(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// InsertProfile stages an already built profile, keeping its ID. Rules it
+// already holds are not registered; attach them with AddRuleToProfile.
+func (tx *Tx) InsertProfile(p *core.Profile) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error { return v.create(p) },
+		apply: func() Event {
+			tx.ctrl.pcinstance.put(p)
+			return newEvent(EventAdded, p)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	tx.profs[p.GetResourceID()] = p
+	return nil
+}
+
+// CreateRule stages a new rule. The rule must be syntactically valid.
+func (tx *Tx) CreateRule(rule *core.Rule) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			if valid, err := rule.IsValidRuleSyntax(); !valid {
+				return fmt.Errorf("invalid rule %d: %w", rule.GetResourceID(), err)
+			}
+			return v.create(rule)
+		},
+		apply: func() Event {
+			tx.ctrl.rcinstance.put(rule)
+			return newEvent(EventAdded, rule)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	tx.rules[rule.GetResourceID()] = rule
+	return nil
+}
+
+// AddRuleToProfile stages attaching an existing or staged rule to a profile
+func (tx *Tx) AddRuleToProfile(profileID, ruleID uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			if _, err := v.rule(ruleID); err != nil {
+				return err
+			}
+			if v.isLinked(p.HasRule(ruleID), profileID, ruleID) {
+				return fmt.Errorf("Rule %d is attached to profile %d: %w", ruleID, profileID, ErrAlreadyExists)
+			}
+			v.linked[[2]uint64{profileID, ruleID}] = true
+			return nil
+		},
+		apply: func() Event {
+			p := tx.ctrl.pcinstance.GetProfile(profileID)
+			p.AddRule(tx.ctrl.rcinstance.GetRule(ruleID))
+			return newEvent(EventModified, p)
+		},
+	})
+}
+
+// RemoveRuleFromProfile stages detaching a rule from a profile
+func (tx *Tx) RemoveRuleFromProfile(profileID, ruleID uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			if !v.isLinked(p.HasRule(ruleID), profileID, ruleID) {
+				return fmt.Errorf("Rule %d attached to profile %d %w", ruleID, profileID, ErrNotFound)
+			}
+			v.linked[[2]uint64{profileID, ruleID}] = false
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(profileID).RemoveRule(ruleID))
+		},
+	})
+}
+
+// AssignProfile stages granting a profile to a user or service account. A
+// profile statically conflicting with one the principal holds is refused
+// with a *core.SoDViolation.
+func (tx *Tx) AssignProfile(principalID, profileID uint64) error {
+	return tx.assignProfile(principalID, profileID, time.Time{})
+}
+
+// AssignProfileUntil stages granting a profile to a user until the given
+// time, after which the Gatekeeper no longer counts it. The assignment
+// itself stays until unassigned; AccessRequestController does that for the
+// assignments it makes.
+func (tx *Tx) AssignProfileUntil(userID, profileID uint64, until time.Time) error {
+	if until.IsZero() {
+		return tx.fail(fmt.Errorf("time-bound assignment of profile %d needs an end", profileID))
+	}
+	return tx.assignProfile(userID, profileID, until)
+}
+
+func (tx *Tx) assignProfile(principalID, profileID uint64, until time.Time) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			var pr core.Principal
+			var err error
+			if until.IsZero() {
+				pr, err = v.principal(principalID)
+			} else {
+				pr, err = v.user(principalID)
+			}
+			if err != nil {
+				return err
+			}
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			if v.isLinked(pr.HasProfile(profileID), principalID, profileID) {
+				return fmt.Errorf("Profile %d is assigned to %s %d: %w", profileID, pr.GetResourceType(), principalID, ErrAlreadyExists)
+			}
+			for _, held := range v.heldProfiles(pr) {
+				if v.conflict(held, p) == core.SoDStatic {
+					return &core.SoDViolation{Kind: core.SoDStatic, PrincipalID: principalID, ProfileID: profileID, ConflictingProfileID: held.GetResourceID()}
+				}
+			}
+			v.linked[[2]uint64{principalID, profileID}] = true
+			return nil
+		},
+		apply: func() Event {
+			// The check has ruled out conflicts, so AddProfile cannot fail
+			p := tx.ctrl.pcinstance.GetProfile(profileID)
+			if sa := tx.ctrl.sacinstance.GetServiceAccount(principalID); sa != nil {
+				sa.AddProfile(p)
+				return newEvent(EventModified, sa)
+			}
+			u := tx.ctrl.ucinstance.GetUser(principalID)
+			if until.IsZero() {
+				u.AddProfile(p)
+			} else {
+				u.AddProfileUntil(p, until)
+			}
+			return newEvent(EventModified, u)
+		},
+	})
+}
+
+// UnassignProfile stages removing a profile from a user or service account
+func (tx *Tx) UnassignProfile(principalID, profileID uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			pr, err := v.principal(principalID)
+			if err != nil {
+				return err
+			}
+			if !v.isLinked(pr.HasProfile(profileID), principalID, profileID) {
+				return fmt.Errorf("Profile %d assigned to %s %d %w", profileID, pr.GetResourceType(), principalID, ErrNotFound)
+			}
+			v.linked[[2]uint64{principalID, profileID}] = false
+			return nil
+		},
+		apply: func() Event {
+			p := tx.ctrl.pcinstance.GetProfile(profileID)
+			if sa := tx.ctrl.sacinstance.GetServiceAccount(principalID); sa != nil {
+				return newEvent(EventModified, sa.RemoveProfile(p))
+			}
+			return newEvent(EventModified, tx.ctrl.ucinstance.GetUser(principalID).RemoveProfile(p))
+		},
+	})
+}
+
+// SetProfileConflict stages a separation-of-duties constraint between two
+// profiles, recorded on both; core.SoDNone lifts it. A static constraint is
+// refused with a *core.SoDViolation while any principal holds both.
+func (tx *Tx) SetProfileConflict(profileID, otherID uint64, kind core.SoDKind) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			other, err := v.profile(otherID)
+			if err != nil {
+				return err
+			}
+			if profileID == otherID {
+				return fmt.Errorf("Profile %d cannot conflict with itself", profileID)
+			}
+			if kind > core.SoDDynamic {
+				return fmt.Errorf("unknown separation of duties kind %d", kind)
+			}
+			if kind == core.SoDStatic {
+				for _, pr := range v.principals() {
+					var holdsP, holdsOther bool
+					for _, held := range v.heldProfiles(pr) {
+						holdsP = holdsP || held == p
+						holdsOther = holdsOther || held == other
+					}
+					if holdsP && holdsOther {
+						return &core.SoDViolation{Kind: core.SoDStatic, PrincipalID: pr.GetResourceID(), ProfileID: otherID, ConflictingProfileID: profileID}
+					}
+				}
+			}
+			v.sod[sodPair(profileID, otherID)] = kind
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(profileID).SetConflict(otherID, kind))
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			_, err := v.profile(otherID)
+			return err
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(otherID).SetConflict(profileID, kind))
+		},
+	})
+}
+
+// UpdateUser stages new details for a user. expectedVersion is checked at
+// commit; 0 skips the check.
+func (tx *Tx) UpdateUser(id, expectedVersion uint64, name, description, email string) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			u, err := v.user(id)
+			if err != nil {
+				return err
+			}
+			return checkVersion(u, expectedVersion)
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.ucinstance.GetUser(id).Update(name, description, email))
+		},
+	})
+}
+
+// UpdateProfile stages a new name and description for a profile
+func (tx *Tx) UpdateProfile(id, expectedVersion uint64, name, description string) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(id)
+			if err != nil {
+				return err
+			}
+			return checkVersion(p, expectedVersion)
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(id).Update(name, description))
+		},
+	})
+}
+
+// UpdateRule stages the result of applying mutate to a copy of a rule. The
+// copy is validated when staged; the live rule is replaced at commit.
+func (tx *Tx) UpdateRule(id, expectedVersion uint64, mutate func(rule *core.Rule) error) error {
+	current := tx.GetRule(id)
+	if current == nil {
+		return tx.fail(fmt.Errorf("%s with ID %d %w", core.ResourceTypeRule, id, ErrNotFound))
+	}
+	updated := *current
+	if err := mutate(&updated); err != nil {
+		return tx.fail(err)
+	}
+	if valid, err := updated.IsValidRuleSyntax(); !valid {
+		return tx.fail(fmt.Errorf("invalid rule %d: %w", id, err))
+	}
+	if updated.GetResourceID() != id {
+		return tx.fail(fmt.Errorf("rule %d: mutate must not change the rule ID", id))
+	}
+
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			r, err := v.rule(id)
+			if err != nil {
+				return err
+			}
+			return checkVersion(r, expectedVersion)
+		},
+		apply: func() Event {
+			live := tx.ctrl.rcinstance.GetRule(id)
+			// Profiles index rules by target type, so re-file the rule
+			// wherever it is attached in case that type changed.
+			var owners []*core.Profile
+			for _, p := range tx.ctrl.pcinstance.ListProfiles() {
+				if p.HasRule(id) {
+					owners = append(owners, p.RemoveRule(id))
+				}
+			}
+			*live = updated
+			for _, p := range owners {
+				p.AddRule(live)
+			}
+			return newEvent(EventModified, live)
+		},
+	})
+}
+
+// DeleteUser stages a soft delete of a user
+func (tx *Tx) DeleteUser(id uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			if _, err := v.user(id); err != nil {
+				return err
+			}
+			v.deleted[id] = true
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventDeleted, tx.ctrl.ucinstance.GetUser(id).SoftDelete())
+		},
+	})
+}
+
+// DeleteProfile stages a soft delete of a profile
+func (tx *Tx) DeleteProfile(id uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			if _, err := v.profile(id); err != nil {
+				return err
+			}
+			v.deleted[id] = true
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventDeleted, tx.ctrl.pcinstance.GetProfile(id).SoftDelete())
+		},
+	})
+}
+
+// DeleteRule stages a soft delete of a rule
+func (tx *Tx) DeleteRule(id uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			if _, err := v.rule(id); err != nil {
+				return err
+			}
+			v.deleted[id] = true
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventDeleted, tx.ctrl.rcinstance.GetRule(id).SoftDelete())
+		},
+	})
+}
+
+// CreateServiceAccount stages a new service account owned by an active user
+func (tx *Tx) CreateServiceAccount(name, description string, ownerID uint64) (*core.ServiceAccount, error) {
+	sa := core.NewServiceAccount(name, description, ownerID)
+	if err := tx.InsertServiceAccount(sa); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// InsertServiceAccount stages an already built service account, keeping its
+// ID and any API keys it holds
+func (tx *Tx) InsertServiceAccount(sa *core.ServiceAccount) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			if _, err := v.user(sa.GetOwnerID()); err != nil {
+				return fmt.Errorf("owner of %s %d: %w", core.ResourceTypeServiceAccount, sa.GetResourceID(), err)
+			}
+			return v.create(sa)
+		},
+		apply: func() Event {
+			tx.ctrl.sacinstance.put(sa)
+			return newEvent(EventAdded, sa)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	tx.accts[sa.GetResourceID()] = sa
+	return nil
+}
+
+// UpdateServiceAccount stages new details for a service account. The new
+// owner must be an active user.
+func (tx *Tx) UpdateServiceAccount(id, expectedVersion uint64, name, description string, ownerID uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			sa, err := v.serviceAccount(id)
+			if err != nil {
+				return err
+			}
+			if _, err := v.user(ownerID); err != nil {
+				return fmt.Errorf("owner of %s %d: %w", core.ResourceTypeServiceAccount, id, err)
+			}
+			return checkVersion(sa, expectedVersion)
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.sacinstance.GetServiceAccount(id).Update(name, description, ownerID))
+		},
+	})
+}
+
+// DeleteServiceAccount stages a soft delete of a service account. Its API
+// keys stop working at commit.
+func (tx *Tx) DeleteServiceAccount(id uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			if _, err := v.serviceAccount(id); err != nil {
+				return err
+			}
+			v.deleted[id] = true
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventDeleted, tx.ctrl.sacinstance.GetServiceAccount(id).SoftDelete())
+		},
+	})
+}
+
+// IssueAPIKey stages a new API key for a service account, expiring at
+// expiresAt (zero for never), and returns the key to hand to the client. The
+// key only works once the transaction commits.
+func (tx *Tx) IssueAPIKey(accountID uint64, expiresAt time.Time) (string, error) {
+	key, presented, err := core.NewAPIKey(expiresAt)
+	if err != nil {
+		return "", tx.fail(err)
+	}
+	err = tx.stage(txOp{
+		check: func(v *txView) error {
+			if _, err := v.serviceAccount(accountID); err != nil {
+				return err
+			}
+			v.keys[key.GetID()] = accountID
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.sacinstance.addKey(accountID, key))
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return presented, nil
+}
+
+// RevokeAPIKey stages revoking an API key from at onwards. A revocation
+// already due earlier is kept.
+func (tx *Tx) RevokeAPIKey(accountID uint64, keyID string, at time.Time) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			sa, err := v.serviceAccount(accountID)
+			if err != nil {
+				return err
+			}
+			if !sa.HasAPIKey(keyID) && v.keys[keyID] != accountID {
+				return fmt.Errorf("API key %q of %s %d %w", keyID, core.ResourceTypeServiceAccount, accountID, ErrNotFound)
+			}
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.sacinstance.GetServiceAccount(accountID).RevokeAPIKey(keyID, at))
+		},
+	})
+}
+
+// RotateAPIKey stages issuing a replacement for keyID and revoking keyID once
+// grace has passed, and returns the new key
+func (tx *Tx) RotateAPIKey(accountID uint64, keyID string, expiresAt time.Time, grace time.Duration) (string, error) {
+	presented, err := tx.IssueAPIKey(accountID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.RevokeAPIKey(accountID, keyID, time.Now().Add(grace)); err != nil {
+		return "", err
+	}
+	return presented, nil
+}
+
+// CreateGrant stages a grant built by core.NewGrant. Both principals must
+// be active, as must the grant it was re-delegated from. Whether the grantor
+// holds the access is checked by DelegationController.Delegate, not here.
+func (tx *Tx) CreateGrant(grant *core.Grant) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			if err := grant.Validate(); err != nil {
+				return fmt.Errorf("invalid grant %d: %w", grant.GetResourceID(), err)
+			}
+			if _, err := v.principal(grant.GetGrantorID()); err != nil {
+				return fmt.Errorf("grantor: %w", err)
+			}
+			if _, err := v.principal(grant.GetGranteeID()); err != nil {
+				return fmt.Errorf("grantee: %w", err)
+			}
+			if parent := grant.GetParentID(); parent != 0 {
+				if _, err := v.grant(parent); err != nil {
+					return fmt.Errorf("delegated from: %w", err)
+				}
+			}
+			return v.create(grant)
+		},
+		apply: func() Event {
+			tx.ctrl.dcinstance.put(grant)
+			return newEvent(EventAdded, grant)
+		},
+	})
+}
+
+// RevokeGrant stages revoking a grant and, one event each, every active
+// grant re-delegated from it
+func (tx *Tx) RevokeGrant(id uint64) error {
+	if err := tx.revokeGrant(id); err != nil {
+		return err
+	}
+	pending := []uint64{id}
+	for len(pending) > 0 {
+		parent := pending[0]
+		pending = pending[1:]
+		for _, child := range tx.ctrl.dcinstance.children(parent) {
+			if !child.IsActive() {
+				continue
+			}
+			if err := tx.revokeGrant(child.GetResourceID()); err != nil {
+				return err
+			}
+			pending = append(pending, child.GetResourceID())
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) revokeGrant(id uint64) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			if _, err := v.grant(id); err != nil {
+				return err
+			}
+			v.deleted[id] = true
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventDeleted, tx.ctrl.dcinstance.GetGrant(id).Revoke())
+		},
+	})
+}
+
+// CreateAccessRequest stages a request built by core.NewAccessRequest. The
+// requester must be an active user who neither holds the profile nor has
+// another open request for it, and the profile must not statically conflict
+// with one the requester holds.
+func (tx *Tx) CreateAccessRequest(ar *core.AccessRequest) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			if err := ar.Validate(); err != nil {
+				return fmt.Errorf("invalid access request %d: %w", ar.GetResourceID(), err)
+			}
+			u, err := v.user(ar.GetRequesterID())
+			if err != nil {
+				return fmt.Errorf("requester: %w", err)
+			}
+			p, err := v.profile(ar.GetProfileID())
+			if err != nil {
+				return err
+			}
+			if v.isLinked(u.HasProfile(p.GetResourceID()), u.GetResourceID(), p.GetResourceID()) {
+				return fmt.Errorf("Profile %d is assigned to %s %d: %w", p.GetResourceID(), u.GetResourceType(), u.GetResourceID(), ErrAlreadyExists)
+			}
+			for _, held := range v.heldProfiles(u) {
+				if v.conflict(held, p) == core.SoDStatic {
+					return &core.SoDViolation{Kind: core.SoDStatic, PrincipalID: u.GetResourceID(), ProfileID: p.GetResourceID(), ConflictingProfileID: held.GetResourceID()}
+				}
+			}
+			for _, open := range v.accessRequests() {
+				if open.GetRequesterID() == ar.GetRequesterID() && open.GetProfileID() == ar.GetProfileID() {
+					return fmt.Errorf("%s %d for profile %d is open: %w", core.ResourceTypeAccessRequest, open.GetResourceID(), ar.GetProfileID(), ErrAlreadyExists)
+				}
+			}
+			return v.create(ar)
+		},
+		apply: func() Event {
+			tx.ctrl.arcinstance.put(ar)
+			return newEvent(EventAdded, ar)
+		},
+	})
+}
+
+// ApproveAccessRequest stages approverID's approval of a pending request at
+// time at. The approval reaching the quorum also stages the time-bound
+// assignment. Whether the approver may approve is checked by
+// AccessRequestController.Approve, not here.
+func (tx *Tx) ApproveAccessRequest(id, approverID uint64, at time.Time) error {
+	ar := tx.GetAccessRequest(id)
+	if ar == nil {
+		return tx.fail(fmt.Errorf("%s with ID %d %w", core.ResourceTypeAccessRequest, id, ErrNotFound))
+	}
+	// Decided now and re-checked at commit, so that two approvals landing
+	// together cannot both miss, or both make, the assignment
+	approvals := len(ar.GetApprovals())
+	reaches := approvals+1 >= ar.GetQuorum()
+
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			ar, err := v.pendingAccessRequest(id, at)
+			if err != nil {
+				return err
+			}
+			switch {
+			case approverID == ar.GetRequesterID():
+				return fmt.Errorf("principal %d cannot approve its own %s %d: %w", approverID, core.ResourceTypeAccessRequest, id, ErrNotApprover)
+			case ar.HasApproved(approverID):
+				return fmt.Errorf("principal %d approved %s %d already: %w", approverID, core.ResourceTypeAccessRequest, id, ErrAlreadyExists)
+			case len(ar.GetApprovals()) != approvals:
+				return fmt.Errorf("%s %d was approved concurrently: %w", core.ResourceTypeAccessRequest, id, ErrConflict)
+			}
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.arcinstance.GetAccessRequest(id).Approve(approverID, at))
+		},
+	})
+	if err != nil || !reaches {
+		return err
+	}
+	return tx.AssignProfileUntil(ar.GetRequesterID(), ar.GetProfileID(), at.Add(ar.GetDuration()))
+}
+
+// RejectAccessRequest stages byID rejecting a pending request
+func (tx *Tx) RejectAccessRequest(id, byID uint64, at time.Time) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			_, err := v.pendingAccessRequest(id, at)
+			return err
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.arcinstance.GetAccessRequest(id).Reject(byID, at))
+		},
+	})
+}
+
+// RevokeAccessRequest stages byID ending an approved request's assignment
+// early
+func (tx *Tx) RevokeAccessRequest(id, byID uint64, at time.Time) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			ar, err := v.accessRequest(id)
+			if err != nil {
+				return err
+			}
+			if state := ar.GetState(); state != core.AccessRequestApproved {
+				return fmt.Errorf("%s %d is %s, not approved: %w", core.ResourceTypeAccessRequest, id, state, ErrInvalidState)
+			}
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.arcinstance.GetAccessRequest(id).Revoke(byID, at))
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.endAssignment(tx.GetAccessRequest(id))
+}
+
+// ExpireAccessRequest stages closing a request that is due at time at, see
+// core.AccessRequest.IsDueAt, and removing the assignment it made
+func (tx *Tx) ExpireAccessRequest(id uint64, at time.Time) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			ar, err := v.accessRequest(id)
+			if err != nil {
+				return err
+			}
+			if !ar.IsDueAt(at) {
+				return fmt.Errorf("%s %d is not due at %s: %w", core.ResourceTypeAccessRequest, id, at.UTC().Format(time.RFC3339), ErrInvalidState)
+			}
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.arcinstance.GetAccessRequest(id).Expire(at))
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.endAssignment(tx.GetAccessRequest(id))
+}
+
+// endAssignment stages unassigning the profile an approved request assigned
+func (tx *Tx) endAssignment(ar *core.AccessRequest) error {
+	if ar.GetState() != core.AccessRequestApproved {
+		return nil
+	}
+	return tx.endTimeBound(ar.GetRequesterID(), ar.GetProfileID(), ar.GetExpiresAt())
+}
+
+// endTimeBound stages unassigning a profile assigned until end, if the user
+// is active and still holds it on those terms
+func (tx *Tx) endTimeBound(userID, profileID uint64, end time.Time) error {
+	u := tx.ctrl.ucinstance.GetUser(userID)
+	if u == nil || !u.IsActive() || !u.HasProfile(profileID) || !u.GetProfileEnd(profileID).Equal(end) {
+		return nil
+	}
+	return tx.UnassignProfile(userID, profileID)
+}
+
+// SetBreakGlass stages making a profile an emergency profile, taken on for
+// duration per activation; 0 makes it an ordinary profile again, which an
+// unacknowledged activation prevents
+func (tx *Tx) SetBreakGlass(profileID uint64, duration time.Duration) error {
+	return tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			if duration < 0 {
+				return fmt.Errorf("break glass duration %s is negative", duration)
+			}
+			if bg, ok := p.GetBreakGlass(); ok && duration == 0 && bg.NeedsAcknowledgement() {
+				return fmt.Errorf("Profile %d: %w", profileID, ErrAcknowledgementRequired)
+			}
+			return nil
+		},
+		apply: func() Event {
+			return newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(profileID).SetBreakGlass(duration))
+		},
+	})
+}
+
+// ActivateBreakGlass stages userID taking on an emergency profile at time
+// at, for reason, until the profile's duration has passed. The profile's
+// event is high priority. Whether userID may break the glass is checked by
+// ProfileController.ActivateBreakGlass, not here.
+func (tx *Tx) ActivateBreakGlass(profileID, userID uint64, reason string, at time.Time) error {
+	p := tx.GetProfile(profileID)
+	if p == nil {
+		return tx.fail(fmt.Errorf("%s with ID %d %w", core.ResourceTypeProfile, profileID, ErrNotFound))
+	}
+	bg, _ := p.GetBreakGlass()
+
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			bg, ok := p.GetBreakGlass()
+			switch {
+			case !ok:
+				return fmt.Errorf("Profile %d is not an emergency profile: %w", profileID, ErrNotFound)
+			case strings.TrimSpace(reason) == "":
+				return fmt.Errorf("breaking the glass on profile %d needs a reason", profileID)
+			case bg.NeedsAcknowledgement():
+				return fmt.Errorf("Profile %d was activated by principal %d at %s: %w",
+					profileID, bg.ActivatedBy, bg.ActivatedAt.UTC().Format(time.RFC3339), ErrAcknowledgementRequired)
+			}
+			return nil
+		},
+		apply: func() Event {
+			ev := newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(profileID).ActivateBreakGlass(userID, reason, at))
+			ev.Priority = PriorityHigh
+			return ev
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.AssignProfileUntil(userID, profileID, at.Add(bg.Duration))
+}
+
+// AcknowledgeBreakGlass stages byID's post-incident acknowledgement of an
+// emergency profile's last activation, ending it if still in force, so that
+// it can be activated again. The event is high priority.
+func (tx *Tx) AcknowledgeBreakGlass(profileID, byID uint64, note string, at time.Time) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(note) == "" {
+				return fmt.Errorf("acknowledging break glass on profile %d needs a note", profileID)
+			}
+			if bg, _ := p.GetBreakGlass(); !bg.NeedsAcknowledgement() {
+				return fmt.Errorf("break glass activation of profile %d awaiting acknowledgement %w", profileID, ErrNotFound)
+			}
+			return nil
+		},
+		apply: func() Event {
+			ev := newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(profileID).AcknowledgeBreakGlass(byID, note, at))
+			ev.Priority = PriorityHigh
+			return ev
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.endBreakGlass(profileID)
+}
+
+// EndBreakGlass stages recording that an emergency profile's activation ran
+// out by time at, and unassigning it. The event is high priority.
+func (tx *Tx) EndBreakGlass(profileID uint64, at time.Time) error {
+	err := tx.stage(txOp{
+		check: func(v *txView) error {
+			p, err := v.profile(profileID)
+			if err != nil {
+				return err
+			}
+			if bg, _ := p.GetBreakGlass(); bg.ActivatedAt.IsZero() || !bg.EndedAt.IsZero() || at.Before(bg.ExpiresAt) {
+				return fmt.Errorf("break glass activation of profile %d running out by %s %w", profileID, at.UTC().Format(time.RFC3339), ErrNotFound)
+			}
+			return nil
+		},
+		apply: func() Event {
+			ev := newEvent(EventModified, tx.ctrl.pcinstance.GetProfile(profileID).EndBreakGlass(at))
+			ev.Priority = PriorityHigh
+			return ev
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.endBreakGlass(profileID)
+}
+
+// endBreakGlass stages unassigning an emergency profile from whoever last
+// activated it
+func (tx *Tx) endBreakGlass(profileID uint64) error {
+	bg, ok := tx.GetProfile(profileID).GetBreakGlass()
+	if !ok || bg.ActivatedAt.IsZero() {
+		return nil
+	}
+	return tx.endTimeBound(bg.ActivatedBy, profileID, bg.ExpiresAt)
+}
+
+// GetAccessRequest returns an access request staged in this transaction or
+// committed before it
+func (tx *Tx) GetAccessRequest(id uint64) *core.AccessRequest {
+	if ar, ok := tx.view.created[id].(*core.AccessRequest); ok {
+		return ar
+	}
+	return tx.ctrl.arcinstance.GetAccessRequest(id)
+}
+
+// GetServiceAccount returns a service account staged in this transaction or
+// committed before it
+func (tx *Tx) GetServiceAccount(id uint64) *core.ServiceAccount {
+	if sa, ok := tx.accts[id]; ok {
+		return sa
+	}
+	return tx.ctrl.sacinstance.GetServiceAccount(id)
+}
+
+// GetUser returns a user staged in this transaction or committed before it
+func (tx *Tx) GetUser(id uint64) *core.User {
+	if u, ok := tx.users[id]; ok {
+		return u
+	}
+	return tx.ctrl.ucinstance.GetUser(id)
+}
+
+// GetProfile returns a profile staged in this transaction or committed
+// before it, so callers can reuse existing profiles.
+func (tx *Tx) GetProfile(id uint64) *core.Profile {
+	if p, ok := tx.profs[id]; ok {
+		return p
+	}
+	return tx.ctrl.pcinstance.GetProfile(id)
+}
+
+// GetRule returns a rule staged in this transaction or committed before it
+func (tx *Tx) GetRule(id uint64) *core.Rule {
+	if r, ok := tx.rules[id]; ok {
+		return r
+	}
+	return tx.ctrl.rcinstance.GetRule(id)
+}
+
+// --- View helpers ---
+
+func (v *txView) create(res core.Resource) error {
+	id, kind := res.GetResourceID(), res.GetResourceType()
+	taken := v.live(kind, id) != nil
+	if kind == core.ResourceTypeUser || kind == core.ResourceTypeServiceAccount {
+		// Users and service accounts share one principal ID space
+		taken = v.live(core.ResourceTypeUser, id) != nil || v.live(core.ResourceTypeServiceAccount, id) != nil
+	}
+	if _, ok := v.created[id]; ok || taken {
+		return fmt.Errorf("%s with ID %d %w", kind, id, ErrAlreadyExists)
+	}
+	v.created[id] = res
+	return nil
+}
+
+func (v *txView) live(kind core.ResourceType, id uint64) core.Resource {
+	switch kind {
+	case core.ResourceTypeUser:
+		if u := v.ctrl.ucinstance.GetUser(id); u != nil {
+			return u
+		}
+	case core.ResourceTypeProfile:
+		if p := v.ctrl.pcinstance.GetProfile(id); p != nil {
+			return p
+		}
+	case core.ResourceTypeRule:
+		if r := v.ctrl.rcinstance.GetRule(id); r != nil {
+			return r
+		}
+	case core.ResourceTypeServiceAccount:
+		if sa := v.ctrl.sacinstance.GetServiceAccount(id); sa != nil {
+			return sa
+		}
+	case core.ResourceTypeGrant:
+		if g := v.ctrl.dcinstance.GetGrant(id); g != nil {
+			return g
+		}
+	case core.ResourceTypeAccessRequest:
+		if ar := v.ctrl.arcinstance.GetAccessRequest(id); ar != nil {
+			return ar
+		}
+	}
+	return nil
+}
+
+// lookup finds an active entity of kind, staged or live
+func (v *txView) lookup(kind core.ResourceType, id uint64) (core.Resource, error) {
+	res, ok := v.created[id]
+	if !ok || res.GetResourceType() != kind {
+		res = v.live(kind, id)
+	}
+	if res == nil {
+		return nil, fmt.Errorf("%s with ID %d %w", kind, id, ErrNotFound)
+	}
+	if v.deleted[id] || !res.IsActive() {
+		return nil, fmt.Errorf("%s %d %w", kind, id, ErrInactive)
+	}
+	return res, nil
+}
+
+func (v *txView) user(id uint64) (*core.User, error) {
+	res, err := v.lookup(core.ResourceTypeUser, id)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*core.User), nil
+}
+
+func (v *txView) profile(id uint64) (*core.Profile, error) {
+	res, err := v.lookup(core.ResourceTypeProfile, id)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*core.Profile), nil
+}
+
+func (v *txView) rule(id uint64) (*core.Rule, error) {
+	res, err := v.lookup(core.ResourceTypeRule, id)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*core.Rule), nil
+}
+
+func (v *txView) serviceAccount(id uint64) (*core.ServiceAccount, error) {
+	res, err := v.lookup(core.ResourceTypeServiceAccount, id)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*core.ServiceAccount), nil
+}
+
+func (v *txView) grant(id uint64) (*core.Grant, error) {
+	res, err := v.lookup(core.ResourceTypeGrant, id)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*core.Grant), nil
+}
+
+// accessRequest finds a request, staged or live, in any state; transitions
+// check the state themselves
+func (v *txView) accessRequest(id uint64) (*core.AccessRequest, error) {
+	if ar, ok := v.created[id].(*core.AccessRequest); ok {
+		return ar, nil
+	}
+	if ar := v.ctrl.arcinstance.GetAccessRequest(id); ar != nil {
+		return ar, nil
+	}
+	return nil, fmt.Errorf("%s with ID %d %w", core.ResourceTypeAccessRequest, id, ErrNotFound)
+}
+
+// pendingAccessRequest finds a request that is still waiting for decisions
+// at time at
+func (v *txView) pendingAccessRequest(id uint64, at time.Time) (*core.AccessRequest, error) {
+	ar, err := v.accessRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if state := ar.GetState(); state != core.AccessRequestPending {
+		return nil, fmt.Errorf("%s %d is %s, not pending: %w", core.ResourceTypeAccessRequest, id, state, ErrInvalidState)
+	}
+	if ar.IsDueAt(at) {
+		return nil, fmt.Errorf("%s %d was not decided in time: %w", core.ResourceTypeAccessRequest, id, ErrInvalidState)
+	}
+	return ar, nil
+}
+
+// accessRequests lists the open access requests, staged or live
+func (v *txView) accessRequests() []*core.AccessRequest {
+	var list []*core.AccessRequest
+	for _, ar := range v.ctrl.arcinstance.ListAccessRequests() {
+		if ar.IsActive() && !v.deleted[ar.GetResourceID()] {
+			list = append(list, ar)
+		}
+	}
+	for _, res := range v.created {
+		if ar, ok := res.(*core.AccessRequest); ok {
+			list = append(list, ar)
+		}
+	}
+	return list
+}
+
+// principal finds an active user or service account
+func (v *txView) principal(id uint64) (core.Principal, error) {
+	if v.live(core.ResourceTypeServiceAccount, id) != nil {
+		return v.serviceAccount(id)
+	}
+	if res, ok := v.created[id]; ok && res.GetResourceType() == core.ResourceTypeServiceAccount {
+		return v.serviceAccount(id)
+	}
+	return v.user(id)
+}
+
+// principals lists the active users and service accounts, staged or live
+func (v *txView) principals() []core.Principal {
+	var list []core.Principal
+	for _, u := range v.ctrl.ucinstance.ListUsers() {
+		list = append(list, u)
+	}
+	for _, sa := range v.ctrl.sacinstance.ListServiceAccounts() {
+		list = append(list, sa)
+	}
+	for _, res := range v.created {
+		if pr, ok := res.(core.Principal); ok && v.live(pr.GetResourceType(), pr.GetResourceID()) == nil {
+			list = append(list, pr)
+		}
+	}
+
+	active := list[:0]
+	for _, pr := range list {
+		if !v.deleted[pr.GetResourceID()] && pr.IsActive() {
+			active = append(active, pr)
+		}
+	}
+	return active
+}
+
+// heldProfiles lists the active profiles pr holds once staged changes are
+// taken into account
+func (v *txView) heldProfiles(pr core.Principal) []*core.Profile {
+	id := pr.GetResourceID()
+	var held []*core.Profile
+	for _, p := range pr.GetProfiles() {
+		if !v.isLinked(true, id, p.GetResourceID()) {
+			continue
+		}
+		if p, err := v.profile(p.GetResourceID()); err == nil {
+			held = append(held, p)
+		}
+	}
+	for link, attached := range v.linked {
+		if link[0] != id || !attached || pr.HasProfile(link[1]) {
+			continue
+		}
+		if p, err := v.profile(link[1]); err == nil {
+			held = append(held, p)
+		}
+	}
+	return held
+}
+
+// conflict returns the constraint between two profiles, staged or live
+func (v *txView) conflict(a, b *core.Profile) core.SoDKind {
+	if kind, ok := v.sod[sodPair(a.GetResourceID(), b.GetResourceID())]; ok {
+		return kind
+	}
+	return core.ConflictBetween(a, b)
+}
+
+// sodPair orders two profile IDs so either order finds the same constraint
+func sodPair(a, b uint64) [2]uint64 {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]uint64{a, b}
+}
+
+// isLinked reports whether member is attached to owner once staged changes
+// are taken into account; live is the committed answer.
+func (v *txView) isLinked(live bool, owner, member uint64) bool {
+	if staged, ok := v.linked[[2]uint64{owner, member}]; ok {
+		return staged
+	}
+	return live
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+// newRule returns a rule on every resource of type rt
+func newRule(name string, rt core.ResourceType, verb core.Verb, action core.Action) *core.Rule {
+	rule := core.NewEmptyRule(name)
+	rule.UpdateVerb(verb)
+	rule.SetTargetResourceTypeAndID(rt, core.ResourceIDAll)
+	rule.UpdateAction(core.ActionOption{Action: action})
+	return rule
+}
+
+func TestTx_OnboardingCommitsAtomically(t *testing.T) {
+	ctrl := New()
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+
+	var user *core.User
+	err := ctrl.Tx(func(tx *Tx) error {
+		var err error
+		if user, err = tx.CreateUser("John", "User", "john@example.com"); err != nil {
+			return err
+		}
+		profile, err := tx.CreateProfile("readers", "read projects")
+		if err != nil {
+			return err
+		}
+		rule := newRule("read-projects", core.ResourceTypeProject, core.VerbRead, core.ActionAllow)
+		if err := tx.CreateRule(rule); err != nil {
+			return err
+		}
+		if err := tx.AddRuleToProfile(profile.GetResourceID(), rule.GetResourceID()); err != nil {
+			return err
+		}
+		return tx.AssignProfile(user.GetResourceID(), profile.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("Tx failed: %v", err)
+	}
+
+	ctx, _ := lib.NewRequestContext(user.GetResourceID(), core.ResourceTypeProject, 42, core.VerbRead, nil)
+	if allowed, err := gk.IsRequestAllowed(ctx); !allowed {
+		t.Errorf("Expected ALLOW after commit, got DENY (%v)", err)
+	}
+}
+
+func TestTx_FailureLeavesNoPartialState(t *testing.T) {
+	ctrl := New()
+
+	err := ctrl.Tx(func(tx *Tx) error {
+		if _, err := tx.CreateUser("Jane", "User", "jane@example.com"); err != nil {
+			return err
+		}
+		if _, err := tx.CreateProfile("writers", "write projects"); err != nil {
+			return err
+		}
+		// Unknown rule: the whole transaction must be discarded.
+		return tx.AddRuleToProfile(core.NewProfile("writers", "write projects").GetResourceID(), 12345)
+	})
+	if err == nil {
+		t.Fatalf("Expected Tx to fail")
+	}
+	if n := len(ctrl.GetUserController().ListUsers()); n != 0 {
+		t.Errorf("Expected no users after failed Tx, got %d", n)
+	}
+	if n := len(ctrl.GetProfileController().ListProfiles()); n != 0 {
+		t.Errorf("Expected no profiles after failed Tx, got %d", n)
+	}
+	if n := len(ctrl.GetUserController().events); n != 0 {
+		t.Errorf("Expected no events after failed Tx, got %d", n)
+	}
+}
+
+func TestTx_IgnoredStagingErrorStillAborts(t *testing.T) {
+	ctrl := New()
+
+	err := ctrl.Tx(func(tx *Tx) error {
+		tx.CreateUser("Bob", "User", "bob@example.com")
+		tx.AssignProfile(1, 2) // error ignored by the caller
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("Expected Tx to report the staging error")
+	}
+	if n := len(ctrl.GetUserController().ListUsers()); n != 0 {
+		t.Errorf("Expected no users after failed Tx, got %d", n)
+	}
+}
+
+func TestTx_EventsPublishedOnCommit(t *testing.T) {
+	ctrl := New()
+
+	err := ctrl.Tx(func(tx *Tx) error {
+		for i := 0; i < 3; i++ {
+			if _, err := tx.CreateUser(fmt.Sprint("user-", i), "User", "u@example.com"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Tx failed: %v", err)
+	}
+	if n := len(ctrl.GetUserController().events); n != 3 {
+		t.Errorf("Expected 3 events after commit, got %d", n)
+	}
+}
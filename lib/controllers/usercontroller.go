@@ -1,7 +1,6 @@
 package controllers
 
 import (
-	"fmt"
 	"sync"
 
 	"github.com/farhansabbir/rbac/core"
@@ -10,22 +9,40 @@ import (
 // UserController manages user state and events
 type UserController struct {
 	id     uint64
+	ctrl   *Controller
 	mux    sync.RWMutex
 	users  map[uint64]*core.User
-	events chan string
+	events chan Event
 }
 
 // --- UserController Methods ---
 
-func (uc *UserController) CreateUser(name, description, email string) *core.User {
-	u := core.NewUser(name, description, email)
-
-	uc.mux.Lock()
-	uc.users[u.GetResourceID()] = u
-	uc.mux.Unlock()
+// CreateUser adds a new user in a transaction. A user whose ID is already
+// taken is not overwritten; ErrAlreadyExists is returned instead.
+func (uc *UserController) CreateUser(name, description, email string) (*core.User, error) {
+	var u *core.User
+	err := uc.ctrl.Tx(func(tx *Tx) error {
+		var err error
+		u, err = tx.CreateUser(name, description, email)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
 
-	uc.events <- fmt.Sprintf("User Created: %s (ID: %d)", u.GetResourceName(), u.GetResourceID())
-	return u
+// UpdateUser changes a user's details. expectedVersion must match the user's
+// current resource version (0 skips the check), otherwise a *ConflictError is
+// returned and nothing changes.
+func (uc *UserController) UpdateUser(id, expectedVersion uint64, name, description, email string) (*core.User, error) {
+	err := uc.ctrl.Tx(func(tx *Tx) error {
+		return tx.UpdateUser(id, expectedVersion, name, description, email)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uc.GetUser(id), nil
 }
 
 func (uc *UserController) GetUser(id uint64) *core.User {
@@ -35,15 +52,23 @@ func (uc *UserController) GetUser(id uint64) *core.User {
 }
 
 func (uc *UserController) DeleteUser(id uint64) bool {
-	uc.mux.Lock()
-	defer uc.mux.Unlock()
+	return uc.ctrl.Tx(func(tx *Tx) error {
+		return tx.DeleteUser(id)
+	}) == nil
+}
 
-	if user, ok := uc.users[id]; ok {
-		user.SoftDelete()
-		uc.events <- fmt.Sprintf("User Deleted: %d", id)
-		return true
-	}
-	return false
+// AssignProfile grants an existing profile to an existing user
+func (uc *UserController) AssignProfile(userID, profileID uint64) error {
+	return uc.ctrl.Tx(func(tx *Tx) error {
+		return tx.AssignProfile(userID, profileID)
+	})
+}
+
+// UnassignProfile removes a profile from a user
+func (uc *UserController) UnassignProfile(userID, profileID uint64) error {
+	return uc.ctrl.Tx(func(tx *Tx) error {
+		return tx.UnassignProfile(userID, profileID)
+	})
 }
 
 func (uc *UserController) ListUsers() []*core.User {
@@ -69,3 +94,17 @@ func (uc *UserController) ListActiveUsers() []*core.User {
 	}
 	return list
 }
+
+// put stores u; callers hold the controller state lock
+func (uc *UserController) put(u *core.User) {
+	uc.mux.Lock()
+	uc.users[u.GetResourceID()] = u
+	uc.mux.Unlock()
+}
+
+// remove deletes id from the store; callers hold the controller state lock
+func (uc *UserController) remove(id uint64) {
+	uc.mux.Lock()
+	delete(uc.users, id)
+	uc.mux.Unlock()
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+func TestUpdateUser_ConflictOnStaleVersion(t *testing.T) {
+	ctrl := New()
+	uc := ctrl.GetUserController()
+
+	user, _ := uc.CreateUser("John", "User", "john@example.com")
+	v1 := user.GetResourceVersion()
+	if v1 == 0 {
+		t.Fatalf("Expected stored user to carry a resource version")
+	}
+
+	// Admin A updates with the version they read.
+	if _, err := uc.UpdateUser(user.GetResourceID(), v1, "John", "Admin A", "john@example.com"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if user.GetResourceVersion() <= v1 {
+		t.Errorf("Expected version to increase, got %d after %d", user.GetResourceVersion(), v1)
+	}
+
+	// Admin B still holds v1 and must be rejected.
+	_, err := uc.UpdateUser(user.GetResourceID(), v1, "John", "Admin B", "john@example.com")
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected *ConflictError, got %v", err)
+	}
+	if conflict.Expected != v1 || conflict.Actual != user.GetResourceVersion() {
+		t.Errorf("Unexpected conflict details: %+v", conflict)
+	}
+	if user.GetResourceDescription() != "Admin A" {
+		t.Errorf("Expected losing update to leave user untouched, got %q", user.GetResourceDescription())
+	}
+}
+
+func TestUpdateRule_ReindexesProfiles(t *testing.T) {
+	ctrl := New()
+	rule := newRule("read-projects", core.ResourceTypeProject, core.VerbRead, core.ActionAllow)
+	if err := ctrl.GetRuleController().CreateRule(rule); err != nil {
+		t.Fatalf("CreateRule failed: %v", err)
+	}
+	profile, _ := ctrl.GetProfileController().CreateProfile("readers", "read")
+	if err := ctrl.GetProfileController().AddRule(profile.GetResourceID(), rule.GetResourceID()); err != nil {
+		t.Fatalf("AddRule failed: %v", err)
+	}
+
+	_, err := ctrl.GetRuleController().UpdateRule(rule.GetResourceID(), rule.GetResourceVersion(), func(r *core.Rule) error {
+		_, err := r.SetTargetResourceTypeAndID(core.ResourceTypeURL, core.ResourceIDAll)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("UpdateRule failed: %v", err)
+	}
+	if n := len(profile.GetAssociatedRules(core.ResourceTypeProject)); n != 0 {
+		t.Errorf("Expected rule to leave the Project index, found %d", n)
+	}
+	if n := len(profile.GetAssociatedRules(core.ResourceTypeURL)); n != 1 {
+		t.Errorf("Expected rule under the URL index, found %d", n)
+	}
+}
+
+func TestDecisionCache_InvalidatedByCommit(t *testing.T) {
+	ctrl := New()
+	var userID, profileID uint64
+	rule := newRule("read-projects", core.ResourceTypeProject, core.VerbRead, core.ActionAllow)
+	err := ctrl.Tx(func(tx *Tx) error {
+		user, err := tx.CreateUser("John", "User", "john@example.com")
+		if err != nil {
+			return err
+		}
+		profile, err := tx.CreateProfile("readers", "")
+		if err != nil {
+			return err
+		}
+		userID, profileID = user.GetResourceID(), profile.GetResourceID()
+		if err := tx.CreateRule(rule); err != nil {
+			return err
+		}
+		if err := tx.AddRuleToProfile(profileID, rule.GetResourceID()); err != nil {
+			return err
+		}
+		return tx.AssignProfile(userID, profileID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl), lib.WithDecisionCache(time.Hour, 10))
+	read := &lib.RequestContext{PrincipalID: userID, RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead}
+	if !gk.Decide(read).Allowed || !gk.Decide(read).Allowed {
+		t.Fatal("John should read projects")
+	}
+
+	if err := ctrl.GetProfileController().RemoveRule(profileID, rule.GetResourceID()); err != nil {
+		t.Fatal(err)
+	}
+	if gk.Decide(read).Allowed {
+		t.Error("cached allow survived the rule being detached")
+	}
+}
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+const (
+	defaultHistorySize   = 1000
+	defaultWatcherBuffer = 100
+)
+
+var (
+	// ErrResourceVersionTooOld is returned by Watch when the requested
+	// version has been compacted out of history. Callers must relist and
+	// watch again from the version List returns.
+	ErrResourceVersionTooOld = errors.New("resource version too old, relist required")
+
+	// ErrWatcherTooSlow is reported by Watcher.Err when the watcher fell so
+	// far behind that its buffer overflowed and it was closed.
+	ErrWatcherTooSlow = errors.New("watcher fell behind and was closed")
+)
+
+// WithHistorySize sets how many committed events are retained for Watch
+// callers resuming from an older version.
+func WithHistorySize(size int) Option {
+	return func(c *Controller) {
+		if size > 0 {
+			c.historySize = size
+		}
+	}
+}
+
+// Watcher streams committed events of one kind
+type Watcher struct {
+	ctrl   *Controller
+	kind   core.ResourceType
+	result chan Event
+	once   sync.Once
+	err    error
+}
+
+// ResultChan returns the event stream. It is closed by Stop, or when the
+// watcher falls too far behind (see Err).
+func (w *Watcher) ResultChan() <-chan Event {
+	return w.result
+}
+
+// Stop ends the watch and closes the result channel
+func (w *Watcher) Stop() {
+	w.ctrl.watchMux.Lock()
+	defer w.ctrl.watchMux.Unlock()
+	w.ctrl.removeWatcher(w, nil)
+}
+
+// Err returns why the result channel was closed, or nil if it was stopped
+// normally or is still open.
+func (w *Watcher) Err() error {
+	w.ctrl.watchMux.Lock()
+	defer w.ctrl.watchMux.Unlock()
+	return w.err
+}
+
+// List returns every entity of kind (core.ResourceTypeAll for all kinds)
+// together with the resource version the listing reflects. Pass that version
+// to Watch to follow changes without gaps.
+func (c *Controller) List(kind core.ResourceType) ([]core.Resource, uint64) {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	var items []core.Resource
+	if kind == core.ResourceTypeUser || kind == core.ResourceTypeAll {
+		for _, u := range c.ucinstance.ListUsers() {
+			items = append(items, u)
+		}
+	}
+	if kind == core.ResourceTypeProfile || kind == core.ResourceTypeAll {
+		for _, p := range c.pcinstance.ListProfiles() {
+			items = append(items, p)
+		}
+	}
+	if kind == core.ResourceTypeRule || kind == core.ResourceTypeAll {
+		for _, r := range c.rcinstance.ListRules() {
+			items = append(items, r)
+		}
+	}
+	if kind == core.ResourceTypeServiceAccount || kind == core.ResourceTypeAll {
+		for _, sa := range c.sacinstance.ListServiceAccounts() {
+			items = append(items, sa)
+		}
+	}
+	if kind == core.ResourceTypeGrant || kind == core.ResourceTypeAll {
+		for _, g := range c.dcinstance.ListGrants() {
+			items = append(items, g)
+		}
+	}
+	if kind == core.ResourceTypeAccessRequest || kind == core.ResourceTypeAll {
+		for _, ar := range c.arcinstance.ListAccessRequests() {
+			items = append(items, ar)
+		}
+	}
+	return items, c.version
+}
+
+// Watch streams ADDED, MODIFIED and DELETED events for kind
+// (core.ResourceTypeAll for all kinds) with a resource version greater than
+// fromVersion. Retained history is replayed first, so a consumer that
+// reconnects with the last version it saw misses nothing. If that history has
+// been compacted, Watch returns ErrResourceVersionTooOld.
+func (c *Controller) Watch(kind core.ResourceType, fromVersion uint64) (*Watcher, error) {
+	// Holding state keeps commits out while we replay and register.
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	if fromVersion < c.compacted {
+		return nil, ErrResourceVersionTooOld
+	}
+
+	var replay []Event
+	for _, ev := range c.history {
+		if ev.ResourceVersion > fromVersion && matchesKind(kind, ev.Kind) {
+			replay = append(replay, ev)
+		}
+	}
+
+	w := &Watcher{
+		ctrl:   c,
+		kind:   kind,
+		result: make(chan Event, len(replay)+defaultWatcherBuffer),
+	}
+	for _, ev := range replay {
+		w.result <- ev
+	}
+
+	c.watchMux.Lock()
+	c.watchers[w] = struct{}{}
+	c.watchMux.Unlock()
+	return w, nil
+}
+
+// record appends ev to history and fans it out to watchers. The object is
+// replaced by a snapshot so later changes to the entity do not rewrite what
+// replayed events show. Callers hold the state lock.
+func (c *Controller) record(ev Event) {
+	if ev.Object != nil {
+		ev.Object = snapshotOf(ev.Object)
+	}
+	c.history = append(c.history, ev)
+	if over := len(c.history) - c.historySize; over > 0 {
+		c.compacted = c.history[over-1].ResourceVersion
+		c.history = append(c.history[:0:0], c.history[over:]...)
+	}
+
+	c.watchMux.Lock()
+	defer c.watchMux.Unlock()
+	for w := range c.watchers {
+		if !matchesKind(w.kind, ev.Kind) {
+			continue
+		}
+		select {
+		case w.result <- ev:
+		default:
+			c.removeWatcher(w, ErrWatcherTooSlow)
+		}
+	}
+}
+
+// removeWatcher closes w once; callers hold watchMux
+func (c *Controller) removeWatcher(w *Watcher, err error) {
+	w.once.Do(func() {
+		delete(c.watchers, w)
+		w.err = err
+		close(w.result)
+	})
+}
+
+// snapshot is a frozen copy of an entity as it was when an event was
+// recorded. It marshals to the same JSON as the entity did then.
+type snapshot struct {
+	id, version       uint64
+	name, description string
+	kind              core.ResourceType
+	created, updated  time.Time
+	deleted           time.Time
+	active            bool
+	raw               json.RawMessage
+}
+
+func snapshotOf(r core.Resource) *snapshot {
+	s := &snapshot{
+		id:          r.GetResourceID(),
+		version:     r.GetResourceVersion(),
+		name:        r.GetResourceName(),
+		description: r.GetResourceDescription(),
+		kind:        r.GetResourceType(),
+		created:     r.GetResourceCreatedAt(),
+		updated:     r.GetResourceUpdatedAt(),
+		deleted:     r.GetResourceDeletedAt(),
+		active:      r.IsActive(),
+	}
+	if raw, err := json.Marshal(r); err == nil {
+		s.raw = raw
+	}
+	return s
+}
+
+func (s *snapshot) GetResourceID() uint64              { return s.id }
+func (s *snapshot) GetResourceName() string            { return s.name }
+func (s *snapshot) GetResourceDescription() string     { return s.description }
+func (s *snapshot) GetResourceType() core.ResourceType { return s.kind }
+func (s *snapshot) GetResourceCreatedAt() time.Time    { return s.created }
+func (s *snapshot) GetResourceUpdatedAt() time.Time    { return s.updated }
+func (s *snapshot) GetResourceDeletedAt() time.Time    { return s.deleted }
+func (s *snapshot) GetResourceVersion() uint64         { return s.version }
+func (s *snapshot) IsActive() bool                     { return s.active }
+
+func (s *snapshot) MarshalJSON() ([]byte, error) {
+	if s.raw == nil {
+		return []byte("null"), nil
+	}
+	return s.raw, nil
+}
+
+func matchesKind(want, got core.ResourceType) bool {
+	return want == core.ResourceTypeAll || want == got
+}
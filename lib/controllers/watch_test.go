@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+func TestWatch_ResumeFromVersion(t *testing.T) {
+	ctrl := New()
+	uc := ctrl.GetUserController()
+
+	first, _ := uc.CreateUser("John", "User", "john@example.com")
+	_, listVersion := ctrl.List(core.ResourceTypeUser)
+
+	uc.CreateUser("Jane", "User", "jane@example.com")
+	ctrl.GetProfileController().CreateProfile("readers", "read") // other kind, filtered out
+	uc.DeleteUser(first.GetResourceID())
+
+	w, err := ctrl.Watch(core.ResourceTypeUser, listVersion)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+
+	want := []EventType{EventAdded, EventDeleted}
+	for _, typ := range want {
+		ev := <-w.ResultChan()
+		if ev.Type != typ || ev.Kind != core.ResourceTypeUser {
+			t.Fatalf("Expected %s User event, got %s %s", typ, ev.Kind, ev.Type)
+		}
+		if ev.ResourceVersion <= listVersion {
+			t.Errorf("Replayed event %d is not newer than %d", ev.ResourceVersion, listVersion)
+		}
+	}
+
+	// Live events follow the replay.
+	uc.CreateUser("Bob", "User", "bob@example.com")
+	if ev := <-w.ResultChan(); ev.Type != EventAdded || ev.Object.GetResourceName() != "Bob" {
+		t.Errorf("Expected live ADDED event for Bob, got %s", ev)
+	}
+}
+
+func TestWatch_CompactedHistoryIsTooOld(t *testing.T) {
+	ctrl := New(WithHistorySize(2))
+	uc := ctrl.GetUserController()
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		uc.CreateUser(name, "User", name+"@example.com")
+	}
+
+	if _, err := ctrl.Watch(core.ResourceTypeAll, 1); !errors.Is(err, ErrResourceVersionTooOld) {
+		t.Fatalf("Expected ErrResourceVersionTooOld, got %v", err)
+	}
+
+	items, version := ctrl.List(core.ResourceTypeAll)
+	if len(items) != 4 {
+		t.Errorf("Expected relist to return 4 items, got %d", len(items))
+	}
+	w, err := ctrl.Watch(core.ResourceTypeAll, version)
+	if err != nil {
+		t.Fatalf("Expected watch from relist version to succeed: %v", err)
+	}
+	w.Stop()
+	if _, open := <-w.ResultChan(); open {
+		t.Errorf("Expected result channel to be closed after Stop")
+	}
+}
+
+func TestWatch_ReplayShowsRecordedState(t *testing.T) {
+	ctrl := New()
+	uc := ctrl.GetUserController()
+
+	user, _ := uc.CreateUser("John", "User", "john@example.com")
+	created := user.GetResourceVersion()
+	if _, err := uc.UpdateUser(user.GetResourceID(), 0, "Johnny", "User", "john@example.com"); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+
+	w, err := ctrl.Watch(core.ResourceTypeUser, 0)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Stop()
+
+	ev := <-w.ResultChan()
+	if ev.Object.GetResourceName() != "John" || ev.Object.GetResourceVersion() != created {
+		t.Errorf("Expected ADDED to replay John at version %d, got %s at %d", created, ev.Object.GetResourceName(), ev.Object.GetResourceVersion())
+	}
+	raw, _ := json.Marshal(ev.Object)
+	if !strings.Contains(string(raw), `"John"`) {
+		t.Errorf("Expected replayed JSON to hold the old name, got %s", raw)
+	}
+	if ev := <-w.ResultChan(); ev.Object.GetResourceName() != "Johnny" {
+		t.Errorf("Expected MODIFIED to carry Johnny, got %s", ev.Object.GetResourceName())
+	}
+}
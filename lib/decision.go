@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Effect is the outcome of an evaluation
+type Effect uint8
+
+const (
+	EffectDeny Effect = iota
+	EffectAllow
+	// EffectIndeterminate means no answer was reached because the request's
+	// context was done. It is not an allow, but callers may want to report
+	// it differently from a deny, e.g. as unavailable rather than forbidden.
+	EffectIndeterminate
+)
+
+func (e Effect) String() string {
+	switch e {
+	case EffectAllow:
+		return "allow"
+	case EffectIndeterminate:
+		return "indeterminate"
+	default:
+		return "deny"
+	}
+}
+
+// Decision explains how the Gatekeeper answered a request
+type Decision struct {
+	Allowed   bool
+	Effect    Effect
+	RuleID    uint64 // deciding rule, 0 when no rule decided (implicit deny or error)
+	ProfileID uint64 // profile the deciding rule was found in
+	GrantID   uint64 // delegated grant the allow came through, if any
+	Reason    string
+	Err       error // set when the request could not be evaluated, or was denied as a *core.SoDViolation
+
+	// BreakGlass is set when the deciding rule came from an emergency
+	// profile, see core.Profile.SetBreakGlass
+	BreakGlass bool
+
+	// PrincipalID and ImpersonatorID copy the request's identities, so a
+	// decision made for an impersonator names both
+	PrincipalID    uint64
+	ImpersonatorID uint64
+}
+
+func (d Decision) String() string {
+	return fmt.Sprintf("%s: %s", d.Effect, d.Reason)
+}
+
+func (d Decision) MarshalJSON() ([]byte, error) {
+	var errText string
+	if d.Err != nil {
+		errText = d.Err.Error()
+	}
+	return json.Marshal(struct {
+		Allowed   bool   `json:"allowed"`
+		Effect    string `json:"effect"`
+		RuleID    uint64 `json:"rule_id,omitempty"`
+		ProfileID uint64 `json:"profile_id,omitempty"`
+		GrantID   uint64 `json:"grant_id,omitempty"`
+		Reason    string `json:"reason"`
+		Error     string `json:"error,omitempty"`
+
+		BreakGlass bool `json:"break_glass,omitempty"`
+
+		PrincipalID    uint64 `json:"principal_id,omitempty"`
+		ImpersonatorID uint64 `json:"impersonator_id,omitempty"`
+	}{
+		Allowed:   d.Allowed,
+		Effect:    d.Effect.String(),
+		RuleID:    d.RuleID,
+		ProfileID: d.ProfileID,
+		GrantID:   d.GrantID,
+		Reason:    d.Reason,
+		Error:     errText,
+
+		BreakGlass: d.BreakGlass,
+
+		PrincipalID:    d.PrincipalID,
+		ImpersonatorID: d.ImpersonatorID,
+	})
+}
+
+func allow(ruleID, profileID uint64, reason string) Decision {
+	return Decision{Allowed: true, Effect: EffectAllow, RuleID: ruleID, ProfileID: profileID, Reason: reason}
+}
+
+func deny(ruleID, profileID uint64, reason string) Decision {
+	return Decision{Effect: EffectDeny, RuleID: ruleID, ProfileID: profileID, Reason: reason}
+}
+
+func denyWithError(err error) Decision {
+	return Decision{Effect: EffectDeny, Reason: err.Error(), Err: err}
+}
+
+func indeterminate(err error) Decision {
+	return Decision{Effect: EffectIndeterminate, Reason: "evaluation abandoned: " + err.Error(), Err: err}
+}
+
+// failed turns an evaluation error into a decision: indeterminate if the
+// request's context ended it, deny otherwise
+func failed(err error) Decision {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return indeterminate(err)
+	}
+	return denyWithError(err)
+}
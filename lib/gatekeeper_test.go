@@ -1,7 +1,12 @@
 package lib
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/farhansabbir/rbac/core"
 )
@@ -222,3 +227,330 @@ func TestGatekeeper_WildcardIDMatching(t *testing.T) {
 		t.Errorf("Expected DENY for ID 101, got ALLOW")
 	}
 }
+
+type recordingSink struct {
+	records []AuditRecord
+}
+
+func (s *recordingSink) Write(rec AuditRecord) {
+	s.records = append(s.records, rec)
+}
+
+func TestGatekeeper_AuditsEveryDecision(t *testing.T) {
+	resetGlobals()
+	sink := &recordingSink{}
+	gk := NewGatekeeper(WithAuditSink(sink))
+
+	rule := core.NewEmptyRule("allow-read-projects")
+	rule.UpdateVerb(core.VerbRead)
+	rule.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+	rule.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+	profile := core.NewProfile("readers", "")
+	profile.AddRule(rule)
+	user := core.NewUser("John", "User", "john@example.com")
+	user.AddProfile(profile)
+	Users = append(Users, user)
+
+	gk.Decide(&RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead, CorrelationID: "req-1"})
+	gk.Decide(&RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbDelete})
+
+	if len(sink.records) != 2 {
+		t.Fatalf("got %d audit records, want 2", len(sink.records))
+	}
+	allowed, denied := sink.records[0], sink.records[1]
+	if allowed.CorrelationID != "req-1" || !allowed.Decision.Allowed || allowed.Decision.RuleID != rule.GetResourceID() {
+		t.Errorf("allowed record = %+v", allowed)
+	}
+	if denied.CorrelationID == "" || denied.Decision.Allowed || denied.Request.RequestVerb != core.VerbDelete {
+		t.Errorf("denied record = %+v", denied)
+	}
+	if allowed.Time.IsZero() || allowed.Latency < 0 {
+		t.Errorf("record is missing time or latency: %+v", allowed)
+	}
+}
+
+// forwardFixture gives Dave a profile whose only rule allows reads on every
+// project and forwards to next, or simply allows when next is 0
+func forwardFixture(next uint64) (*core.User, *core.Rule) {
+	resetGlobals()
+	forward := core.NewEmptyRule("forward")
+	forward.UpdateVerb(core.VerbRead)
+	forward.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+	if next == 0 {
+		forward.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+	} else {
+		forward.UpdateAction(core.ActionOption{Action: core.ActionAllowAndForwardToNextRule, NextRuleID: next})
+	}
+	profile := core.NewProfile("forward-profile", "")
+	profile.AddRule(forward)
+	user := core.NewUser("Dave", "User", "dave@example.com")
+	user.AddProfile(profile)
+	Users = append(Users, user)
+	Rules = append(Rules, forward)
+	return user, forward
+}
+
+func TestGatekeeper_ForwardChainDeny(t *testing.T) {
+	keep := core.NewEmptyRule("keep-42")
+	keep.UpdateVerb(core.VerbAll)
+	keep.SetTargetResourceTypeAndID(core.ResourceTypeProject, "42")
+	keep.UpdateAction(core.ActionOption{Action: core.ActionDeny})
+	user, forward := forwardFixture(keep.GetResourceID())
+	Rules = append(Rules, keep)
+	gk := NewGatekeeper()
+
+	d := gk.Decide(&RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 42, RequestVerb: core.VerbRead})
+	if d.Allowed || d.RuleID != keep.GetResourceID() {
+		t.Errorf("project 42: want deny by the chained rule, got %+v", d)
+	}
+	d = gk.Decide(&RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 7, RequestVerb: core.VerbRead})
+	if !d.Allowed || d.RuleID != forward.GetResourceID() {
+		t.Errorf("project 7: want allow by the forwarding rule, got %+v", d)
+	}
+}
+
+func TestGatekeeper_ForwardChainLoop(t *testing.T) {
+	user, forward := forwardFixture(0)
+	forward.UpdateAction(core.ActionOption{Action: core.ActionAllowAndForwardToNextRule, NextRuleID: forward.GetResourceID()})
+	gk := NewGatekeeper()
+
+	d := gk.Decide(&RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead})
+	if d.Allowed || d.Err == nil {
+		t.Errorf("want a deny with an error for a looping chain, got %+v", d)
+	}
+}
+
+func TestGatekeeper_DecideContextDone(t *testing.T) {
+	user, _ := forwardFixture(0)
+	gk := NewGatekeeper()
+	rc := &RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := gk.DecideContext(ctx, rc)
+	if d.Allowed || d.Effect != EffectIndeterminate || !errors.Is(d.Err, context.Canceled) {
+		t.Errorf("want indeterminate for a cancelled context, got %+v", d)
+	}
+	if allowed, err := gk.IsRequestAllowedContext(ctx, rc); allowed || !errors.Is(err, context.Canceled) {
+		t.Errorf("IsRequestAllowedContext = %v, %v", allowed, err)
+	}
+	if d := gk.DecideContext(context.Background(), rc); !d.Allowed {
+		t.Errorf("want allow without a deadline, got %+v", d)
+	}
+}
+
+// contextStore serves the package-level users and rules through the
+// context-aware lookups, and service accounts only through GetPrincipalByID
+type contextStore struct {
+	globalStore
+	accounts map[uint64]*core.ServiceAccount
+	onRule   func() // called on every rule lookup
+}
+
+func (contextStore) GetUserByIDContext(ctx context.Context, id uint64) (*core.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return GetUserByID(id)
+}
+
+func (s contextStore) GetRuleByIDContext(ctx context.Context, id uint64) (*core.Rule, error) {
+	if s.onRule != nil {
+		s.onRule()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.GetRuleByID(id)
+}
+
+func (s contextStore) GetPrincipalByID(id uint64) (core.Principal, error) {
+	if sa, ok := s.accounts[id]; ok {
+		return sa, nil
+	}
+	return GetUserByID(id)
+}
+
+func TestGatekeeper_ContextStoreResolvesServiceAccounts(t *testing.T) {
+	user, _ := forwardFixture(0)
+	sa := core.NewServiceAccount("ci", "", user.GetResourceID())
+	sa.AddProfile(&user.GetProfiles()[0])
+	gk := NewGatekeeper(WithStore(contextStore{accounts: map[uint64]*core.ServiceAccount{sa.GetResourceID(): sa}}))
+
+	for _, id := range []uint64{user.GetResourceID(), sa.GetResourceID()} {
+		rc := &RequestContext{PrincipalID: id, RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead}
+		if d := gk.Decide(rc); !d.Allowed {
+			t.Errorf("principal %d: want allow, got %+v", id, d)
+		}
+	}
+}
+
+func TestGatekeeper_ForwardChainHonoursContext(t *testing.T) {
+	keep := core.NewEmptyRule("keep-42")
+	keep.UpdateVerb(core.VerbAll)
+	keep.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+	keep.UpdateAction(core.ActionOption{Action: core.ActionDeny})
+	hop := core.NewEmptyRule("hop")
+	hop.UpdateVerb(core.VerbRead)
+	hop.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+	hop.UpdateAction(core.ActionOption{Action: core.ActionAllowAndForwardToNextRule, NextRuleID: keep.GetResourceID()})
+	user, _ := forwardFixture(hop.GetResourceID())
+	Rules = append(Rules, hop, keep)
+
+	// The context ends while the first link is looked up, so the chain
+	// stops before reaching the deny
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gk := NewGatekeeper(WithStore(contextStore{onRule: cancel}))
+	d := gk.DecideContext(ctx, &RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead})
+	if d.Effect != EffectIndeterminate || !errors.Is(d.Err, context.Canceled) {
+		t.Errorf("want an indeterminate decision once the context is canceled, got %+v", d)
+	}
+}
+
+type slowAttributes struct{}
+
+func (slowAttributes) ResourceAttributes(ctx context.Context, resourceType core.ResourceType, resourceID uint64) (map[string]any, error) {
+	if resourceID == 1 {
+		return map[string]any{"owner": "dave", "env": "prod"}, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGatekeeper_AttributeProvider(t *testing.T) {
+	user, _ := forwardFixture(0)
+	sink := &recordingSink{}
+	gk := NewGatekeeper(WithAttributeProvider(slowAttributes{}), WithAuditSink(sink))
+
+	rc := &RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead, Attributes: map[string]any{"env": "dev"}}
+	if d := gk.Decide(rc); !d.Allowed {
+		t.Fatalf("want allow, got %+v", d)
+	}
+	attrs := sink.records[0].Request.Attributes
+	if attrs["owner"] != "dave" || attrs["env"] != "dev" {
+		t.Errorf("audited attributes = %v, want the provider's merged under the caller's", attrs)
+	}
+	if len(rc.Attributes) != 1 {
+		t.Errorf("caller's request was modified: %v", rc.Attributes)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rc = &RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 2, RequestVerb: core.VerbRead}
+	if d := gk.DecideContext(ctx, rc); d.Effect != EffectIndeterminate || !errors.Is(d.Err, context.DeadlineExceeded) {
+		t.Errorf("want indeterminate once the lookup outlives the deadline, got %+v", d)
+	}
+}
+
+func TestGatekeeper_Impersonation(t *testing.T) {
+	resetGlobals()
+	sink := &recordingSink{}
+	gk := NewGatekeeper(WithAuditSink(sink))
+
+	read := core.NewEmptyRule("read-projects")
+	read.UpdateVerb(core.VerbRead)
+	read.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+	read.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+	customers := core.NewProfile("customers", "")
+	customers.AddRule(read)
+	customer := core.NewUser("Carol", "Customer", "carol@example.com")
+	customer.AddProfile(customers)
+	other := core.NewUser("Oscar", "Customer", "oscar@example.com")
+	other.AddProfile(customers)
+
+	act := core.NewEmptyRule("impersonate-carol")
+	act.UpdateVerb(core.VerbImpersonate)
+	act.SetTargetResourceTypeAndID(core.ResourceTypeUser, fmt.Sprint(customer.GetResourceID()))
+	act.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+	support := core.NewProfile("support", "")
+	support.AddRule(act)
+	engineer := core.NewUser("Sam", "Support", "sam@example.com")
+	engineer.AddProfile(support)
+	Users = append(Users, customer, other, engineer)
+
+	as := func(principal *core.User, verb core.Verb) Decision {
+		return gk.Decide(&RequestContext{PrincipalID: principal.GetResourceID(), ImpersonatorID: engineer.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 7, RequestVerb: verb})
+	}
+
+	d := as(customer, core.VerbRead)
+	if !d.Allowed || d.RuleID != read.GetResourceID() {
+		t.Errorf("acting as Carol: want allow by the customer's rule, got %+v", d)
+	}
+	if d.PrincipalID != customer.GetResourceID() || d.ImpersonatorID != engineer.GetResourceID() {
+		t.Errorf("decision does not name both identities: %+v", d)
+	}
+	if rec := sink.records[0]; rec.Request.ImpersonatorID != engineer.GetResourceID() {
+		t.Errorf("audit record lost the impersonator: %+v", rec.Request)
+	}
+
+	if d := as(customer, core.VerbDelete); d.Allowed {
+		t.Errorf("acting as Carol grants no more than Carol has, got %+v", d)
+	}
+	if d := as(other, core.VerbRead); d.Allowed || !strings.Contains(d.Reason, "may not impersonate") {
+		t.Errorf("acting as Oscar needs its own impersonate permission, got %+v", d)
+	}
+
+	// Every verb on every user still leaves impersonation out
+	all := core.NewEmptyRule("all-users")
+	all.UpdateVerb(core.VerbAll)
+	all.SetTargetResourceTypeAndID(core.ResourceTypeUser, core.ResourceIDAll)
+	all.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+	support.AddRule(all)
+	if d := as(other, core.VerbRead); d.Allowed {
+		t.Errorf("VerbAll must not imply impersonate, got %+v", d)
+	}
+}
+
+func TestGatekeeper_DynamicSeparationOfDuties(t *testing.T) {
+	resetGlobals()
+	gk := NewGatekeeper()
+
+	payments := func(name string, verb core.Verb) *core.Profile {
+		rule := core.NewEmptyRule(name)
+		rule.UpdateVerb(verb)
+		rule.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+		rule.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+		profile := core.NewProfile(name, "")
+		profile.AddRule(rule)
+		return profile
+	}
+	initiator := payments("payments-initiator", core.VerbCreate)
+	approver := payments("payments-approver", core.VerbUpdate)
+	initiator.SetConflict(approver.GetResourceID(), core.SoDDynamic)
+
+	user := core.NewUser("Pat", "Payments", "pat@example.com")
+	if _, err := user.AddProfile(initiator); err != nil {
+		t.Fatalf("AddProfile failed: %v", err)
+	}
+	if _, err := user.AddProfile(approver); err != nil {
+		t.Fatalf("a dynamic constraint must not stop holding both profiles: %v", err)
+	}
+	Users = append(Users, user)
+
+	payment := func(verb core.Verb) *RequestContext {
+		return &RequestContext{PrincipalID: user.GetResourceID(), RequestResourceType: core.ResourceTypeProject, RequestResourceID: 9, RequestVerb: verb}
+	}
+
+	session := NewSession()
+	if d := session.Decide(gk, payment(core.VerbCreate)); !d.Allowed || d.ProfileID != initiator.GetResourceID() {
+		t.Fatalf("initiating: want allow by the initiator profile, got %+v", d)
+	}
+	d := session.Decide(gk, payment(core.VerbUpdate))
+	var violation *core.SoDViolation
+	if d.Allowed || !errors.As(d.Err, &violation) || violation.ConflictingProfileID != initiator.GetResourceID() {
+		t.Errorf("approving in the same session: want a dynamic SoD denial, got %+v", d)
+	}
+
+	if d := NewSession().Decide(gk, payment(core.VerbUpdate)); !d.Allowed {
+		t.Errorf("approving in another session: want allow, got %+v", d)
+	}
+
+	// A static constraint stops the profiles being held together at all
+	initiator.SetConflict(approver.GetResourceID(), core.SoDStatic)
+	other := core.NewUser("Quinn", "Payments", "quinn@example.com")
+	other.AddProfile(approver)
+	if _, err := other.AddProfile(initiator); !errors.Is(err, core.ErrSoDViolation) || other.HasProfile(initiator.GetResourceID()) {
+		t.Errorf("want ErrSoDViolation and no change, got %v", err)
+	}
+}
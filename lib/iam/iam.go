@@ -0,0 +1,423 @@
+// Package iam converts between AWS IAM-style policy documents and profiles.
+//
+// An identity policy becomes one profile: every statement becomes one rule
+// per resource it names, Allow and Deny keep their meaning (deny overrides
+// allow in both models), actions become verbs and ARN-like resources become
+// resource types and IDs through a Mapping. Constructs the rule model cannot
+// express, such as Condition, NotResource or partial wildcards, are rejected
+// with an error wrapping ErrUnsupported instead of being dropped.
+//
+//	p, err := iam.Decode(f)
+//	doc, err := iam.ToProfile(p, "developers", iam.DefaultMapping())
+//	err = policy.Load(ctrl, doc)
+package iam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib/policy"
+)
+
+// Version is the policy language version written by FromProfile
+const Version = "2012-10-17"
+
+// ErrUnsupported is wrapped by errors for valid IAM constructs that have no
+// equivalent in the rule model
+var ErrUnsupported = errors.New("not supported")
+
+// Policy is an IAM policy document
+type Policy struct {
+	Version   string     `json:"Version,omitempty"`
+	ID        string     `json:"Id,omitempty"`
+	Statement Statements `json:"Statement"`
+}
+
+// Statement is one IAM policy statement. Principal, NotPrincipal,
+// NotResource and Condition are decoded only so they can be reported.
+type Statement struct {
+	Sid          string                       `json:"Sid,omitempty"`
+	Effect       string                       `json:"Effect"`
+	Principal    json.RawMessage              `json:"Principal,omitempty"`
+	NotPrincipal json.RawMessage              `json:"NotPrincipal,omitempty"`
+	Action       Values                       `json:"Action,omitempty"`
+	NotAction    Values                       `json:"NotAction,omitempty"`
+	Resource     Values                       `json:"Resource,omitempty"`
+	NotResource  Values                       `json:"NotResource,omitempty"`
+	Condition    map[string]map[string]Values `json:"Condition,omitempty"`
+}
+
+// Statements accepts a single statement object as well as a list
+type Statements []Statement
+
+func (s *Statements) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var one Statement
+		if err := dec.Decode(&one); err != nil {
+			return err
+		}
+		*s = Statements{one}
+		return nil
+	}
+	var many []Statement
+	if err := dec.Decode(&many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+// Values is a string list that IAM also allows to be written as a single
+// string
+type Values []string
+
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*v = Values{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*v = many
+	return nil
+}
+
+func (v Values) MarshalJSON() ([]byte, error) {
+	if len(v) == 1 {
+		return json.Marshal(v[0])
+	}
+	return json.Marshal([]string(v))
+}
+
+// Decode reads a policy document. Unknown fields are an error, so that
+// nothing is ignored silently.
+func Decode(r io.Reader) (*Policy, error) {
+	var p Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid IAM policy: %w", err)
+	}
+	return &p, nil
+}
+
+// Encode writes p as indented JSON
+func (p *Policy) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// Mapping ties IAM names to the rule model. A resource is written
+// ARNPrefix + "type/id", e.g. "arn:rbac:::project/42", where type is a key of
+// Resources and id is numeric or "*"; the resource "*" is every resource.
+// Actions maps action names, compared case-insensitively as IAM does, to
+// verbs. Wildcard actions such as "rbac:*" only work when listed.
+type Mapping struct {
+	ARNPrefix string
+	Resources map[string]core.ResourceType
+	Actions   map[string]core.Verb
+}
+
+// DefaultMapping uses the prefix "arn:rbac:::", the lower-case name of each
+// resource type (e.g. "project") and one "rbac:" action per verb (e.g.
+// "rbac:Read"), plus "rbac:*" and "*" for every verb.
+func DefaultMapping() Mapping {
+	m := Mapping{
+		ARNPrefix: "arn:rbac:::",
+		Resources: make(map[string]core.ResourceType),
+		Actions: map[string]core.Verb{
+			"rbac:Read":        core.VerbRead,
+			"rbac:Create":      core.VerbCreate,
+			"rbac:Update":      core.VerbUpdate,
+			"rbac:Delete":      core.VerbDelete,
+			"rbac:List":        core.VerbList,
+			"rbac:Execute":     core.VerbExecute,
+			"rbac:Impersonate": core.VerbImpersonate,
+			"rbac:*":           core.VerbAll,
+			"*":                core.VerbAll,
+		},
+	}
+	for _, t := range core.ResourceTypes() {
+		m.Resources[strings.ToLower(t.String())] = t
+	}
+	return m
+}
+
+func (m Mapping) verb(action string) (core.Verb, error) {
+	for name, verb := range m.Actions {
+		if strings.EqualFold(name, action) {
+			return verb, nil
+		}
+	}
+	if strings.ContainsAny(action, "*?") {
+		return 0, fmt.Errorf("action pattern %q: %w", action, ErrUnsupported)
+	}
+	return 0, fmt.Errorf("unknown action %q", action)
+}
+
+func (m Mapping) resource(arn string) (core.ResourceType, string, error) {
+	if arn == "*" {
+		return core.ResourceTypeAll, core.ResourceIDAll, nil
+	}
+	rest, ok := strings.CutPrefix(arn, m.ARNPrefix)
+	if !ok {
+		return 0, "", fmt.Errorf("resource %q does not start with %q", arn, m.ARNPrefix)
+	}
+	typeName, id, ok := strings.Cut(rest, "/")
+	if !ok {
+		return 0, "", fmt.Errorf("resource %q has no type/id part", arn)
+	}
+	t, ok := m.Resources[typeName]
+	if !ok {
+		if strings.ContainsAny(typeName, "*?") {
+			return 0, "", fmt.Errorf("resource pattern %q: %w", arn, ErrUnsupported)
+		}
+		return 0, "", fmt.Errorf("resource %q: unknown resource type %q", arn, typeName)
+	}
+	if id == core.ResourceIDAll {
+		return t, id, nil
+	}
+	if strings.ContainsAny(id, "*?") {
+		return 0, "", fmt.Errorf("resource pattern %q: %w", arn, ErrUnsupported)
+	}
+	if !isNumeric(id) {
+		return 0, "", fmt.Errorf("resource %q: ID %q is not numeric", arn, id)
+	}
+	return t, id, nil
+}
+
+// ToProfile converts p into a document holding one profile named name and
+// its rules. Rules are named "<name>/<Sid>/<n>", with the statement's index
+// standing in for a missing Sid.
+func ToProfile(p *Policy, name string, m Mapping) (*policy.Document, error) {
+	if p.Version != "" && p.Version != Version {
+		return nil, fmt.Errorf("policy version %q: %w", p.Version, ErrUnsupported)
+	}
+	description := "imported from IAM policy"
+	if p.ID != "" {
+		description += " " + p.ID
+	}
+	ps := policy.ProfileSpec{Name: name, Description: description, RuleIDs: []uint64{}}
+	ps.ID = core.NewProfile(ps.Name, ps.Description).GetResourceID()
+	doc := &policy.Document{Rules: []policy.RuleSpec{}, Profiles: []policy.ProfileSpec{}, Users: []policy.UserSpec{}}
+
+	for i, st := range p.Statement {
+		sid := st.Sid
+		if sid == "" {
+			sid = fmt.Sprint(i)
+		}
+		rules, err := m.statement(st)
+		if err != nil {
+			return nil, fmt.Errorf("statement %s: %w", sid, err)
+		}
+		for j, rs := range rules {
+			rs.Name = fmt.Sprintf("%s/%s/%d", name, sid, j)
+			rs.ID = core.NewRule(rs.Name, rs.Description, rs.TargetResourceID, rs.Verb, rs.Action).GetResourceID()
+			doc.Rules = append(doc.Rules, rs)
+			ps.RuleIDs = append(ps.RuleIDs, rs.ID)
+		}
+	}
+	doc.Profiles = append(doc.Profiles, ps)
+	return doc, nil
+}
+
+// statement converts one statement into unnamed rule specs
+func (m Mapping) statement(st Statement) ([]policy.RuleSpec, error) {
+	switch {
+	case len(st.Principal) > 0 || len(st.NotPrincipal) > 0:
+		return nil, fmt.Errorf("Principal (resource-based policies): %w", ErrUnsupported)
+	case len(st.NotResource) > 0:
+		return nil, fmt.Errorf("NotResource: %w", ErrUnsupported)
+	case len(st.Condition) > 0:
+		return nil, fmt.Errorf("Condition: %w", ErrUnsupported)
+	case len(st.Action) > 0 && len(st.NotAction) > 0:
+		return nil, errors.New("both Action and NotAction are set")
+	case len(st.Action) == 0 && len(st.NotAction) == 0:
+		return nil, errors.New("neither Action nor NotAction is set")
+	case len(st.Resource) == 0:
+		return nil, errors.New("no Resource")
+	}
+
+	var action core.Action
+	switch st.Effect {
+	case "Allow":
+		action = core.ActionAllow
+	case "Deny":
+		action = core.ActionDeny
+	default:
+		return nil, fmt.Errorf("invalid Effect %q, want Allow or Deny", st.Effect)
+	}
+
+	var verb core.Verb
+	for _, name := range append(st.Action, st.NotAction...) {
+		v, err := m.verb(name)
+		if err != nil {
+			return nil, err
+		}
+		verb |= v
+	}
+	if len(st.NotAction) > 0 {
+		// Verbs are a closed set, so "everything but" is exact; like "*" it
+		// leaves out impersonate, which is only granted by name
+		verb = core.VerbAll &^ verb
+		if verb == 0 {
+			return nil, errors.New("NotAction excludes every verb")
+		}
+	}
+
+	rules := make([]policy.RuleSpec, 0, len(st.Resource))
+	for _, arn := range st.Resource {
+		t, id, err := m.resource(arn)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, policy.RuleSpec{TargetResourceType: t, TargetResourceID: id, Verb: verb, Action: action})
+	}
+	return rules, nil
+}
+
+// FromProfile writes the active rules of the profile with the given ID as a
+// policy, one statement per rule with Sid "Rule<ID>". Forwarding rules and
+// targets the mapping cannot name are an error.
+func FromProfile(doc *policy.Document, profileID uint64, m Mapping) (*Policy, error) {
+	var profile *policy.ProfileSpec
+	for i := range doc.Profiles {
+		if doc.Profiles[i].ID == profileID {
+			profile = &doc.Profiles[i]
+		}
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("no profile with ID %d", profileID)
+	}
+	rules := make(map[uint64]policy.RuleSpec, len(doc.Rules))
+	for _, rs := range doc.Rules {
+		rules[rs.ID] = rs
+	}
+
+	p := &Policy{Version: Version, Statement: Statements{}}
+	for _, id := range profile.RuleIDs {
+		rs, ok := rules[id]
+		if !ok {
+			return nil, fmt.Errorf("profile %d refers to unknown rule %d", profileID, id)
+		}
+		st, err := m.fromRule(rs)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", rs.ID, rs.Name, err)
+		}
+		p.Statement = append(p.Statement, st)
+	}
+	return p, nil
+}
+
+func (m Mapping) fromRule(rs policy.RuleSpec) (Statement, error) {
+	st := Statement{Sid: fmt.Sprintf("Rule%d", rs.ID)}
+	switch rs.Action {
+	case core.ActionAllow:
+		st.Effect = "Allow"
+	case core.ActionDeny:
+		st.Effect = "Deny"
+	default:
+		return Statement{}, fmt.Errorf("action %s: %w", rs.Action, ErrUnsupported)
+	}
+
+	actions, err := m.actionNames(rs.Verb)
+	if err != nil {
+		return Statement{}, err
+	}
+	st.Action = actions
+
+	resource, err := m.arn(rs.TargetResourceType, rs.TargetResourceID)
+	if err != nil {
+		return Statement{}, err
+	}
+	st.Resource = Values{resource}
+	return st, nil
+}
+
+// actionNames spells verb with as few mapped actions as possible: a single
+// action for the whole verb if one exists (a named one in preference to
+// "*"), otherwise one per verb bit
+func (m Mapping) actionNames(verb core.Verb) (Values, error) {
+	names := make([]string, 0, len(m.Actions))
+	for name := range m.Actions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == "*") != (names[j] == "*") {
+			return names[j] == "*"
+		}
+		return names[i] < names[j]
+	})
+
+	exact := func(v core.Verb) (string, bool) {
+		for _, name := range names {
+			if m.Actions[name] == v {
+				return name, true
+			}
+		}
+		return "", false
+	}
+	if name, ok := exact(verb); ok {
+		return Values{name}, nil
+	}
+
+	var actions Values
+	for bit := core.Verb(1); bit != 0 && bit <= verb; bit <<= 1 {
+		if verb&bit == 0 {
+			continue
+		}
+		name, ok := exact(bit)
+		if !ok {
+			return nil, fmt.Errorf("no action is mapped to verb %s", bit)
+		}
+		actions = append(actions, name)
+	}
+	if len(actions) == 0 {
+		return nil, errors.New("rule has no verb")
+	}
+	return actions, nil
+}
+
+func (m Mapping) arn(t core.ResourceType, id string) (string, error) {
+	if t == core.ResourceTypeAll {
+		if id != core.ResourceIDAll {
+			return "", fmt.Errorf("ID %q on every resource type: %w", id, ErrUnsupported)
+		}
+		return "*", nil
+	}
+	names := make([]string, 0, 1)
+	for name, mapped := range m.Resources {
+		if mapped == t {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no resource name is mapped to type %s", t)
+	}
+	sort.Strings(names)
+	return m.ARNPrefix + names[0] + "/" + id, nil
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
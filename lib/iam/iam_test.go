@@ -0,0 +1,136 @@
+package iam
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/policy"
+)
+
+const developers = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {"Sid": "ReadProjects", "Effect": "Allow", "Action": ["rbac:Read", "rbac:List"], "Resource": "arn:rbac:::project/*"},
+    {"Sid": "KeepProd", "Effect": "Deny", "Action": "rbac:Delete", "Resource": ["arn:rbac:::project/42", "arn:rbac:::project/43"]},
+    {"Effect": "Allow", "NotAction": "rbac:Delete", "Resource": "arn:rbac:::project/7"}
+  ]
+}`
+
+func TestToProfile(t *testing.T) {
+	p, err := Decode(strings.NewReader(developers))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	doc, err := ToProfile(p, "developers", DefaultMapping())
+	if err != nil {
+		t.Fatalf("ToProfile: %v", err)
+	}
+
+	want := []policy.RuleSpec{
+		{Name: "developers/ReadProjects/0", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "*", Verb: core.VerbRead | core.VerbList, Action: core.ActionAllow},
+		{Name: "developers/KeepProd/0", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "42", Verb: core.VerbDelete, Action: core.ActionDeny},
+		{Name: "developers/KeepProd/1", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "43", Verb: core.VerbDelete, Action: core.ActionDeny},
+		{Name: "developers/2/0", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "7", Verb: core.VerbAll &^ core.VerbDelete, Action: core.ActionAllow},
+	}
+	if len(doc.Rules) != len(want) {
+		t.Fatalf("want %d rules, got %+v", len(want), doc.Rules)
+	}
+	for i := range want {
+		want[i].ID = doc.Rules[i].ID
+		if !reflect.DeepEqual(doc.Rules[i], want[i]) {
+			t.Errorf("rule %d:\n got %+v\nwant %+v", i, doc.Rules[i], want[i])
+		}
+	}
+	if len(doc.Profiles) != 1 || len(doc.Profiles[0].RuleIDs) != 4 {
+		t.Fatalf("unexpected profiles: %+v", doc.Profiles)
+	}
+
+	doc.Users = []policy.UserSpec{{ID: 1, Name: "alice", ProfileIDs: []uint64{doc.Profiles[0].ID}}}
+	ctrl, err := policy.NewController(doc)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	decide := func(verb core.Verb, id uint64) bool {
+		return gk.Decide(&lib.RequestContext{PrincipalID: 1, RequestResourceType: core.ResourceTypeProject, RequestResourceID: id, RequestVerb: verb}).Allowed
+	}
+	if !decide(core.VerbRead, 42) || decide(core.VerbDelete, 42) || !decide(core.VerbUpdate, 7) || decide(core.VerbUpdate, 8) {
+		t.Error("imported profile does not decide like the IAM policy")
+	}
+}
+
+func TestToProfile_RejectsUnsupported(t *testing.T) {
+	for name, statement := range map[string]string{
+		"condition":    `{"Effect": "Allow", "Action": "rbac:Read", "Resource": "*", "Condition": {"Bool": {"aws:MultiFactorAuthPresent": "true"}}}`,
+		"notresource":  `{"Effect": "Allow", "Action": "rbac:Read", "NotResource": "arn:rbac:::project/1"}`,
+		"principal":    `{"Effect": "Allow", "Principal": "*", "Action": "rbac:Read", "Resource": "*"}`,
+		"action glob":  `{"Effect": "Allow", "Action": "rbac:Get*", "Resource": "*"}`,
+		"partial glob": `{"Effect": "Allow", "Action": "rbac:Read", "Resource": "arn:rbac:::project/4*"}`,
+	} {
+		p, err := Decode(strings.NewReader(`{"Version": "2012-10-17", "Statement": ` + statement + `}`))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", name, err)
+		}
+		if _, err := ToProfile(p, "x", DefaultMapping()); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: want ErrUnsupported, got %v", name, err)
+		}
+	}
+
+	if _, err := Decode(strings.NewReader(`{"Statement": [], "Extra": 1}`)); err == nil {
+		t.Error("unknown fields should be rejected")
+	}
+	p := &Policy{Statement: Statements{{Effect: "Allow", Action: Values{"rbac:Read"}, Resource: Values{"arn:rbac:::widget/1"}}}}
+	if _, err := ToProfile(p, "x", DefaultMapping()); err == nil || !strings.Contains(err.Error(), `unknown resource type "widget"`) {
+		t.Errorf("want an unknown resource type error, got %v", err)
+	}
+}
+
+func TestFromProfile_RoundTrip(t *testing.T) {
+	p, err := Decode(strings.NewReader(developers))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	doc, err := ToProfile(p, "developers", DefaultMapping())
+	if err != nil {
+		t.Fatalf("ToProfile: %v", err)
+	}
+
+	exported, err := FromProfile(doc, doc.Profiles[0].ID, DefaultMapping())
+	if err != nil {
+		t.Fatalf("FromProfile: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := exported.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for _, want := range []string{`"Action": "rbac:Delete"`, `"rbac:Read",`, `"Resource": "arn:rbac:::project/43"`, `"Effect": "Deny"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("export is missing %s:\n%s", want, buf.String())
+		}
+	}
+
+	reimported, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode export: %v", err)
+	}
+	again, err := ToProfile(reimported, "developers", DefaultMapping())
+	if err != nil {
+		t.Fatalf("ToProfile export: %v", err)
+	}
+	for i, rs := range again.Rules {
+		orig := doc.Rules[i]
+		if rs.TargetResourceType != orig.TargetResourceType || rs.TargetResourceID != orig.TargetResourceID || rs.Verb != orig.Verb || rs.Action != orig.Action {
+			t.Errorf("rule %d changed in the round trip: %+v, was %+v", i, rs, orig)
+		}
+	}
+
+	doc.Rules[0].Action = core.ActionAllowAndForwardToNextRule
+	if _, err := FromProfile(doc, doc.Profiles[0].ID, DefaultMapping()); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("forwarding rule: want ErrUnsupported, got %v", err)
+	}
+}
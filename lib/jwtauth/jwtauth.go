@@ -0,0 +1,368 @@
+// Package jwtauth turns verified JSON Web Tokens into Gatekeeper requests.
+//
+// A Verifier checks a token's HS256, RS256 or EdDSA signature against a
+// local JWK Set using only the standard library, then its exp, nbf, iss and
+// aud claims, and maps the claims to a principal ID and request attributes.
+//
+//	keys, err := jwtauth.LoadKeySet("/etc/rbac/jwks.json")
+//	v := jwtauth.NewVerifier(keys, jwtauth.WithIssuer("https://idp.example.com"), jwtauth.WithAudience("projects-api"))
+//	rc, err := v.RequestContext(token, core.ResourceTypeProject, 42, core.VerbRead)
+//	decision := gk.DecideContext(ctx, rc)
+package jwtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+var (
+	// ErrMalformed means the token is not a well-formed JWS compact JWT
+	ErrMalformed = errors.New("malformed token")
+	// ErrUnverifiable means no key of the set verified the signature
+	ErrUnverifiable = errors.New("signature not verified")
+	// ErrExpired means the token's exp has passed
+	ErrExpired = errors.New("token expired")
+	// ErrNotYetValid means the token's nbf is still ahead
+	ErrNotYetValid = errors.New("token not yet valid")
+	// ErrIssuer means iss is not one of the accepted issuers
+	ErrIssuer = errors.New("untrusted issuer")
+	// ErrAudience means aud does not name the configured audience
+	ErrAudience = errors.New("token not meant for this audience")
+	// ErrPrincipal means the claims do not identify a principal
+	ErrPrincipal = errors.New("no principal in token")
+)
+
+// Claims are a token's verified claims. Numbers are json.Number.
+type Claims map[string]any
+
+// GetString returns claim name if it is a string
+func (c Claims) GetString(name string) (string, bool) {
+	s, ok := c[name].(string)
+	return s, ok
+}
+
+// time returns a NumericDate claim
+func (c Claims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, true, fmt.Errorf("%w: %s is not a number", ErrMalformed, name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("%w: %s: %v", ErrMalformed, name, err)
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), true, nil
+}
+
+// audiences returns aud, which may be a string or a list
+func (c Claims) audiences() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		var out []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// PrincipalResolver maps verified claims to a principal ID, e.g. by looking
+// up the user named by an email claim
+type PrincipalResolver func(claims Claims) (uint64, error)
+
+// Option configures a Verifier built by NewVerifier
+type Option func(*Verifier)
+
+// WithIssuer accepts tokens from these issuers only. Without it iss is not
+// checked.
+func WithIssuer(issuers ...string) Option {
+	return func(v *Verifier) {
+		v.issuers = append(v.issuers, issuers...)
+	}
+}
+
+// WithAudience requires aud to name audience. Without it aud is not checked.
+func WithAudience(audience string) Option {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithClockSkew tolerates clocks that disagree by up to d when checking exp
+// and nbf. The default is one minute.
+func WithClockSkew(d time.Duration) Option {
+	return func(v *Verifier) {
+		if d >= 0 {
+			v.skew = d
+		}
+	}
+}
+
+// WithPrincipalClaim reads the principal ID, a number or a numeric string,
+// from claim name instead of "sub"
+func WithPrincipalClaim(name string) Option {
+	return func(v *Verifier) {
+		v.principal = numericClaim(name)
+	}
+}
+
+// WithPrincipalResolver derives the principal ID with fn, for tokens whose
+// subjects are not user IDs
+func WithPrincipalResolver(fn PrincipalResolver) Option {
+	return func(v *Verifier) {
+		v.principal = fn
+	}
+}
+
+// WithClaimAttribute copies claim into the request's Attributes under
+// attribute, when the token carries it
+func WithClaimAttribute(claim, attribute string) Option {
+	return func(v *Verifier) {
+		v.attributes[claim] = attribute
+	}
+}
+
+// Verifier checks tokens against a key set. It is safe for concurrent use.
+type Verifier struct {
+	keys       *KeySet
+	issuers    []string
+	audience   string
+	skew       time.Duration
+	principal  PrincipalResolver
+	attributes map[string]string // claim -> attribute
+	now        func() time.Time
+}
+
+// NewVerifier returns a verifier for keys. By default the principal is the
+// numeric "sub" claim and no claims become attributes.
+func NewVerifier(keys *KeySet, opts ...Option) *Verifier {
+	v := &Verifier{
+		keys:       keys,
+		skew:       time.Minute,
+		principal:  numericClaim("sub"),
+		attributes: make(map[string]string),
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify checks token's signature and its exp, nbf, iss and aud claims and
+// returns its claims. exp is required.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: want 3 segments, got %d", ErrMalformed, len(parts))
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+	if err := decodeJSON(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+	if err := v.verifySignature(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	// Only claims of a verified token are looked at
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(alg, kid string, signed, signature []byte) error {
+	var candidates []Key
+	for _, k := range v.keys.keys {
+		if (kid == "" || k.ID == kid) && k.fits(alg) {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("%w: no %q key with kid %q", ErrUnverifiable, alg, kid)
+	}
+
+	digest := sha256.Sum256(signed)
+	for _, k := range candidates {
+		switch key := k.key.(type) {
+		case []byte:
+			mac := hmac.New(sha256.New, key)
+			mac.Write(signed)
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, signed, signature) {
+				return nil
+			}
+		}
+	}
+	return ErrUnverifiable
+}
+
+func (v *Verifier) validate(claims Claims) error {
+	now := v.now()
+
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: no exp claim", ErrMalformed)
+	}
+	if !now.Before(exp.Add(v.skew)) {
+		return fmt.Errorf("%w at %s", ErrExpired, exp.UTC().Format(time.RFC3339))
+	}
+
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.skew).Before(nbf) {
+		return fmt.Errorf("%w until %s", ErrNotYetValid, nbf.UTC().Format(time.RFC3339))
+	}
+
+	if len(v.issuers) > 0 {
+		iss, _ := claims.GetString("iss")
+		if !contains(v.issuers, iss) {
+			return fmt.Errorf("%w %q", ErrIssuer, iss)
+		}
+	}
+	if v.audience != "" && !contains(claims.audiences(), v.audience) {
+		return fmt.Errorf("%w %q", ErrAudience, v.audience)
+	}
+	return nil
+}
+
+// RequestContext verifies token and describes the request its bearer is
+// making
+func (v *Verifier) RequestContext(token string, resourceType core.ResourceType, resourceID uint64, verb core.Verb) (*lib.RequestContext, error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	principalID, err := v.principal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes map[string]any
+	for claim, attribute := range v.attributes {
+		if value, ok := claims[claim]; ok {
+			if attributes == nil {
+				attributes = make(map[string]any, len(v.attributes))
+			}
+			attributes[attribute] = value
+		}
+	}
+	return &lib.RequestContext{
+		PrincipalID:         principalID,
+		RequestResourceType: resourceType,
+		RequestResourceID:   resourceID,
+		RequestVerb:         verb,
+		ContextDT:           v.now(),
+		Attributes:          attributes,
+	}, nil
+}
+
+// Principal verifies the request's bearer token and returns its principal
+// ID. It has the shape of middleware.PrincipalExtractor.
+func (v *Verifier) Principal(r *http.Request) (uint64, error) {
+	token, err := BearerToken(r)
+	if err != nil {
+		return 0, err
+	}
+	claims, err := v.Verify(token)
+	if err != nil {
+		return 0, err
+	}
+	return v.principal(claims)
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header
+func BearerToken(r *http.Request) (string, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("missing bearer token")
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// numericClaim reads the principal ID from claim name
+func numericClaim(name string) PrincipalResolver {
+	return func(claims Claims) (uint64, error) {
+		var text string
+		switch v := claims[name].(type) {
+		case json.Number:
+			text = v.String()
+		case string:
+			text = v
+		default:
+			return 0, fmt.Errorf("%w: claim %q is missing or not an ID", ErrPrincipal, name)
+		}
+		id, err := strconv.ParseUint(text, 10, 64)
+		if err != nil || id == 0 {
+			return 0, fmt.Errorf("%w: claim %q is %q, not a user ID", ErrPrincipal, name, text)
+		}
+		return id, nil
+	}
+}
+
+func decodeJSON(segment string, v any) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
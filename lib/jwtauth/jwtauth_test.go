@@ -0,0 +1,221 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+var (
+	hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+	rsaKey     *rsa.PrivateKey
+	edKey      ed25519.PrivateKey
+	clock      = time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+)
+
+func init() {
+	var err error
+	if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if _, edKey, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func testKeySet(t *testing.T) *KeySet {
+	t.Helper()
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "oct", "kid": "hs", "alg": "HS256", "k": %q},
+		{"kty": "RSA", "kid": "rs", "n": %q, "e": %q},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": %q},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "", "e": ""}
+	]}`, b64(hmacSecret), b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()), b64(edKey.Public().(ed25519.PublicKey)))
+	ks, err := ParseKeySet([]byte(jwks))
+	if err != nil {
+		t.Fatalf("ParseKeySet: %v", err)
+	}
+	return ks
+}
+
+// sign builds a token; kid selects the key and alg is written as given
+func sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	var sig []byte
+	switch kid {
+	case "hs":
+		sig = hmacSum(hmacSecret, signed)
+	case "rs":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ed":
+		sig = ed25519.Sign(edKey, []byte(signed))
+	}
+	return signed + "." + b64(sig)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":   "30",
+		"iss":   "https://idp.example.com",
+		"aud":   []string{"projects-api", "other"},
+		"exp":   clock.Add(time.Hour).Unix(),
+		"nbf":   clock.Add(-time.Minute).Unix(),
+		"email": "alice@example.com",
+	}
+}
+
+func newTestVerifier(t *testing.T, opts ...Option) *Verifier {
+	opts = append([]Option{WithIssuer("https://idp.example.com"), WithAudience("projects-api")}, opts...)
+	v := NewVerifier(testKeySet(t), opts...)
+	v.now = func() time.Time { return clock }
+	return v
+}
+
+func TestVerifier_Algorithms(t *testing.T) {
+	v := newTestVerifier(t)
+	for _, tc := range []struct{ alg, kid string }{{"HS256", "hs"}, {"RS256", "rs"}, {"EdDSA", "ed"}} {
+		if _, err := v.Verify(sign(t, tc.alg, tc.kid, validClaims())); err != nil {
+			t.Errorf("%s: %v", tc.alg, err)
+		}
+	}
+
+	// Without a kid every key fitting the algorithm is tried
+	header, _ := json.Marshal(map[string]string{"alg": "EdDSA"})
+	payload, _ := json.Marshal(validClaims())
+	signed := b64(header) + "." + b64(payload)
+	if _, err := v.Verify(signed + "." + b64(ed25519.Sign(edKey, []byte(signed)))); err != nil {
+		t.Errorf("EdDSA without kid: %v", err)
+	}
+}
+
+func TestVerifier_Rejects(t *testing.T) {
+	v := newTestVerifier(t)
+	with := func(key string, value any) map[string]any {
+		c := validClaims()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+	tampered := sign(t, "HS256", "hs", validClaims())
+	tampered = tampered[:len(tampered)-2] + "AA"
+
+	for name, tc := range map[string]struct {
+		token string
+		want  error
+	}{
+		"expired":        {sign(t, "HS256", "hs", with("exp", clock.Add(-2*time.Minute).Unix())), ErrExpired},
+		"no exp":         {sign(t, "HS256", "hs", with("exp", nil)), ErrMalformed},
+		"not yet valid":  {sign(t, "HS256", "hs", with("nbf", clock.Add(2*time.Minute).Unix())), ErrNotYetValid},
+		"wrong issuer":   {sign(t, "HS256", "hs", with("iss", "https://evil.example.com")), ErrIssuer},
+		"wrong audience": {sign(t, "HS256", "hs", with("aud", "billing")), ErrAudience},
+		"bad signature":  {tampered, ErrUnverifiable},
+		"alg none":       {sign(t, "none", "hs", validClaims()), ErrUnverifiable},
+		"alg confusion":  {sign(t, "HS256", "rs", validClaims()), ErrUnverifiable},
+		"unknown kid":    {sign(t, "HS256", "missing", validClaims()), ErrUnverifiable},
+		"two segments":   {"a.b", ErrMalformed},
+		"garbage header": {"!!!.e30.e30", ErrMalformed},
+	} {
+		if _, err := v.Verify(tc.token); !errors.Is(err, tc.want) {
+			t.Errorf("%s: want %v, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestVerifier_ClockSkew(t *testing.T) {
+	claims := validClaims()
+	claims["exp"] = clock.Add(-30 * time.Second).Unix()
+	token := sign(t, "HS256", "hs", claims)
+
+	if _, err := newTestVerifier(t).Verify(token); err != nil {
+		t.Errorf("default skew should tolerate 30s: %v", err)
+	}
+	if _, err := newTestVerifier(t, WithClockSkew(0)).Verify(token); !errors.Is(err, ErrExpired) {
+		t.Errorf("without skew: want ErrExpired, got %v", err)
+	}
+}
+
+func TestVerifier_RequestContext(t *testing.T) {
+	v := newTestVerifier(t, WithClaimAttribute("email", "principal_email"))
+	token := sign(t, "RS256", "rs", validClaims())
+
+	rc, err := v.RequestContext(token, core.ResourceTypeProject, 42, core.VerbRead)
+	if err != nil {
+		t.Fatalf("RequestContext: %v", err)
+	}
+	if rc.PrincipalID != 30 || rc.RequestResourceID != 42 || rc.RequestVerb != core.VerbRead || !rc.ContextDT.Equal(clock) {
+		t.Errorf("unexpected request: %+v", rc)
+	}
+	if rc.Attributes["principal_email"] != "alice@example.com" {
+		t.Errorf("attributes = %v", rc.Attributes)
+	}
+
+	byEmail := newTestVerifier(t, WithPrincipalResolver(func(c Claims) (uint64, error) {
+		if email, _ := c.GetString("email"); email == "alice@example.com" {
+			return 7, nil
+		}
+		return 0, ErrPrincipal
+	}))
+	if rc, err := byEmail.RequestContext(token, core.ResourceTypeProject, 42, core.VerbRead); err != nil || rc.PrincipalID != 7 {
+		t.Errorf("resolver: got %+v, %v", rc, err)
+	}
+
+	claims := validClaims()
+	claims["sub"] = "alice"
+	if _, err := v.RequestContext(sign(t, "RS256", "rs", claims), core.ResourceTypeProject, 42, core.VerbRead); !errors.Is(err, ErrPrincipal) {
+		t.Errorf("non-numeric sub: want ErrPrincipal, got %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/projects/42", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if id, err := v.Principal(req); err != nil || id != 30 {
+		t.Errorf("Principal = %d, %v", id, err)
+	}
+}
+
+func TestParseKeySet_RejectsWeakKeys(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "n": %q, "e": "AQAB"}]}`, b64(small.N.Bytes()))
+	if _, err := ParseKeySet([]byte(jwks)); err == nil {
+		t.Error("1024-bit RSA key should be rejected")
+	}
+	if _, err := ParseKeySet([]byte(`{"keys": [{"kty": "EC", "crv": "P-256"}]}`)); err == nil {
+		t.Error("unsupported key type should be rejected")
+	}
+}
+
+func hmacSum(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,136 @@
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// minRSABits is the smallest RSA modulus accepted from a key set
+const minRSABits = 2048
+
+// Key is one verification key of a KeySet
+type Key struct {
+	ID        string // "kid", may be empty
+	Algorithm string // "alg" the key is restricted to, empty for any its type allows
+	key       any    // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// KeySet holds the keys tokens are verified against
+type KeySet struct {
+	keys []Key
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	K   string `json:"k"` // oct
+	N   string `json:"n"` // RSA
+	E   string `json:"e"`
+	X   string `json:"x"` // OKP
+}
+
+// ParseKeySet reads a JWK Set ({"keys": [...]}) holding symmetric ("oct"),
+// RSA and Ed25519 ("OKP") public keys. Keys marked for a use other than
+// "sig" are skipped; anything else that cannot be used is an error.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var raw struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+	ks := &KeySet{}
+	for i, k := range raw.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (kid %q): %w", i, k.Kid, err)
+		}
+		ks.keys = append(ks.keys, Key{ID: k.Kid, Algorithm: k.Alg, key: key})
+	}
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("key set has no signing keys")
+	}
+	return ks, nil
+}
+
+// LoadKeySet reads a JWKS file
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := ParseKeySet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ks, nil
+}
+
+// Keys returns the keys of the set
+func (ks *KeySet) Keys() []Key {
+	return append([]Key(nil), ks.keys...)
+}
+
+func (k jwk) parse() (any, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := decodeSegment(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("invalid symmetric key")
+		}
+		return secret, nil
+	case "RSA":
+		n, errN := decodeSegment(k.N)
+		e, errE := decodeSegment(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key of %d bits is shorter than %d", pub.N.BitLen(), minRSABits)
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// fits reports whether k may verify a token signed with alg
+func (k Key) fits(alg string) bool {
+	if k.Algorithm != "" && k.Algorithm != alg {
+		return false
+	}
+	switch k.key.(type) {
+	case []byte:
+		return alg == "HS256"
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,406 @@
+// Package k8s imports Kubernetes RBAC manifests (Role, ClusterRole,
+// RoleBinding and ClusterRoleBinding, as JSON) into a policy.Document.
+//
+// Each role becomes a profile and each of its PolicyRules one allow rule per
+// mapped resource type and resource name. Kubernetes verbs become core.Verb
+// bits, and bindings assign the role's profile to the User subjects they
+// name. Roles and RoleBindings are namespaced and only imported when
+// Mapping.WidenNamespaces opts into widening them. Anything that cannot be
+// carried over exactly is skipped or approximated and described in the
+// returned Report; review it before loading the document.
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib/policy"
+)
+
+// Object is the subset of a Kubernetes RBAC object the importer reads. A
+// List carries its objects in Items.
+type Object struct {
+	APIVersion      string          `json:"apiVersion"`
+	Kind            string          `json:"kind"`
+	Metadata        ObjectMeta      `json:"metadata"`
+	Rules           []PolicyRule    `json:"rules,omitempty"`
+	AggregationRule json.RawMessage `json:"aggregationRule,omitempty"`
+	RoleRef         RoleRef         `json:"roleRef,omitempty"`
+	Subjects        []Subject       `json:"subjects,omitempty"`
+	Items           []Object        `json:"items,omitempty"`
+}
+
+type ObjectMeta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type PolicyRule struct {
+	APIGroups       []string `json:"apiGroups,omitempty"`
+	Resources       []string `json:"resources,omitempty"`
+	Verbs           []string `json:"verbs"`
+	ResourceNames   []string `json:"resourceNames,omitempty"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+}
+
+type RoleRef struct {
+	APIGroup string `json:"apiGroup"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+type Subject struct {
+	Kind      string `json:"kind"`
+	APIGroup  string `json:"apiGroup,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Mapping ties Kubernetes resources to resource types. Keys are
+// "group/resource" or plain "resource", e.g. "example.com/projects" or
+// "projects"; "*" is every resource. Plain keys only match rules on the core
+// group "" or on every group "*"; resources of other groups need a
+// "group/resource" key or are reported as unmapped.
+//
+// Rules have no namespaces, so Roles and RoleBindings, which only apply in
+// one namespace, are skipped and reported unless WidenNamespaces is set; it
+// imports them as rules and assignments that apply in every namespace.
+type Mapping struct {
+	Resources       map[string]core.ResourceType
+	WidenNamespaces bool
+}
+
+// DefaultMapping maps the lower-case plural of each resource type, e.g.
+// "projects" to core.ResourceTypeProject, and "*" to core.ResourceTypeAll
+func DefaultMapping() Mapping {
+	m := Mapping{Resources: map[string]core.ResourceType{"*": core.ResourceTypeAll}}
+	for _, t := range core.ResourceTypes() {
+		m.Resources[strings.ToLower(t.String())+"s"] = t
+	}
+	return m
+}
+
+func (m Mapping) resourceType(groups []string, resource string) (core.ResourceType, bool) {
+	for _, group := range groups {
+		if t, ok := m.Resources[group+"/"+resource]; ok {
+			return t, true
+		}
+	}
+	for _, group := range groups {
+		if group == "" || group == "*" {
+			t, ok := m.Resources[resource]
+			return t, ok
+		}
+	}
+	return core.ResourceTypeNone, false
+}
+
+// verbs maps Kubernetes verbs to core verbs. exact is false where distinct
+// Kubernetes verbs collapse into one core verb.
+var verbs = map[string]struct {
+	verb  core.Verb
+	exact bool
+}{
+	"get":              {core.VerbRead, true},
+	"list":             {core.VerbList, true},
+	"watch":            {core.VerbList, false},
+	"create":           {core.VerbCreate, true},
+	"update":           {core.VerbUpdate, true},
+	"patch":            {core.VerbUpdate, false},
+	"delete":           {core.VerbDelete, true},
+	"deletecollection": {core.VerbDelete, false},
+	"impersonate":      {core.VerbImpersonate, true},
+	"*":                {core.VerbAll, false}, // without impersonate, see core.VerbImpersonate
+}
+
+// Finding describes one construct that was not imported exactly
+type Finding struct {
+	Source  string // e.g. "ClusterRole/view rules[2]"
+	Message string
+}
+
+func (f Finding) String() string {
+	return f.Source + ": " + f.Message
+}
+
+// Report lists everything the importer skipped or approximated
+type Report []Finding
+
+func (r Report) String() string {
+	lines := make([]string, len(r))
+	for i, f := range r {
+		lines[i] = f.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Decode reads Kubernetes objects from r: a single object, a List, or a
+// stream of either. Lists are flattened.
+func Decode(r io.Reader) ([]Object, error) {
+	var objects []Object
+	dec := json.NewDecoder(r)
+	for {
+		var obj Object
+		err := dec.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kubernetes manifest: %w", err)
+		}
+		objects = append(objects, flatten(obj)...)
+	}
+}
+
+func flatten(obj Object) []Object {
+	if !strings.HasSuffix(obj.Kind, "List") {
+		return []Object{obj}
+	}
+	var objects []Object
+	for _, item := range obj.Items {
+		objects = append(objects, flatten(item)...)
+	}
+	return objects
+}
+
+// Import reads manifests from r and converts them, see Convert
+func Import(r io.Reader, m Mapping) (*policy.Document, Report, error) {
+	objects, err := Decode(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, report := Convert(objects, m)
+	return doc, report, nil
+}
+
+// Convert turns RBAC objects into a document. Roles are converted before
+// bindings regardless of their order, and objects of other kinds are
+// reported and skipped.
+func Convert(objects []Object, m Mapping) (*policy.Document, Report) {
+	c := &converter{
+		mapping:  m,
+		doc:      &policy.Document{Rules: []policy.RuleSpec{}, Profiles: []policy.ProfileSpec{}, Users: []policy.UserSpec{}},
+		profiles: make(map[string]int),
+		users:    make(map[string]int),
+	}
+	for _, obj := range objects {
+		switch obj.Kind {
+		case "Role", "ClusterRole":
+			c.role(obj)
+		case "RoleBinding", "ClusterRoleBinding":
+		default:
+			c.report(obj.Kind+"/"+obj.Metadata.Name, "not an RBAC object, skipped")
+		}
+	}
+	for _, obj := range objects {
+		if obj.Kind == "RoleBinding" || obj.Kind == "ClusterRoleBinding" {
+			c.binding(obj)
+		}
+	}
+	return c.doc, c.findings
+}
+
+type converter struct {
+	mapping  Mapping
+	doc      *policy.Document
+	findings Report
+	profiles map[string]int // profile name -> index in doc.Profiles
+	users    map[string]int // user name -> index in doc.Users
+}
+
+func (c *converter) report(source, format string, args ...any) {
+	c.findings = append(c.findings, Finding{Source: source, Message: fmt.Sprintf(format, args...)})
+}
+
+// profileName names the profile for a role; Roles are namespaced
+func profileName(kind, namespace, name string) string {
+	if kind == "Role" {
+		return "Role/" + namespace + "/" + name
+	}
+	return "ClusterRole/" + name
+}
+
+func (c *converter) role(obj Object) {
+	name := profileName(obj.Kind, obj.Metadata.Namespace, obj.Metadata.Name)
+	if _, dup := c.profiles[name]; dup {
+		c.report(name, "declared more than once, later declaration skipped")
+		return
+	}
+	if obj.Kind == "Role" {
+		if !c.mapping.WidenNamespaces {
+			c.report(name, "namespaced Role skipped: its rules would apply in every namespace")
+			return
+		}
+		c.report(name, "namespace %q dropped: the rules apply in every namespace", obj.Metadata.Namespace)
+	}
+	if len(obj.AggregationRule) > 0 {
+		c.report(name, "aggregationRule not supported: only the rules listed inline were imported")
+	}
+
+	description := "imported from Kubernetes " + obj.Kind
+	profile := core.NewProfile(name, description)
+	ps := policy.ProfileSpec{ID: profile.GetResourceID(), Name: name, Description: description, RuleIDs: []uint64{}}
+
+	for i, pr := range obj.Rules {
+		source := fmt.Sprintf("%s rules[%d]", name, i)
+		for j, rs := range c.policyRule(source, pr) {
+			rs.Name = fmt.Sprintf("%s#%d.%d", name, i, j)
+			rs.ID = core.NewRule(rs.Name, rs.Description, rs.TargetResourceID, rs.Verb, rs.Action).GetResourceID()
+			c.doc.Rules = append(c.doc.Rules, rs)
+			ps.RuleIDs = append(ps.RuleIDs, rs.ID)
+		}
+	}
+
+	c.profiles[name] = len(c.doc.Profiles)
+	c.doc.Profiles = append(c.doc.Profiles, ps)
+}
+
+// policyRule converts one PolicyRule into unnamed rule specs
+func (c *converter) policyRule(source string, pr PolicyRule) []policy.RuleSpec {
+	for _, url := range pr.NonResourceURLs {
+		c.report(source, "nonResourceURL %q skipped: URL rules match numeric resource IDs only", url)
+	}
+
+	var verb core.Verb
+	for _, name := range pr.Verbs {
+		mapped, ok := verbs[strings.ToLower(name)]
+		switch {
+		case !ok:
+			c.report(source, "verb %q has no equivalent and was skipped", name)
+		case name == "*":
+			c.report(source, "verb \"*\" imported without impersonate, which must be granted by name")
+			verb |= mapped.verb
+		case !mapped.exact:
+			c.report(source, "verb %q approximated as %s", name, mapped.verb)
+			verb |= mapped.verb
+		default:
+			verb |= mapped.verb
+		}
+	}
+	if verb == 0 {
+		if len(pr.Resources) > 0 {
+			c.report(source, "no verb could be mapped, rule skipped")
+		}
+		return nil
+	}
+
+	targets := []string{core.ResourceIDAll}
+	if len(pr.ResourceNames) > 0 {
+		targets = pr.ResourceNames
+		for _, name := range pr.ResourceNames {
+			if !isNumeric(name) {
+				c.report(source, "resourceName %q is not a numeric ID and will never match a request", name)
+			}
+		}
+	}
+
+	var specs []policy.RuleSpec
+	seen := make(map[core.ResourceType]bool)
+	for _, resource := range pr.Resources {
+		t, ok := c.mapping.resourceType(pr.APIGroups, resource)
+		if !ok {
+			c.report(source, "resource %q has no mapped resource type and was skipped", resource)
+			continue
+		}
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		if t == core.ResourceTypeAll {
+			// Rules on every type cannot name a target
+			if len(pr.ResourceNames) > 0 {
+				c.report(source, "resourceNames on %q dropped: the rule applies to every resource", resource)
+			}
+			specs = append(specs, policy.RuleSpec{TargetResourceType: t, TargetResourceID: core.ResourceIDAll, Verb: verb, Action: core.ActionAllow})
+			continue
+		}
+		for _, target := range targets {
+			specs = append(specs, policy.RuleSpec{TargetResourceType: t, TargetResourceID: target, Verb: verb, Action: core.ActionAllow})
+		}
+	}
+	return specs
+}
+
+func (c *converter) binding(obj Object) {
+	source := obj.Kind + "/" + obj.Metadata.Name
+	if obj.Kind == "RoleBinding" {
+		source = obj.Kind + "/" + obj.Metadata.Namespace + "/" + obj.Metadata.Name
+		if !c.mapping.WidenNamespaces {
+			c.report(source, "namespaced binding skipped: it would grant %s %q in every namespace", obj.RoleRef.Kind, obj.RoleRef.Name)
+			return
+		}
+	}
+
+	namespace := obj.Metadata.Namespace
+	if obj.RoleRef.Kind == "ClusterRole" {
+		namespace = ""
+		if obj.Kind == "RoleBinding" {
+			c.report(source, "grants ClusterRole %q in namespace %q only; imported without the namespace, i.e. everywhere", obj.RoleRef.Name, obj.Metadata.Namespace)
+		}
+	}
+	name := profileName(obj.RoleRef.Kind, namespace, obj.RoleRef.Name)
+	index, ok := c.profiles[name]
+	if !ok {
+		c.report(source, "refers to %s, which is not in the input; binding skipped", name)
+		return
+	}
+	profileID := c.doc.Profiles[index].ID
+
+	for _, subject := range obj.Subjects {
+		if subject.Kind != "User" {
+			c.report(source, "%s subject %q skipped: only User subjects can be assigned", subject.Kind, subject.Name)
+			continue
+		}
+		us := c.user(subject.Name)
+		if !containsID(us.ProfileIDs, profileID) {
+			us.ProfileIDs = append(us.ProfileIDs, profileID)
+		}
+	}
+}
+
+// user returns the spec for a Kubernetes user name, adding it on first use
+func (c *converter) user(name string) *policy.UserSpec {
+	if i, ok := c.users[name]; ok {
+		return &c.doc.Users[i]
+	}
+	var email string
+	if strings.Contains(name, "@") {
+		email = name
+	}
+	description := "imported from Kubernetes"
+	c.users[name] = len(c.doc.Users)
+	c.doc.Users = append(c.doc.Users, policy.UserSpec{
+		ID:          core.NewUser(name, description, email).GetResourceID(),
+		Name:        name,
+		Description: description,
+		Email:       email,
+		ProfileIDs:  []uint64{},
+	})
+	return &c.doc.Users[len(c.doc.Users)-1]
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package k8s
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/policy"
+)
+
+const manifests = `
+{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRole", "metadata": {"name": "project-viewer"},
+ "rules": [
+  {"apiGroups": [""], "resources": ["projects", "projects/status"], "verbs": ["get", "list", "watch"]},
+  {"nonResourceURLs": ["/healthz"], "verbs": ["get"]}
+ ]}
+{"apiVersion": "v1", "kind": "List", "items": [
+ {"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "Role", "metadata": {"name": "editor", "namespace": "team-a"},
+  "rules": [{"apiGroups": [""], "resources": ["projects"], "resourceNames": ["42", "web"], "verbs": ["update", "patch", "escalate"]}]},
+ {"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "RoleBinding", "metadata": {"name": "editors", "namespace": "team-a"},
+  "roleRef": {"apiGroup": "rbac.authorization.k8s.io", "kind": "Role", "name": "editor"},
+  "subjects": [{"kind": "User", "name": "alice@example.com"}, {"kind": "Group", "name": "devs"}]},
+ {"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRoleBinding", "metadata": {"name": "viewers"},
+  "roleRef": {"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "project-viewer"},
+  "subjects": [{"kind": "User", "name": "alice@example.com"}, {"kind": "User", "name": "bob"}]},
+ {"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRoleBinding", "metadata": {"name": "admins"},
+  "roleRef": {"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "cluster-admin"},
+  "subjects": [{"kind": "User", "name": "root"}]}
+]}
+`
+
+func TestImport(t *testing.T) {
+	m := DefaultMapping()
+	m.WidenNamespaces = true
+	doc, report, err := Import(strings.NewReader(manifests), m)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if len(doc.Profiles) != 2 || doc.Profiles[0].Name != "ClusterRole/project-viewer" || doc.Profiles[1].Name != "Role/team-a/editor" {
+		t.Fatalf("unexpected profiles: %+v", doc.Profiles)
+	}
+	if len(doc.Rules) != 3 {
+		t.Fatalf("want 3 rules, got %+v", doc.Rules)
+	}
+	if r := doc.Rules[0]; r.TargetResourceType != core.ResourceTypeProject || r.TargetResourceID != core.ResourceIDAll || r.Verb != core.VerbRead|core.VerbList {
+		t.Errorf("viewer rule: %+v", r)
+	}
+	if r := doc.Rules[1]; r.TargetResourceID != "42" || r.Verb != core.VerbUpdate || r.Action != core.ActionAllow {
+		t.Errorf("editor rule: %+v", r)
+	}
+	if len(doc.Users) != 2 || doc.Users[0].Email != "alice@example.com" || len(doc.Users[0].ProfileIDs) != 2 || doc.Users[1].Name != "bob" {
+		t.Fatalf("unexpected users: %+v", doc.Users)
+	}
+
+	for _, want := range []string{
+		`ClusterRole/project-viewer rules[0]: resource "projects/status" has no mapped resource type`,
+		`ClusterRole/project-viewer rules[0]: verb "watch" approximated as list`,
+		`ClusterRole/project-viewer rules[1]: nonResourceURL "/healthz" skipped`,
+		`Role/team-a/editor: namespace "team-a" dropped`,
+		`Role/team-a/editor rules[0]: verb "patch" approximated as update`,
+		`Role/team-a/editor rules[0]: verb "escalate" has no equivalent`,
+		`Role/team-a/editor rules[0]: resourceName "web" is not a numeric ID`,
+		`RoleBinding/team-a/editors: Group subject "devs" skipped`,
+		`ClusterRoleBinding/admins: refers to ClusterRole/cluster-admin, which is not in the input`,
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, report)
+		}
+	}
+
+	ctrl, err := policy.NewController(doc)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	alice, bob := doc.Users[0].ID, doc.Users[1].ID
+	for _, tc := range []struct {
+		principal uint64
+		verb      core.Verb
+		id        uint64
+		want      bool
+	}{
+		{alice, core.VerbUpdate, 42, true},
+		{alice, core.VerbUpdate, 7, false},
+		{bob, core.VerbList, 7, true},
+		{bob, core.VerbUpdate, 42, false},
+	} {
+		rc := &lib.RequestContext{PrincipalID: tc.principal, RequestResourceType: core.ResourceTypeProject, RequestResourceID: tc.id, RequestVerb: tc.verb}
+		if got := gk.Decide(rc).Allowed; got != tc.want {
+			t.Errorf("principal %d %s project %d: allowed=%v, want %v", tc.principal, tc.verb, tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestConvert_SkipsNamespacedByDefault(t *testing.T) {
+	doc, report := Convert([]Object{
+		{Kind: "ClusterRole", Metadata: ObjectMeta{Name: "viewer"}, Rules: []PolicyRule{{APIGroups: []string{""}, Resources: []string{"projects"}, Verbs: []string{"get"}}}},
+		{Kind: "Role", Metadata: ObjectMeta{Name: "editor", Namespace: "team-a"}, Rules: []PolicyRule{{APIGroups: []string{""}, Resources: []string{"projects"}, Verbs: []string{"update"}}}},
+		{Kind: "RoleBinding", Metadata: ObjectMeta{Name: "editors", Namespace: "team-a"},
+			RoleRef: RoleRef{Kind: "Role", Name: "editor"}, Subjects: []Subject{{Kind: "User", Name: "alice"}}},
+		{Kind: "RoleBinding", Metadata: ObjectMeta{Name: "viewers", Namespace: "team-a"},
+			RoleRef: RoleRef{Kind: "ClusterRole", Name: "viewer"}, Subjects: []Subject{{Kind: "User", Name: "alice"}}},
+	}, DefaultMapping())
+
+	if len(doc.Profiles) != 1 || doc.Profiles[0].Name != "ClusterRole/viewer" {
+		t.Errorf("unexpected profiles: %+v", doc.Profiles)
+	}
+	if len(doc.Rules) != 1 || len(doc.Users) != 0 {
+		t.Errorf("namespaced objects should grant nothing, got rules %+v and users %+v", doc.Rules, doc.Users)
+	}
+	want := []string{
+		`Role/team-a/editor: namespaced Role skipped`,
+		`RoleBinding/team-a/editors: namespaced binding skipped: it would grant Role "editor" in every namespace`,
+		`RoleBinding/team-a/viewers: namespaced binding skipped: it would grant ClusterRole "viewer" in every namespace`,
+	}
+	if len(report) != len(want) {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	for i, w := range want {
+		if !strings.Contains(report[i].String(), w) {
+			t.Errorf("report[%d] = %s, want %s", i, report[i], w)
+		}
+	}
+}
+
+func TestConvert_WildcardsAndGroups(t *testing.T) {
+	m := DefaultMapping()
+	m.Resources["example.com/workspaces"] = core.ResourceTypeProject
+
+	doc, report := Convert([]Object{{
+		Kind:     "ClusterRole",
+		Metadata: ObjectMeta{Name: "admin"},
+		Rules: []PolicyRule{
+			{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+			{APIGroups: []string{"example.com"}, Resources: []string{"workspaces"}, Verbs: []string{"delete"}},
+			{APIGroups: []string{"other.io"}, Resources: []string{"workspaces"}, Verbs: []string{"delete"}},
+			{APIGroups: []string{"apps"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+			{APIGroups: []string{"apps"}, Resources: []string{"projects"}, Verbs: []string{"get"}},
+		},
+	}}, m)
+
+	if len(doc.Rules) != 2 {
+		t.Fatalf("want 2 rules, got %+v", doc.Rules)
+	}
+	if r := doc.Rules[0]; r.TargetResourceType != core.ResourceTypeAll || r.Verb != core.VerbAll {
+		t.Errorf("wildcard rule: %+v", r)
+	}
+	if r := doc.Rules[1]; r.TargetResourceType != core.ResourceTypeProject || r.Verb != core.VerbDelete {
+		t.Errorf("grouped rule: %+v", r)
+	}
+	// Plain keys do not match resources of other groups, not even "*", and
+	// the "*" verb does not carry impersonate over
+	want := []string{
+		`rules[0]: verb "*" imported without impersonate`,
+		`rules[2]: resource "workspaces" has no mapped resource type`,
+		`rules[3]: verb "*" imported without impersonate`,
+		`rules[3]: resource "*" has no mapped resource type`,
+		`rules[4]: resource "projects" has no mapped resource type`,
+	}
+	if len(report) != len(want) {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	for i, w := range want {
+		if !strings.Contains(report[i].String(), w) {
+			t.Errorf("report[%d] = %s, want %s", i, report[i], w)
+		}
+	}
+
+	doc.Users = []policy.UserSpec{{ID: 1, Name: "root", ProfileIDs: []uint64{doc.Profiles[0].ID}}}
+	ctrl, err := policy.NewController(doc)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	rc := &lib.RequestContext{PrincipalID: 1, RequestResourceType: core.ResourceTypeRole, RequestResourceID: 5, RequestVerb: core.VerbCreate}
+	if d := lib.NewGatekeeper(lib.WithStore(ctrl)).Decide(rc); !d.Allowed {
+		t.Errorf("wildcard rule should allow everything, got %+v", d)
+	}
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/controllers"
+)
+
+func TestRegistry_TextFormat(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounterVec("requests_total", "Requests\nby path.", "path")
+	latency := reg.NewHistogramVec("latency_seconds", "Latency.", []float64{0.5, 0.1})
+	reg.NewGaugeFunc("up", "Whether it is up.", nil, func(emit func(float64, ...string)) { emit(1) })
+
+	requests.With(`/a"b\`).Add(2)
+	requests.With("/").Inc()
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.2)
+	latency.With().Observe(3)
+
+	var sb strings.Builder
+	if err := reg.WriteText(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP requests_total Requests\nby path.
+# TYPE requests_total counter
+requests_total{path="/"} 1
+requests_total{path="/a\"b\\"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="0.5"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.25
+latency_seconds_count 3
+# HELP up Whether it is up.
+# TYPE up gauge
+up 1
+`
+	if sb.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestMetrics_GatekeeperAndController(t *testing.T) {
+	ctrl := controllers.New()
+	var userID uint64
+	err := ctrl.Tx(func(tx *controllers.Tx) error {
+		user, err := tx.CreateUser("John", "User", "john@example.com")
+		if err != nil {
+			return err
+		}
+		userID = user.GetResourceID()
+		profile, err := tx.CreateProfile("readers", "")
+		if err != nil {
+			return err
+		}
+		rule := core.NewEmptyRule("read-projects")
+		rule.UpdateVerb(core.VerbRead)
+		rule.SetTargetResourceTypeAndID(core.ResourceTypeProject, core.ResourceIDAll)
+		rule.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+		if err := tx.CreateRule(rule); err != nil {
+			return err
+		}
+		if err := tx.AddRuleToProfile(profile.GetResourceID(), rule.GetResourceID()); err != nil {
+			return err
+		}
+		return tx.AssignProfile(userID, profile.GetResourceID())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := NewRegistry()
+	RegisterController(reg, ctrl)
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl), lib.WithDecisionCache(time.Minute, 100), lib.WithObserver(NewGatekeeperMetrics(reg)))
+
+	read := &lib.RequestContext{PrincipalID: userID, RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead}
+	gk.Decide(read)
+	gk.Decide(read)
+	gk.Decide(&lib.RequestContext{PrincipalID: userID, RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbDelete})
+	// Requests that can never be cached are not cache misses
+	gk.Decide(&lib.RequestContext{PrincipalID: userID, RequestResourceType: core.ResourceTypeProject, RequestResourceID: 1, RequestVerb: core.VerbRead,
+		Attributes: map[string]any{"env": "prod"}})
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`rbac_decisions_total{effect="allow",resource_type="Project",verb="read"} 3`,
+		`rbac_decisions_total{effect="deny",resource_type="Project",verb="delete"} 1`,
+		`rbac_decision_duration_seconds_count{resource_type="Project"} 4`,
+		`rbac_decision_rules_evaluated_count 3`,
+		`rbac_decision_cache_requests_total{result="hit"} 1`,
+		`rbac_decision_cache_requests_total{result="miss"} 2`,
+		`rbac_controller_entities{kind="User",state="active"} 1`,
+		`rbac_controller_entities{kind="Rule",state="deleted"} 0`,
+		`rbac_controller_event_queue_depth{kind="Profile"} 2`,
+		`rbac_controller_event_queue_capacity 100`,
+		`rbac_controller_resource_version 5`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %s in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/controllers"
+)
+
+// GatekeeperMetrics records decisions; pass it to lib.WithObserver
+type GatekeeperMetrics struct {
+	decisions      *CounterVec
+	latency        *HistogramVec
+	rulesEvaluated *HistogramVec
+	cache          *CounterVec
+}
+
+// NewGatekeeperMetrics registers the decision metrics in reg:
+//
+//	rbac_decisions_total{effect,resource_type,verb}
+//	rbac_decision_duration_seconds{resource_type}
+//	rbac_decision_rules_evaluated
+//	rbac_decision_cache_requests_total{result="hit|miss"}
+//
+// Only decisions that could have been cached count towards the cache
+// metric, so hit/(hit+miss) is the cache hit rate.
+func NewGatekeeperMetrics(reg *Registry) *GatekeeperMetrics {
+	return &GatekeeperMetrics{
+		decisions: reg.NewCounterVec("rbac_decisions_total",
+			"Authorization decisions by effect, requested resource type and verb.",
+			"effect", "resource_type", "verb"),
+		latency: reg.NewHistogramVec("rbac_decision_duration_seconds",
+			"Time taken to reach a decision, including cache lookups.",
+			ExponentialBuckets(0.000001, 4, 10), "resource_type"),
+		rulesEvaluated: reg.NewHistogramVec("rbac_decision_rules_evaluated",
+			"Active rules checked against each evaluated (uncached) request.",
+			[]float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500}),
+		cache: reg.NewCounterVec("rbac_decision_cache_requests_total",
+			"Decision cache lookups by result.",
+			"result"),
+	}
+}
+
+// ObserveDecision implements lib.DecisionObserver
+func (m *GatekeeperMetrics) ObserveDecision(ev lib.Evaluation) {
+	resourceType := ev.Request.RequestResourceType.String()
+	m.decisions.With(ev.Decision.Effect.String(), resourceType, core.FormatVerb(ev.Request.RequestVerb)).Inc()
+	m.latency.With(resourceType).Observe(ev.Latency.Seconds())
+	if ev.Cached {
+		m.cache.With("hit").Inc()
+		return
+	}
+	m.rulesEvaluated.With().Observe(float64(ev.RulesEvaluated))
+	if ev.Cacheable {
+		m.cache.With("miss").Inc()
+	}
+}
+
+// RegisterController registers gauges read from ctrl.Stats on every scrape:
+//
+//	rbac_controller_entities{kind,state="active|deleted"}
+//	rbac_controller_resource_version
+//	rbac_controller_event_queue_depth{kind}
+//	rbac_controller_event_queue_capacity
+//	rbac_controller_events_dropped_total
+//	rbac_controller_watchers
+func RegisterController(reg *Registry, ctrl *controllers.Controller) {
+	kinds := []core.ResourceType{core.ResourceTypeUser, core.ResourceTypeProfile, core.ResourceTypeRule, core.ResourceTypeServiceAccount, core.ResourceTypeGrant, core.ResourceTypeAccessRequest}
+
+	reg.NewGaugeFunc("rbac_controller_entities",
+		"Users, profiles, rules, service accounts, grants and access requests held by the controller, by state.",
+		[]string{"kind", "state"},
+		func(emit func(float64, ...string)) {
+			s := ctrl.Stats()
+			for i, count := range []controllers.EntityCount{s.Users, s.Profiles, s.Rules, s.ServiceAccounts, s.Grants, s.AccessRequests} {
+				emit(float64(count.Active), kinds[i].String(), "active")
+				emit(float64(count.Deleted), kinds[i].String(), "deleted")
+			}
+		})
+	reg.NewGaugeFunc("rbac_controller_resource_version",
+		"Resource version of the most recent committed change.",
+		nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ctrl.Stats().ResourceVersion))
+		})
+	reg.NewGaugeFunc("rbac_controller_event_queue_depth",
+		"Events waiting for the event loop, by kind.",
+		[]string{"kind"},
+		func(emit func(float64, ...string)) {
+			depth := ctrl.Stats().EventQueueDepth
+			for _, kind := range kinds {
+				emit(float64(depth[kind]), kind.String())
+			}
+		})
+	reg.NewGaugeFunc("rbac_controller_event_queue_capacity",
+		"Capacity of each event queue.",
+		nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ctrl.Stats().EventQueueCapacity))
+		})
+	reg.NewCounterFunc("rbac_controller_events_dropped_total",
+		"Events dropped because their queue was full.",
+		nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ctrl.Stats().EventsDropped))
+		})
+	reg.NewGaugeFunc("rbac_controller_watchers",
+		"Open Watch streams.",
+		nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(ctrl.Stats().Watchers))
+		})
+}
@@ -0,0 +1,329 @@
+// Package metrics exposes Gatekeeper and controller metrics in the
+// Prometheus text exposition format, using only the standard library.
+//
+//	reg := metrics.NewRegistry()
+//	metrics.RegisterController(reg, ctrl)
+//	gk := lib.NewGatekeeper(lib.WithStore(ctrl), lib.WithObserver(metrics.NewGatekeeperMetrics(reg)))
+//	http.Handle("/metrics", reg)
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metric families and writes them out on scrape
+type Registry struct {
+	mux      sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// family is one named metric with its HELP and TYPE
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteText writes every family in registration order
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mux.Lock()
+	families := append([]family(nil), r.families...)
+	r.mux.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the registry, e.g. on /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteText(w)
+}
+
+// --- Counters ---
+
+// Counter only goes up
+type Counter struct {
+	value uint64
+}
+
+// Inc adds one
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add adds n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// CounterVec is a family of counters partitioned by label values
+type CounterVec struct {
+	header
+	series seriesMap[*Counter]
+}
+
+// NewCounterVec registers a counter family
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{header: header{name, help, "counter", labels}}
+	r.register(name, c)
+	return c
+}
+
+// With returns the counter for labelValues, given in label order
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.series.get(c.header, labelValues, func() *Counter { return &Counter{} })
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.series.each(func(values []string, counter *Counter) {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, c.labelPairs(values, "", ""), counter.Value())
+	})
+}
+
+// --- Histograms ---
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mux     sync.Mutex
+	buckets []float64 // upper bounds, ascending
+	counts  []uint64  // per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a family of histograms partitioned by label values
+type HistogramVec struct {
+	header
+	buckets []float64
+	series  seriesMap[*Histogram]
+}
+
+// NewHistogramVec registers a histogram family with the given bucket upper
+// bounds; +Inf is implied
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{header: header{name, help, "histogram", labels}, buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// With returns the histogram for labelValues, given in label order
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.series.get(h.header, labelValues, func() *Histogram {
+		return &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.series.each(func(values []string, hist *Histogram) {
+		hist.mux.Lock()
+		counts := append([]uint64(nil), hist.counts...)
+		sum, count := hist.sum, hist.count
+		hist.mux.Unlock()
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(values, "", ""), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(values, "", ""), count)
+	})
+}
+
+// ExponentialBuckets returns count bounds starting at start, each factor
+// times the previous
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// --- Read-on-scrape families ---
+
+// FuncVec is a family whose values are read on every scrape, for state that
+// is already counted elsewhere
+type FuncVec struct {
+	header
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc registers a gauge family. collect is called on every scrape
+// and reports each series through emit, label values in label order.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) *FuncVec {
+	f := &FuncVec{header: header{name, help, "gauge", labels}, collect: collect}
+	r.register(name, f)
+	return f
+}
+
+// NewCounterFunc is NewGaugeFunc for values that only go up
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) *FuncVec {
+	f := &FuncVec{header: header{name, help, "counter", labels}, collect: collect}
+	r.register(name, f)
+	return f
+}
+
+func (f *FuncVec) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	f.collect(func(value float64, labelValues ...string) {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelPairs(labelValues, "", ""), formatFloat(value))
+	})
+}
+
+// --- Shared plumbing ---
+
+type header struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (h header) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, escapeHelp(h.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", h.name, h.kind)
+}
+
+// labelPairs renders {a="1",b="2"} plus an optional extra pair, or nothing
+// when there are no labels
+func (h header) labelPairs(values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range h.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// seriesMap holds the series of one family keyed by label values
+type seriesMap[T any] struct {
+	mux    sync.RWMutex
+	series map[string]T
+	values map[string][]string
+}
+
+func (m *seriesMap[T]) get(h header, labelValues []string, create func() T) T {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	m.mux.RLock()
+	s, ok := m.series[key]
+	m.mux.RUnlock()
+	if ok {
+		return s
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if s, ok := m.series[key]; ok {
+		return s
+	}
+	if m.series == nil {
+		m.series = make(map[string]T)
+		m.values = make(map[string][]string)
+	}
+	s = create()
+	m.series[key] = s
+	m.values[key] = append([]string(nil), labelValues...)
+	return s
+}
+
+// each visits series sorted by label values
+func (m *seriesMap[T]) each(fn func(values []string, s T)) {
+	m.mux.RLock()
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	series, values := make([]T, len(keys)), make([][]string, len(keys))
+	sort.Strings(keys)
+	for i, key := range keys {
+		series[i], values[i] = m.series[key], m.values[key]
+	}
+	m.mux.RUnlock()
+
+	for i := range keys {
+		fn(values[i], series[i])
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,287 @@
+// Package middleware maps net/http requests onto Gatekeeper checks.
+//
+// A route table ties each method and path template to the resource type,
+// resource-ID path parameter and verb being exercised. The principal is taken
+// from the request by a caller-supplied extractor, the Gatekeeper decides,
+// and the request is either passed on or answered with 401/403.
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+// CorrelationHeader carries the caller's request ID into audit records
+const CorrelationHeader = "X-Request-Id"
+
+// PrincipalExtractor returns the ID of the principal making r. An error
+// means the caller is unauthenticated and yields a 401.
+type PrincipalExtractor func(r *http.Request) (uint64, error)
+
+// ImpersonateHeader names the principal ID the caller wants to act as, see
+// WithImpersonation
+const ImpersonateHeader = "X-Impersonate-Principal"
+
+// APIKeyHeader carries a service account's API key, see APIKeyPrincipal
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator resolves a presented API key to its service account.
+// controllers.Controller implements it.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(presented string) (*core.ServiceAccount, error)
+}
+
+// APIKeyPrincipal returns an extractor that authenticates the APIKeyHeader
+// and yields the service account's ID
+func APIKeyPrincipal(auth APIKeyAuthenticator) PrincipalExtractor {
+	return func(r *http.Request) (uint64, error) {
+		presented := r.Header.Get(APIKeyHeader)
+		if presented == "" {
+			return 0, fmt.Errorf("missing %s header", APIKeyHeader)
+		}
+		sa, err := auth.AuthenticateAPIKey(presented)
+		if err != nil {
+			return 0, err
+		}
+		return sa.GetResourceID(), nil
+	}
+}
+
+// Route describes one protected endpoint
+type Route struct {
+	Method       string            // HTTP method, e.g. "GET"; "" matches any method
+	Pattern      string            // net/http path template, e.g. "/projects/{id}"
+	ResourceType core.ResourceType // type of the resource being accessed
+	IDParam      string            // path parameter holding the resource ID, "" for collections
+	Verb         core.Verb         // 0 derives the verb from Method, see VerbForMethod
+}
+
+// Authorizer is the Gatekeeper API the middleware depends on. Decisions
+// are bounded by the HTTP request's context.
+type Authorizer interface {
+	DecideContext(ctx context.Context, requestcontext *lib.RequestContext) lib.Decision
+}
+
+// UnauthorizedHandler writes the response for a request without a principal
+type UnauthorizedHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// ForbiddenHandler writes the response for a denied request
+type ForbiddenHandler func(w http.ResponseWriter, r *http.Request, decision lib.Decision)
+
+// Option configures a Middleware built by New
+type Option func(*Middleware)
+
+// WithUnauthorizedHandler replaces the default JSON 401 response
+func WithUnauthorizedHandler(h UnauthorizedHandler) Option {
+	return func(m *Middleware) {
+		m.unauthorized = h
+	}
+}
+
+// WithForbiddenHandler replaces the default JSON 403 response, which is a
+// 503 for indeterminate decisions
+func WithForbiddenHandler(h ForbiddenHandler) Option {
+	return func(m *Middleware) {
+		m.forbidden = h
+	}
+}
+
+// WithUnmatchedAllowed lets requests that match no route through unchecked.
+// By default they are denied.
+func WithUnmatchedAllowed() Option {
+	return func(m *Middleware) {
+		m.allowUnmatched = true
+	}
+}
+
+// WithImpersonation honours ImpersonateHeader: the extracted principal
+// becomes the impersonator of the principal the header names. The
+// Gatekeeper then requires both the impersonate permission and the
+// impersonated principal's own access. By default the header is ignored.
+func WithImpersonation() Option {
+	return func(m *Middleware) {
+		m.impersonation = true
+	}
+}
+
+// Middleware enforces Gatekeeper decisions on an http.Handler
+type Middleware struct {
+	gatekeeper     Authorizer
+	principal      PrincipalExtractor
+	routes         []Route
+	unauthorized   UnauthorizedHandler
+	forbidden      ForbiddenHandler
+	allowUnmatched bool
+	impersonation  bool
+}
+
+// New validates the route table and returns the middleware
+func New(gatekeeper Authorizer, principal PrincipalExtractor, routes []Route, opts ...Option) (*Middleware, error) {
+	if gatekeeper == nil || principal == nil {
+		return nil, fmt.Errorf("middleware needs a gatekeeper and a principal extractor")
+	}
+	m := &Middleware{
+		gatekeeper:   gatekeeper,
+		principal:    principal,
+		routes:       routes,
+		unauthorized: defaultUnauthorized,
+		forbidden:    defaultForbidden,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	for _, route := range routes {
+		if route.ResourceType == core.ResourceTypeNone {
+			return nil, fmt.Errorf("route %s %s: resource type cannot be ResourceTypeNone", route.Method, route.Pattern)
+		}
+		if route.IDParam != "" && !strings.Contains(route.Pattern, "{"+route.IDParam+"}") {
+			return nil, fmt.Errorf("route %s %s: pattern has no {%s} parameter", route.Method, route.Pattern, route.IDParam)
+		}
+	}
+	// ServeMux panics on malformed or conflicting patterns; surface that as
+	// an error here instead of at Wrap time.
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Wrap returns a handler that authorizes each request before calling next.
+// The decision is available to next through DecisionFromContext.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	mux := m.mux(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			if m.allowUnmatched {
+				next.ServeHTTP(w, r)
+				return
+			}
+			m.forbidden(w, r, lib.Decision{Effect: lib.EffectDeny, Reason: "no authorization route matches the request"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) mux(next http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range m.routes {
+		mux.Handle(strings.TrimSpace(route.Method+" "+route.Pattern), m.check(route, next))
+	}
+	return mux
+}
+
+func (m *Middleware) validate() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid route table: %v", r)
+		}
+	}()
+	m.mux(http.NotFoundHandler())
+	return nil
+}
+
+// check authorizes one matched route
+func (m *Middleware) check(route Route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verb := route.Verb
+		if verb == 0 {
+			verb = VerbForMethod(r.Method, route.IDParam == "")
+		}
+
+		principalID, err := m.principal(r)
+		if err != nil {
+			m.unauthorized(w, r, err)
+			return
+		}
+		var impersonatorID uint64
+		if target := r.Header.Get(ImpersonateHeader); m.impersonation && target != "" {
+			impersonatorID = principalID
+			if principalID, err = strconv.ParseUint(target, 10, 64); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s %q", ImpersonateHeader, target), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var resourceID uint64
+		if route.IDParam != "" {
+			resourceID, err = strconv.ParseUint(r.PathValue(route.IDParam), 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid resource id %q", r.PathValue(route.IDParam)), http.StatusBadRequest)
+				return
+			}
+		}
+
+		decision := m.gatekeeper.DecideContext(r.Context(), &lib.RequestContext{
+			PrincipalID:         principalID,
+			RequestResourceType: route.ResourceType,
+			RequestResourceID:   resourceID,
+			RequestVerb:         verb,
+			ContextDT:           time.Now(),
+			CorrelationID:       r.Header.Get(CorrelationHeader),
+			ImpersonatorID:      impersonatorID,
+		})
+		if !decision.Allowed {
+			m.forbidden(w, r, decision)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decisionKey{}, decision)))
+	})
+}
+
+// VerbForMethod maps an HTTP method to a verb: GET and HEAD read (or list
+// when collection is true), POST creates, PUT and PATCH update, DELETE
+// deletes, and anything else executes.
+func VerbForMethod(method string, collection bool) core.Verb {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		if collection {
+			return core.VerbList
+		}
+		return core.VerbRead
+	case http.MethodPost:
+		return core.VerbCreate
+	case http.MethodPut, http.MethodPatch:
+		return core.VerbUpdate
+	case http.MethodDelete:
+		return core.VerbDelete
+	default:
+		return core.VerbExecute
+	}
+}
+
+type decisionKey struct{}
+
+// DecisionFromContext returns the decision that let the request through
+func DecisionFromContext(ctx context.Context) (lib.Decision, bool) {
+	decision, ok := ctx.Value(decisionKey{}).(lib.Decision)
+	return decision, ok
+}
+
+func defaultUnauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+}
+
+func defaultForbidden(w http.ResponseWriter, r *http.Request, decision lib.Decision) {
+	if decision.Effect == lib.EffectIndeterminate {
+		writeError(w, http.StatusServiceUnavailable, "indeterminate", decision.Reason)
+		return
+	}
+	writeError(w, http.StatusForbidden, "forbidden", decision.Reason)
+}
+
+func writeError(w http.ResponseWriter, status int, code, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "reason": reason})
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/controllers"
+)
+
+func newProjectReader(t *testing.T) (*lib.Gatekeeper, *core.User) {
+	ctrl := controllers.New()
+	rule := core.NewEmptyRule("read-project-42")
+	rule.UpdateVerb(core.VerbRead)
+	rule.SetTargetResourceTypeAndID(core.ResourceTypeProject, "42")
+	rule.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+
+	var user *core.User
+	err := ctrl.Tx(func(tx *controllers.Tx) error {
+		user, _ = tx.CreateUser("John", "User", "john@example.com")
+		profile, _ := tx.CreateProfile("readers", "read")
+		tx.CreateRule(rule)
+		tx.AddRuleToProfile(profile.GetResourceID(), rule.GetResourceID())
+		return tx.AssignProfile(user.GetResourceID(), profile.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	return lib.NewGatekeeper(lib.WithStore(ctrl)), user
+}
+
+func headerPrincipal(r *http.Request) (uint64, error) {
+	id, err := strconv.ParseUint(r.Header.Get("X-User"), 10, 64)
+	if err != nil {
+		return 0, errors.New("missing X-User header")
+	}
+	return id, nil
+}
+
+func TestMiddleware_Decisions(t *testing.T) {
+	gk, user := newProjectReader(t)
+	routes := []Route{
+		{Method: "GET", Pattern: "/projects/{id}", ResourceType: core.ResourceTypeProject, IDParam: "id"},
+		{Method: "DELETE", Pattern: "/projects/{id}", ResourceType: core.ResourceTypeProject, IDParam: "id"},
+	}
+	mw, err := New(gk, headerPrincipal, routes)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	handler := mw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := DecisionFromContext(r.Context()); !ok {
+			t.Errorf("Expected decision in request context")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	userHeader := strconv.FormatUint(user.GetResourceID(), 10)
+	cases := []struct {
+		method, path, user string
+		want               int
+	}{
+		{"GET", "/projects/42", userHeader, http.StatusNoContent},
+		{"GET", "/projects/42", "", http.StatusUnauthorized},
+		{"DELETE", "/projects/42", userHeader, http.StatusForbidden},
+		{"GET", "/projects/43", userHeader, http.StatusForbidden},
+		{"GET", "/unrouted", userHeader, http.StatusForbidden},
+		{"GET", "/projects/abc", userHeader, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d (%s)", tc.method, tc.path, tc.want, rec.Code, rec.Body)
+		}
+		if rec.Code == http.StatusForbidden && tc.path != "/unrouted" {
+			var body map[string]string
+			json.NewDecoder(rec.Body).Decode(&body)
+			if body["reason"] == "" {
+				t.Errorf("%s %s: expected a decision reason in the 403 body", tc.method, tc.path)
+			}
+		}
+	}
+}
+
+func TestMiddleware_CancelledRequestIsUnavailable(t *testing.T) {
+	gk, user := newProjectReader(t)
+	mw, err := New(gk, headerPrincipal, []Route{{Method: "GET", Pattern: "/projects/{id}", ResourceType: core.ResourceTypeProject, IDParam: "id"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	handler := mw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler ran for a cancelled request")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/projects/42", nil).WithContext(ctx)
+	req.Header.Set("X-User", strconv.FormatUint(user.GetResourceID(), 10))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d (%s)", rec.Code, rec.Body)
+	}
+}
+
+func TestMiddleware_APIKeyPrincipal(t *testing.T) {
+	ctrl := controllers.New()
+	rule := core.NewEmptyRule("read-project-42")
+	rule.UpdateVerb(core.VerbRead)
+	rule.SetTargetResourceTypeAndID(core.ResourceTypeProject, "42")
+	rule.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+
+	var key string
+	err := ctrl.Tx(func(tx *controllers.Tx) error {
+		owner, _ := tx.CreateUser("John", "User", "john@example.com")
+		sa, err := tx.CreateServiceAccount("deploy-bot", "CI", owner.GetResourceID())
+		if err != nil {
+			return err
+		}
+		profile, _ := tx.CreateProfile("readers", "read")
+		tx.CreateRule(rule)
+		tx.AddRuleToProfile(profile.GetResourceID(), rule.GetResourceID())
+		if key, err = tx.IssueAPIKey(sa.GetResourceID(), time.Time{}); err != nil {
+			return err
+		}
+		return tx.AssignProfile(sa.GetResourceID(), profile.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	mw, err := New(lib.NewGatekeeper(lib.WithStore(ctrl)), APIKeyPrincipal(ctrl), []Route{{Method: "GET", Pattern: "/projects/{id}", ResourceType: core.ResourceTypeProject, IDParam: "id"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	handler := mw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for presented, want := range map[string]int{
+		key:                    http.StatusNoContent,
+		"":                     http.StatusUnauthorized,
+		key[:len(key)-1] + "x": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest("GET", "/projects/42", nil)
+		if presented != "" {
+			req.Header.Set(APIKeyHeader, presented)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("key %q: expected %d, got %d (%s)", presented, want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestMiddleware_Impersonation(t *testing.T) {
+	ctrl := controllers.New()
+	read := core.NewEmptyRule("read-project-42")
+	read.UpdateVerb(core.VerbRead)
+	read.SetTargetResourceTypeAndID(core.ResourceTypeProject, "42")
+	read.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+
+	var customer, engineer *core.User
+	err := ctrl.Tx(func(tx *controllers.Tx) error {
+		customer, _ = tx.CreateUser("Carol", "Customer", "carol@example.com")
+		engineer, _ = tx.CreateUser("Sam", "Support", "sam@example.com")
+		readers, _ := tx.CreateProfile("readers", "read")
+		tx.CreateRule(read)
+		tx.AddRuleToProfile(readers.GetResourceID(), read.GetResourceID())
+		tx.AssignProfile(customer.GetResourceID(), readers.GetResourceID())
+
+		act := core.NewEmptyRule("impersonate-users")
+		act.UpdateVerb(core.VerbImpersonate)
+		act.SetTargetResourceTypeAndID(core.ResourceTypeUser, core.ResourceIDAll)
+		act.UpdateAction(core.ActionOption{Action: core.ActionAllow})
+		support, _ := tx.CreateProfile("support", "act as customers")
+		tx.CreateRule(act)
+		tx.AddRuleToProfile(support.GetResourceID(), act.GetResourceID())
+		return tx.AssignProfile(engineer.GetResourceID(), support.GetResourceID())
+	})
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	routes := []Route{{Method: "GET", Pattern: "/projects/{id}", ResourceType: core.ResourceTypeProject, IDParam: "id"}}
+	serve := func(opts []Option, target string) int {
+		mw, err := New(lib.NewGatekeeper(lib.WithStore(ctrl)), headerPrincipal, routes, opts...)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		handler := mw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, _ := DecisionFromContext(r.Context())
+			if d.ImpersonatorID != engineer.GetResourceID() {
+				t.Errorf("decision does not name the impersonator: %+v", d)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		req := httptest.NewRequest("GET", "/projects/42", nil)
+		req.Header.Set("X-User", strconv.FormatUint(engineer.GetResourceID(), 10))
+		req.Header.Set(ImpersonateHeader, target)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	carol := strconv.FormatUint(customer.GetResourceID(), 10)
+	if code := serve([]Option{WithImpersonation()}, carol); code != http.StatusNoContent {
+		t.Errorf("acting as Carol: expected 204, got %d", code)
+	}
+	if code := serve(nil, carol); code != http.StatusForbidden {
+		t.Errorf("header without WithImpersonation: expected 403, got %d", code)
+	}
+	if code := serve([]Option{WithImpersonation()}, "carol"); code != http.StatusBadRequest {
+		t.Errorf("non-numeric target: expected 400, got %d", code)
+	}
+}
+
+func TestNew_RejectsBadRoutes(t *testing.T) {
+	gk := lib.NewGatekeeper()
+	bad := [][]Route{
+		{{Method: "GET", Pattern: "/projects/{id}", ResourceType: core.ResourceTypeProject, IDParam: "pid"}},
+		{{Method: "GET", Pattern: "/projects", ResourceType: core.ResourceTypeNone}},
+		{
+			{Method: "GET", Pattern: "/p/{id}", ResourceType: core.ResourceTypeProject, IDParam: "id"},
+			{Method: "GET", Pattern: "/p/{id}", ResourceType: core.ResourceTypeProject, IDParam: "id"},
+		},
+	}
+	for i, routes := range bad {
+		if _, err := New(gk, headerPrincipal, routes); err == nil {
+			t.Errorf("case %d: expected route table to be rejected", i)
+		}
+	}
+}
+
+func TestVerbForMethod(t *testing.T) {
+	if VerbForMethod("GET", true) != core.VerbList || VerbForMethod("GET", false) != core.VerbRead {
+		t.Errorf("GET should map to list for collections and read otherwise")
+	}
+	if VerbForMethod("PATCH", false) != core.VerbUpdate || VerbForMethod("POST", true) != core.VerbCreate {
+		t.Errorf("unexpected verb mapping for PATCH/POST")
+	}
+}
@@ -0,0 +1,26 @@
+package lib
+
+import "time"
+
+// Evaluation describes how one decision was reached
+type Evaluation struct {
+	Request        *RequestContext
+	Decision       Decision
+	Latency        time.Duration
+	RulesEvaluated int  // active rules checked against the request; 0 when Cached
+	Cached         bool // served from the decision cache
+	Cacheable      bool // looked up in, and if missing stored in, the decision cache
+}
+
+// DecisionObserver is told about every decision, e.g. to keep metrics. It
+// runs on the authorization path and must be cheap and non-blocking.
+type DecisionObserver interface {
+	ObserveDecision(ev Evaluation)
+}
+
+// WithObserver adds an observer; each is called in the order added
+func WithObserver(o DecisionObserver) GatekeeperOption {
+	return func(g *Gatekeeper) {
+		g.observers = append(g.observers, o)
+	}
+}
@@ -0,0 +1,236 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CauseKind names a policy change that can move access
+type CauseKind string
+
+const (
+	CauseUserAdded         CauseKind = "user_added"
+	CauseUserRemoved       CauseKind = "user_removed"
+	CauseProfileAssigned   CauseKind = "profile_assigned"
+	CauseProfileUnassigned CauseKind = "profile_unassigned"
+	CauseRuleAttached      CauseKind = "rule_attached"
+	CauseRuleDetached      CauseKind = "rule_detached"
+	CauseRuleAdded         CauseKind = "rule_added"
+	CauseRuleRemoved       CauseKind = "rule_removed"
+	CauseRuleChanged       CauseKind = "rule_changed"
+)
+
+// Cause is one policy change behind an access change. ProfileID is set for
+// assignments and attachments, RuleID for everything about rules.
+type Cause struct {
+	Kind      CauseKind `json:"kind"`
+	ProfileID uint64    `json:"profile_id,omitempty"`
+	RuleID    uint64    `json:"rule_id,omitempty"`
+}
+
+func (c Cause) String() string {
+	switch c.Kind {
+	case CauseUserAdded, CauseUserRemoved:
+		return strings.Replace(string(c.Kind), "_", " ", 1)
+	case CauseProfileAssigned, CauseProfileUnassigned:
+		return fmt.Sprintf("profile %d %s", c.ProfileID, strings.TrimPrefix(string(c.Kind), "profile_"))
+	case CauseRuleAttached:
+		return fmt.Sprintf("rule %d attached to profile %d", c.RuleID, c.ProfileID)
+	case CauseRuleDetached:
+		return fmt.Sprintf("rule %d detached from profile %d", c.RuleID, c.ProfileID)
+	default:
+		return fmt.Sprintf("rule %d %s", c.RuleID, strings.TrimPrefix(string(c.Kind), "rule_"))
+	}
+}
+
+// AccessChange is a permission a user gained or lost, and the policy
+// changes that did it
+type AccessChange struct {
+	Permission Permission `json:"permission"`
+	Causes     []Cause    `json:"causes"`
+}
+
+func (ac AccessChange) String() string {
+	causes := make([]string, len(ac.Causes))
+	for i, c := range ac.Causes {
+		causes[i] = c.String()
+	}
+	return fmt.Sprintf("%s (%s)", ac.Permission, strings.Join(causes, ", "))
+}
+
+// UserDiff is how one user's access differs between two documents
+type UserDiff struct {
+	UserID  uint64         `json:"user_id"`
+	Name    string         `json:"name"`
+	Added   []AccessChange `json:"added"`
+	Removed []AccessChange `json:"removed"`
+}
+
+// Diff compares the effective access of every user in from and to, such as
+// a live controller's Export and a candidate file, rather than their rules.
+// It returns the users whose access changes, by ID. A permission counts as
+// held when some allow covers it and no deny does; see Access.Allowed.
+// Changes are merged across verbs where the type, ID and causes agree.
+func Diff(from, to *Document) []UserDiff {
+	fromProfiles, fromRules := from.index()
+	toProfiles, toRules := to.index()
+	fromUsers, toUsers := usersByID(from), usersByID(to)
+	// Gained: an allow appeared, or the deny that took it back went away.
+	// Lost: the reverse.
+	gained := side{rules: toRules, otherUsers: fromUsers, otherProfiles: fromProfiles, otherRules: fromRules, appeared: true}
+	lost := side{rules: fromRules, otherUsers: toUsers, otherProfiles: toProfiles, otherRules: toRules}
+
+	ids := make([]uint64, 0, len(fromUsers)+len(toUsers))
+	for id := range fromUsers {
+		ids = append(ids, id)
+	}
+	for id := range toUsers {
+		if _, ok := fromUsers[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sortIDs(ids)
+
+	var diffs []UserDiff
+	for _, id := range ids {
+		fromUser, inFrom := fromUsers[id]
+		toUser, inTo := toUsers[id]
+		before, after := Access{UserID: id}, Access{UserID: id}
+		if inFrom {
+			before = userAccess(fromUser, fromProfiles, fromRules)
+		}
+		if inTo {
+			after = userAccess(toUser, toProfiles, toRules)
+		}
+
+		d := UserDiff{UserID: id, Name: toUser.Name, Added: []AccessChange{}, Removed: []AccessChange{}}
+		if !inTo {
+			d.Name = fromUser.Name
+		}
+		for _, p := range candidates(before, after) {
+			was, is := before.Allowed(p), after.Allowed(p)
+			switch {
+			case !was && is:
+				allows, _ := after.Covering(p)
+				_, denies := before.Covering(p)
+				d.Added = append(d.Added, AccessChange{Permission: p, Causes: mergeCauses(gained.causes(id, p, allows), lost.causes(id, p, denies))})
+			case was && !is:
+				allows, _ := before.Covering(p)
+				_, denies := after.Covering(p)
+				d.Removed = append(d.Removed, AccessChange{Permission: p, Causes: mergeCauses(lost.causes(id, p, allows), gained.causes(id, p, denies))})
+			}
+		}
+		d.Added, d.Removed = mergeVerbs(d.Added), mergeVerbs(d.Removed)
+		if len(d.Added) > 0 || len(d.Removed) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// side explains entitlements of one document by what differs in the other;
+// appeared is set when the one is the newer document
+type side struct {
+	rules         map[uint64]RuleSpec
+	otherUsers    map[uint64]UserSpec
+	otherProfiles map[uint64]ProfileSpec
+	otherRules    map[uint64]RuleSpec
+	appeared      bool
+}
+
+// causes returns why each entitlement, which covers p, holds on this side
+// but not the other. A rule changed in ways that do not affect p, say a
+// verb added beside it, is not a cause.
+func (s side) causes(userID uint64, p Permission, ents []Entitlement) []Cause {
+	pick := func(added, removed CauseKind) CauseKind {
+		if s.appeared {
+			return added
+		}
+		return removed
+	}
+
+	otherUser, ok := s.otherUsers[userID]
+	if !ok && len(ents) > 0 {
+		return []Cause{{Kind: pick(CauseUserAdded, CauseUserRemoved)}}
+	}
+	var causes []Cause
+	for _, e := range ents {
+		if !containsID(otherUser.ProfileIDs, e.ProfileID) {
+			causes = append(causes, Cause{Kind: pick(CauseProfileAssigned, CauseProfileUnassigned), ProfileID: e.ProfileID})
+		}
+		if ps, ok := s.otherProfiles[e.ProfileID]; !ok || !containsID(ps.RuleIDs, e.Chain[0]) {
+			causes = append(causes, Cause{Kind: pick(CauseRuleAttached, CauseRuleDetached), ProfileID: e.ProfileID, RuleID: e.Chain[0]})
+		}
+		for _, rid := range e.Chain {
+			switch rs, ok := s.otherRules[rid]; {
+			case !ok:
+				causes = append(causes, Cause{Kind: pick(CauseRuleAdded, CauseRuleRemoved), RuleID: rid})
+			case !reflect.DeepEqual(rs, s.rules[rid]) && (rs.Action != s.rules[rid].Action || !PermissionOf(rs).Covers(p)):
+				causes = append(causes, Cause{Kind: CauseRuleChanged, RuleID: rid})
+			}
+		}
+	}
+	return causes
+}
+
+// mergeCauses joins cause lists, dropping repeats and sorting them
+func mergeCauses(lists ...[]Cause) []Cause {
+	seen := make(map[Cause]bool)
+	merged := []Cause{}
+	for _, list := range lists {
+		for _, c := range list {
+			if !seen[c] {
+				seen[c] = true
+				merged = append(merged, c)
+			}
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		switch {
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.ProfileID != b.ProfileID:
+			return a.ProfileID < b.ProfileID
+		}
+		return a.RuleID < b.RuleID
+	})
+	return merged
+}
+
+// mergeVerbs folds single-verb changes of the same type and ID with the
+// same causes into one change; changes arrive sorted by permission
+func mergeVerbs(changes []AccessChange) []AccessChange {
+	merged := []AccessChange{}
+	for _, c := range changes {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.Permission.ResourceType == c.Permission.ResourceType && last.Permission.ResourceID == c.Permission.ResourceID &&
+				reflect.DeepEqual(last.Causes, c.Causes) {
+				last.Permission.Verb |= c.Permission.Verb
+				continue
+			}
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+func usersByID(doc *Document) map[uint64]UserSpec {
+	users := make(map[uint64]UserSpec, len(doc.Users))
+	for _, us := range doc.Users {
+		users[us.ID] = us
+	}
+	return users
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
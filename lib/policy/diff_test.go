@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+func TestDiff(t *testing.T) {
+	from := &Document{
+		Rules: []RuleSpec{
+			{ID: 10, Name: "read-projects", TargetResourceType: core.ResourceTypeProject, TargetResourceID: core.ResourceIDAll, Verb: core.VerbRead, Action: core.ActionAllow},
+			{ID: 11, Name: "keep-42", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "42", Verb: core.VerbRead, Action: core.ActionDeny},
+		},
+		Profiles: []ProfileSpec{
+			{ID: 20, Name: "readers", RuleIDs: []uint64{10, 11}},
+		},
+		Users: []UserSpec{
+			{ID: 30, Name: "alice", ProfileIDs: []uint64{20}},
+			{ID: 31, Name: "bob", ProfileIDs: []uint64{}},
+			{ID: 32, Name: "carol", ProfileIDs: []uint64{}},
+		},
+	}
+	to := &Document{
+		Rules: []RuleSpec{
+			{ID: 10, Name: "read-projects", TargetResourceType: core.ResourceTypeProject, TargetResourceID: core.ResourceIDAll, Verb: core.VerbRead | core.VerbList, Action: core.ActionAllow},
+			{ID: 12, Name: "delete-projects", TargetResourceType: core.ResourceTypeProject, TargetResourceID: core.ResourceIDAll, Verb: core.VerbDelete, Action: core.ActionAllow},
+		},
+		Profiles: []ProfileSpec{
+			{ID: 20, Name: "readers", RuleIDs: []uint64{10, 12}},
+		},
+		Users: []UserSpec{
+			{ID: 31, Name: "bob", ProfileIDs: []uint64{20}},
+			{ID: 32, Name: "carol", ProfileIDs: []uint64{}},
+		},
+	}
+	projects := func(id string, verb core.Verb) Permission {
+		return Permission{ResourceType: core.ResourceTypeProject, ResourceID: id, Verb: verb}
+	}
+
+	want := []UserDiff{
+		{UserID: 30, Name: "alice", Added: []AccessChange{}, Removed: []AccessChange{
+			{Permission: projects(core.ResourceIDAll, core.VerbRead), Causes: []Cause{{Kind: CauseUserRemoved}}},
+		}},
+		{UserID: 31, Name: "bob", Removed: []AccessChange{}, Added: []AccessChange{
+			{Permission: projects(core.ResourceIDAll, core.VerbRead), Causes: []Cause{{Kind: CauseProfileAssigned, ProfileID: 20}}},
+			{Permission: projects(core.ResourceIDAll, core.VerbDelete), Causes: []Cause{{Kind: CauseProfileAssigned, ProfileID: 20}, {Kind: CauseRuleAdded, RuleID: 12}, {Kind: CauseRuleAttached, ProfileID: 20, RuleID: 12}}},
+			{Permission: projects(core.ResourceIDAll, core.VerbList), Causes: []Cause{{Kind: CauseProfileAssigned, ProfileID: 20}, {Kind: CauseRuleChanged, RuleID: 10}}},
+		}},
+	}
+	if got := Diff(from, to); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Diff:\n got %+v\nwant %+v", got, want)
+	}
+
+	// Dropping a deny gains what it took back, and names the deny
+	to.Users = append(to.Users, UserSpec{ID: 30, Name: "alice", ProfileIDs: []uint64{20}})
+	to.Rules[0].Verb = core.VerbRead
+	to.Profiles[0].RuleIDs = []uint64{10}
+	got := Diff(from, to)
+	wantAlice := UserDiff{UserID: 30, Name: "alice", Removed: []AccessChange{}, Added: []AccessChange{
+		{Permission: projects("42", core.VerbRead), Causes: []Cause{{Kind: CauseRuleDetached, ProfileID: 20, RuleID: 11}, {Kind: CauseRuleRemoved, RuleID: 11}}},
+	}}
+	if len(got) == 0 || !reflect.DeepEqual(got[0], wantAlice) {
+		t.Fatalf("Diff after dropping the deny:\n got %+v\nwant %+v", got, wantAlice)
+	}
+}
@@ -0,0 +1,336 @@
+// Package policy moves whole policies in and out of a controller: users,
+// profiles, rules and the links between them, as a single Document.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib/controllers"
+)
+
+// Document is a complete, self-contained policy. IDs are kept as they are
+// when loaded; an ID of 0 is derived from the entity's content the same way
+// the core constructors do. Profiles and users refer to rules and profiles by
+// ID.
+type Document struct {
+	Rules    []RuleSpec    `json:"rules"`
+	Profiles []ProfileSpec `json:"profiles"`
+	Users    []UserSpec    `json:"users"`
+}
+
+// RuleSpec describes one rule
+type RuleSpec struct {
+	ID                 uint64
+	Name               string
+	Description        string
+	TargetResourceType core.ResourceType
+	TargetResourceID   string
+	Verb               core.Verb
+	Action             core.Action
+	ForwardRuleID      uint64
+}
+
+// ProfileSpec describes one profile and the rules attached to it
+type ProfileSpec struct {
+	ID          uint64   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	RuleIDs     []uint64 `json:"rule_ids"`
+}
+
+// UserSpec describes one user and the profiles assigned to them
+type UserSpec struct {
+	ID          uint64   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Email       string   `json:"email"`
+	ProfileIDs  []uint64 `json:"profile_ids"`
+}
+
+type ruleSpecJSON struct {
+	ID                 uint64 `json:"id"`
+	Name               string `json:"name"`
+	Description        string `json:"description"`
+	TargetResourceType string `json:"target_resource_type"`
+	TargetResourceID   string `json:"target_resource_id"`
+	Verb               string `json:"verb"`
+	Action             string `json:"action"`
+	ForwardRuleID      uint64 `json:"forward_rule_id,omitempty"`
+}
+
+func (rs RuleSpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ruleSpecJSON{
+		ID:                 rs.ID,
+		Name:               rs.Name,
+		Description:        rs.Description,
+		TargetResourceType: rs.TargetResourceType.String(),
+		TargetResourceID:   rs.TargetResourceID,
+		Verb:               core.FormatVerb(rs.Verb),
+		Action:             rs.Action.String(),
+		ForwardRuleID:      rs.ForwardRuleID,
+	})
+}
+
+func (rs *RuleSpec) UnmarshalJSON(data []byte) error {
+	var raw ruleSpecJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	targetType, err := core.ParseResourceType(raw.TargetResourceType)
+	if err != nil {
+		return err
+	}
+	verb, err := core.ParseVerb(raw.Verb)
+	if err != nil {
+		return err
+	}
+	action, err := core.ParseAction(raw.Action)
+	if err != nil {
+		return err
+	}
+	*rs = RuleSpec{
+		ID:                 raw.ID,
+		Name:               raw.Name,
+		Description:        raw.Description,
+		TargetResourceType: targetType,
+		TargetResourceID:   raw.TargetResourceID,
+		Verb:               verb,
+		Action:             action,
+		ForwardRuleID:      raw.ForwardRuleID,
+	}
+	return nil
+}
+
+// Build creates the core rule described by rs
+func (rs RuleSpec) Build() (*core.Rule, error) {
+	id := rs.ID
+	if id == 0 {
+		id = core.NewRule(rs.Name, rs.Description, rs.TargetResourceID, rs.Verb, rs.Action).GetResourceID()
+	}
+	rule := core.NewRuleWithID(id, rs.Name, rs.Description)
+	rule.UpdateVerb(rs.Verb)
+	if _, err := rule.UpdateAction(core.ActionOption{Action: rs.Action, NextRuleID: rs.ForwardRuleID}); err != nil {
+		return nil, fmt.Errorf("rule %q: %w", rs.Name, err)
+	}
+	if _, err := rule.SetTargetResourceTypeAndID(rs.TargetResourceType, rs.TargetResourceID); err != nil {
+		return nil, fmt.Errorf("rule %q: %w", rs.Name, err)
+	}
+	return rule, nil
+}
+
+// RuleSpecFrom describes an existing rule
+func RuleSpecFrom(r *core.Rule) RuleSpec {
+	return RuleSpec{
+		ID:                 r.GetResourceID(),
+		Name:               r.GetRuleName(),
+		Description:        r.GetRuleDescription(),
+		TargetResourceType: r.GetTargetResourceType(),
+		TargetResourceID:   r.GetTargetResourceID(),
+		Verb:               r.GetVerb(),
+		Action:             r.GetRuleAction(),
+		ForwardRuleID:      r.GetResourceForwardRuleID(),
+	}
+}
+
+// FindUser resolves ref, a numeric user ID, a user name or an email address,
+// to exactly one user of doc
+func (doc *Document) FindUser(ref string) (UserSpec, error) {
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		for _, u := range doc.Users {
+			if u.ID == id {
+				return u, nil
+			}
+		}
+		return UserSpec{}, fmt.Errorf("no user with ID %d", id)
+	}
+	var found []UserSpec
+	for _, u := range doc.Users {
+		if u.Name == ref || strings.EqualFold(u.Email, ref) {
+			found = append(found, u)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return UserSpec{}, fmt.Errorf("no user named %q", ref)
+	case 1:
+		return found[0], nil
+	default:
+		return UserSpec{}, fmt.Errorf("%d users match %q, use the numeric ID", len(found), ref)
+	}
+}
+
+// Decode reads a JSON document
+func Decode(r io.Reader) (*Document, error) {
+	var doc Document
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid policy document: %w", err)
+	}
+	return &doc, nil
+}
+
+// Encode writes doc as indented JSON
+func (doc *Document) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// ReadFile loads a policy document from path: the policy language (see
+// Parse) when the name ends in ".rbac", JSON otherwise.
+func ReadFile(path string) (*Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var doc *Document
+	if isDSL(path) {
+		doc, err = Parse(f)
+	} else {
+		doc, err = Decode(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// WriteFile replaces path with doc, in the format ReadFile expects for that
+// name. The file is written beside path and renamed into place.
+func WriteFile(path string, doc *Document) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if isDSL(path) {
+		err = Format(f, doc)
+	} else {
+		err = doc.Encode(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func isDSL(path string) bool {
+	return filepath.Ext(path) == ".rbac"
+}
+
+// Load adds every entity and link in doc to ctrl in a single transaction:
+// either the whole document lands or none of it does.
+func Load(ctrl *controllers.Controller, doc *Document) error {
+	return ctrl.Tx(func(tx *controllers.Tx) error {
+		for _, rs := range doc.Rules {
+			rule, err := rs.Build()
+			if err != nil {
+				return err
+			}
+			if err := tx.CreateRule(rule); err != nil {
+				return err
+			}
+		}
+		for _, ps := range doc.Profiles {
+			profile := core.NewProfile(ps.Name, ps.Description)
+			if ps.ID != 0 {
+				profile = core.NewProfileWithID(ps.ID, ps.Name, ps.Description)
+			}
+			if err := tx.InsertProfile(profile); err != nil {
+				return err
+			}
+			for _, ruleID := range ps.RuleIDs {
+				if err := tx.AddRuleToProfile(profile.GetResourceID(), ruleID); err != nil {
+					return err
+				}
+			}
+		}
+		for _, us := range doc.Users {
+			user := core.NewUser(us.Name, us.Description, us.Email)
+			if us.ID != 0 {
+				user = core.NewUserWithID(us.ID, us.Name, us.Description, us.Email)
+			}
+			if err := tx.InsertUser(user); err != nil {
+				return err
+			}
+			for _, profileID := range us.ProfileIDs {
+				if err := tx.AssignProfile(user.GetResourceID(), profileID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// NewController returns a stopped controller holding exactly doc
+func NewController(doc *Document, opts ...controllers.Option) (*controllers.Controller, error) {
+	ctrl := controllers.New(opts...)
+	if err := Load(ctrl, doc); err != nil {
+		return nil, err
+	}
+	return ctrl, nil
+}
+
+// Export captures the active entities of ctrl as a document, sorted by ID.
+// Soft-deleted entities and links to them are left out.
+func Export(ctrl *controllers.Controller) *Document {
+	doc := &Document{Rules: []RuleSpec{}, Profiles: []ProfileSpec{}, Users: []UserSpec{}}
+
+	ctrl.View(func() {
+		for _, r := range ctrl.GetRuleController().ListRules() {
+			if r.IsActive() {
+				doc.Rules = append(doc.Rules, RuleSpecFrom(r))
+			}
+		}
+		for _, p := range ctrl.GetProfileController().ListProfiles() {
+			if !p.IsActive() {
+				continue
+			}
+			ps := ProfileSpec{ID: p.GetResourceID(), Name: p.GetResourceName(), Description: p.GetResourceDescription(), RuleIDs: []uint64{}}
+			for _, rules := range p.GetRuleMap() {
+				for _, r := range rules {
+					if r.IsActive() {
+						ps.RuleIDs = append(ps.RuleIDs, r.GetResourceID())
+					}
+				}
+			}
+			sortIDs(ps.RuleIDs)
+			doc.Profiles = append(doc.Profiles, ps)
+		}
+		for _, u := range ctrl.GetUserController().ListActiveUsers() {
+			us := UserSpec{ID: u.GetResourceID(), Name: u.GetResourceName(), Description: u.GetResourceDescription(), Email: u.GetEmail(), ProfileIDs: []uint64{}}
+			for _, p := range u.GetProfiles() {
+				if p.IsActive() {
+					us.ProfileIDs = append(us.ProfileIDs, p.GetResourceID())
+				}
+			}
+			sortIDs(us.ProfileIDs)
+			doc.Users = append(doc.Users, us)
+		}
+	})
+
+	sort.Slice(doc.Rules, func(i, j int) bool { return doc.Rules[i].ID < doc.Rules[j].ID })
+	sort.Slice(doc.Profiles, func(i, j int) bool { return doc.Profiles[i].ID < doc.Profiles[j].ID })
+	sort.Slice(doc.Users, func(i, j int) bool { return doc.Users[i].ID < doc.Users[j].ID })
+	return doc
+}
+
+func sortIDs(ids []uint64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+	"github.com/farhansabbir/rbac/lib/controllers"
+)
+
+func sampleDocument() *Document {
+	return &Document{
+		Rules: []RuleSpec{
+			{ID: 10, Name: "read-projects", TargetResourceType: core.ResourceTypeProject, TargetResourceID: core.ResourceIDAll, Verb: core.VerbRead | core.VerbList, Action: core.ActionAllow},
+			{ID: 11, Name: "no-delete", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "42", Verb: core.VerbDelete, Action: core.ActionDeny},
+		},
+		Profiles: []ProfileSpec{
+			{ID: 20, Name: "readers", Description: "read projects", RuleIDs: []uint64{10, 11}},
+		},
+		Users: []UserSpec{
+			{ID: 30, Name: "alice", Email: "alice@example.com", ProfileIDs: []uint64{20}},
+		},
+	}
+}
+
+func TestDocument_LoadExportRoundTrip(t *testing.T) {
+	doc := sampleDocument()
+	ctrl, err := NewController(doc)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+
+	got := Export(ctrl)
+	if !reflect.DeepEqual(got, doc) {
+		t.Fatalf("export differs from loaded document:\n got %+v\nwant %+v", got, doc)
+	}
+
+	var buf bytes.Buffer
+	if err := got.Encode(&buf); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, doc) {
+		t.Fatalf("JSON round trip differs:\n got %+v\nwant %+v", decoded, doc)
+	}
+
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+	if !gk.Decide(&lib.RequestContext{PrincipalID: 30, RequestResourceType: core.ResourceTypeProject, RequestResourceID: 7, RequestVerb: core.VerbRead}).Allowed {
+		t.Error("alice should read project 7")
+	}
+}
+
+func TestDocument_LoadIsAtomic(t *testing.T) {
+	doc := sampleDocument()
+	doc.Users[0].ProfileIDs = []uint64{99}
+
+	ctrl := controllers.New()
+	if err := Load(ctrl, doc); err == nil {
+		t.Fatal("expected an error for a dangling profile ID")
+	}
+	if got := Export(ctrl); len(got.Rules)+len(got.Profiles)+len(got.Users) != 0 {
+		t.Fatalf("failed load left entities behind: %+v", got)
+	}
+}
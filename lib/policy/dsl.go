@@ -0,0 +1,436 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// SyntaxError reports where a policy source stopped making sense. Line and
+// Col are 1-based; Col counts bytes.
+type SyntaxError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Parse reads a document written in the policy language. The language is
+// line oriented: each non-blank line declares one rule, profile or user, and
+// '#' starts a comment that runs to the end of the line. Declarations may
+// come in any order; by convention rules come first, then profiles, then
+// users.
+//
+//	# Anyone with this profile may read and list every project ...
+//	rule 10:Project:*:read|list:allow name="read-projects"
+//	# ... but nobody may delete project 42.
+//	rule 11:Project:42:delete:deny name="keep-42" description="production"
+//	rule 12:URL:"/api/v1":read:allow_and_forward_to_next_rule:10 name="api"
+//
+//	profile 20 name="readers" rules=10,11
+//
+//	user 30 name="alice" email="alice@example.com" profiles=20
+//
+// A rule header is id:type:target:verb:action, followed by :forward-id for
+// allow_and_forward_to_next_rule. It is the same header Rule.GetRuleAsDSL
+// writes. Type and action use core.ResourceType and core.Action names; the
+// type may be empty (no target) or "*" (every type). Verbs use
+// core.FormatVerb syntax: names joined by '|', "*" for every verb, or
+// nothing. The target ID is written bare unless it contains whitespace, ':',
+// '"', '#' or anything Go would escape, in which case it is a quoted string.
+//
+// Attributes follow as key=value pairs. Strings are double-quoted with Go
+// escapes; ID lists are comma separated with no spaces. Rules take name and
+// description; profiles take name, description and rules; users take name,
+// description, email and profiles.
+//
+// Errors are reported as *SyntaxError, and an ID declared twice for the same
+// kind is an error. Whether referenced IDs exist is checked by Load.
+func Parse(r io.Reader) (*Document, error) {
+	doc := &Document{Rules: []RuleSpec{}, Profiles: []ProfileSpec{}, Users: []UserSpec{}}
+	seen := map[string]int{} // "kind id" -> line of first declaration
+
+	sc := bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		p := &lineParser{src: sc.Text(), line: lineNo}
+		p.skipSpace()
+		if p.done() {
+			continue
+		}
+
+		keywordCol := p.col()
+		keyword := p.word()
+		var (
+			id  uint64
+			err error
+		)
+		switch keyword {
+		case "rule":
+			var rs RuleSpec
+			rs, err = p.rule()
+			id = rs.ID
+			doc.Rules = append(doc.Rules, rs)
+		case "profile":
+			var ps ProfileSpec
+			ps, err = p.profile()
+			id = ps.ID
+			doc.Profiles = append(doc.Profiles, ps)
+		case "user":
+			var us UserSpec
+			us, err = p.user()
+			id = us.ID
+			doc.Users = append(doc.Users, us)
+		default:
+			err = p.errorAt(keywordCol, "expected rule, profile or user, found %q", keyword)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if id != 0 {
+			key := keyword + " " + strconv.FormatUint(id, 10)
+			if first, dup := seen[key]; dup {
+				return nil, p.errorAt(keywordCol, "%s %d already declared on line %d", keyword, id, first)
+			}
+			seen[key] = lineNo
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ParseString is Parse over a string
+func ParseString(src string) (*Document, error) {
+	return Parse(strings.NewReader(src))
+}
+
+// Format writes the canonical form of doc: its entities in order, rules
+// first, then profiles, then users, a blank line between kinds and empty
+// attributes left out. Parse(Format(doc)) reproduces doc exactly when its ID
+// lists are non-nil, which holds for every document Parse, Decode and Export
+// return.
+func Format(w io.Writer, doc *Document) error {
+	var sections []string
+
+	var rules strings.Builder
+	for _, rs := range doc.Rules {
+		rules.WriteString(formatRule(rs) + "\n")
+	}
+
+	var profiles strings.Builder
+	for _, ps := range doc.Profiles {
+		fmt.Fprintf(&profiles, "profile %d name=%s", ps.ID, strconv.Quote(ps.Name))
+		writeString(&profiles, "description", ps.Description)
+		writeIDs(&profiles, "rules", ps.RuleIDs)
+		profiles.WriteString("\n")
+	}
+
+	var users strings.Builder
+	for _, us := range doc.Users {
+		fmt.Fprintf(&users, "user %d name=%s", us.ID, strconv.Quote(us.Name))
+		writeString(&users, "description", us.Description)
+		writeString(&users, "email", us.Email)
+		writeIDs(&users, "profiles", us.ProfileIDs)
+		users.WriteString("\n")
+	}
+
+	for _, section := range []string{rules.String(), profiles.String(), users.String()} {
+		if section != "" {
+			sections = append(sections, section)
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(sections, "\n"))
+	return err
+}
+
+// FormatString is Format into a string
+func FormatString(doc *Document) string {
+	var sb strings.Builder
+	Format(&sb, doc)
+	return sb.String()
+}
+
+func formatRule(rs RuleSpec) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "rule %d:%s:%s:%s:%s", rs.ID, rs.TargetResourceType, formatTarget(rs.TargetResourceID), core.FormatVerb(rs.Verb), rs.Action)
+	if rs.Action == core.ActionAllowAndForwardToNextRule || rs.ForwardRuleID != 0 {
+		fmt.Fprintf(&sb, ":%d", rs.ForwardRuleID)
+	}
+	fmt.Fprintf(&sb, " name=%s", strconv.Quote(rs.Name))
+	writeString(&sb, "description", rs.Description)
+	return sb.String()
+}
+
+// formatTarget mirrors the quoting in core.Rule.GetRuleAsDSL
+func formatTarget(id string) string {
+	if strings.ContainsAny(id, " \t:\"#") || strconv.Quote(id) != `"`+id+`"` {
+		return strconv.Quote(id)
+	}
+	return id
+}
+
+func writeString(w io.StringWriter, key, value string) {
+	if value != "" {
+		w.WriteString(" " + key + "=" + strconv.Quote(value))
+	}
+}
+
+func writeIDs(w io.StringWriter, key string, ids []uint64) {
+	if len(ids) > 0 {
+		w.WriteString(" " + key + "=" + joinIDs(ids))
+	}
+}
+
+func joinIDs(ids []uint64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// lineParser walks one line of source
+type lineParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *lineParser) col() int { return p.pos + 1 }
+
+func (p *lineParser) done() bool {
+	return p.pos >= len(p.src) || p.src[p.pos] == '#'
+}
+
+func (p *lineParser) errorAt(col int, format string, args ...any) *SyntaxError {
+	return &SyntaxError{Line: p.line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *lineParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// until returns the text up to (not including) any byte in stop, or to the
+// end of the line
+func (p *lineParser) until(stop string) string {
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(stop, rune(p.src[p.pos])) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *lineParser) word() string {
+	return p.until(" \t#")
+}
+
+func (p *lineParser) expect(c byte, what string) error {
+	if p.pos >= len(p.src) || p.src[p.pos] != c {
+		return p.errorAt(p.col(), "expected %q %s", c, what)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *lineParser) id(what string) (uint64, error) {
+	col := p.col()
+	text := p.until(" \t#:")
+	id, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return 0, p.errorAt(col, "invalid %s %q", what, text)
+	}
+	return id, nil
+}
+
+// quoted reads a double-quoted Go string literal
+func (p *lineParser) quoted() (string, error) {
+	col := p.col()
+	if p.pos >= len(p.src) || p.src[p.pos] != '"' {
+		return "", p.errorAt(col, "expected a quoted string")
+	}
+	end := p.pos + 1
+	for end < len(p.src) && p.src[end] != '"' {
+		if p.src[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.src) {
+		return "", p.errorAt(col, "unterminated string")
+	}
+	s, err := strconv.Unquote(p.src[p.pos : end+1])
+	if err != nil {
+		return "", p.errorAt(col, "invalid string %s", p.src[p.pos:end+1])
+	}
+	p.pos = end + 1
+	return s, nil
+}
+
+// ids reads a comma-separated ID list
+func (p *lineParser) ids() ([]uint64, error) {
+	col := p.col()
+	text := p.word()
+	ids := []uint64{}
+	if text == "" {
+		return ids, nil
+	}
+	for _, part := range strings.Split(text, ",") {
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, p.errorAt(col, "invalid ID %q in list", part)
+		}
+		ids = append(ids, id)
+		col += len(part) + 1
+	}
+	return ids, nil
+}
+
+func (p *lineParser) rule() (RuleSpec, error) {
+	rs := RuleSpec{}
+	p.skipSpace()
+
+	var err error
+	if rs.ID, err = p.id("rule ID"); err != nil {
+		return rs, err
+	}
+	if err := p.expect(':', "after the rule ID"); err != nil {
+		return rs, err
+	}
+
+	col := p.col()
+	text := p.until(":")
+	if rs.TargetResourceType, err = core.ParseResourceType(text); err != nil {
+		return rs, p.errorAt(col, "%v", err)
+	}
+	if err := p.expect(':', "after the target type"); err != nil {
+		return rs, err
+	}
+
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		if rs.TargetResourceID, err = p.quoted(); err != nil {
+			return rs, err
+		}
+	} else {
+		rs.TargetResourceID = p.until(" \t#:\"")
+	}
+	if err := p.expect(':', "after the target ID"); err != nil {
+		return rs, err
+	}
+
+	col = p.col()
+	text = p.until(" \t#:")
+	if rs.Verb, err = core.ParseVerb(text); err != nil {
+		return rs, p.errorAt(col, "%v", err)
+	}
+	if err := p.expect(':', "after the verb"); err != nil {
+		return rs, err
+	}
+
+	col = p.col()
+	text = p.until(" \t#:")
+	if rs.Action, err = core.ParseAction(text); err != nil {
+		return rs, p.errorAt(col, "%v", err)
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == ':' {
+		p.pos++
+		if rs.ForwardRuleID, err = p.id("forward rule ID"); err != nil {
+			return rs, err
+		}
+	}
+	if rs.Action == core.ActionAllowAndForwardToNextRule && rs.ForwardRuleID == 0 {
+		return rs, p.errorAt(col, "%s needs a forward rule ID, e.g. %s:10", rs.Action, rs.Action)
+	}
+
+	err = p.attributes(map[string]any{
+		"name":        &rs.Name,
+		"description": &rs.Description,
+	})
+	return rs, err
+}
+
+func (p *lineParser) profile() (ProfileSpec, error) {
+	ps := ProfileSpec{RuleIDs: []uint64{}}
+	p.skipSpace()
+
+	var err error
+	if ps.ID, err = p.id("profile ID"); err != nil {
+		return ps, err
+	}
+	err = p.attributes(map[string]any{
+		"name":        &ps.Name,
+		"description": &ps.Description,
+		"rules":       &ps.RuleIDs,
+	})
+	return ps, err
+}
+
+func (p *lineParser) user() (UserSpec, error) {
+	us := UserSpec{ProfileIDs: []uint64{}}
+	p.skipSpace()
+
+	var err error
+	if us.ID, err = p.id("user ID"); err != nil {
+		return us, err
+	}
+	err = p.attributes(map[string]any{
+		"name":        &us.Name,
+		"description": &us.Description,
+		"email":       &us.Email,
+		"profiles":    &us.ProfileIDs,
+	})
+	return us, err
+}
+
+// attributes reads key=value pairs to the end of the line into fields, which
+// maps each allowed key to a *string, *uint64 or *[]uint64
+func (p *lineParser) attributes(fields map[string]any) error {
+	set := map[string]bool{}
+	for {
+		start := p.pos
+		p.skipSpace()
+		if p.done() {
+			return nil
+		}
+		if p.pos == start {
+			return p.errorAt(p.col(), "expected a space before the next attribute")
+		}
+
+		col := p.col()
+		key := p.until(" \t#=")
+		field, ok := fields[key]
+		if !ok {
+			return p.errorAt(col, "unknown attribute %q", key)
+		}
+		if set[key] {
+			return p.errorAt(col, "attribute %q given twice", key)
+		}
+		set[key] = true
+		if err := p.expect('=', "after "+key); err != nil {
+			return err
+		}
+
+		var err error
+		switch field := field.(type) {
+		case *string:
+			*field, err = p.quoted()
+		case *[]uint64:
+			*field, err = p.ids()
+		case *uint64:
+			*field, err = p.id(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+func TestDSL_FormatParseRoundTrip(t *testing.T) {
+	doc := sampleDocument()
+	doc.Rules = append(doc.Rules,
+		RuleSpec{ID: 12, Name: "api \"v1\"", Description: "line\nbreak", TargetResourceType: core.ResourceTypeURL, TargetResourceID: "/api/v1: #x", Verb: core.VerbAll, Action: core.ActionAllowAndForwardToNextRule, ForwardRuleID: 10},
+		RuleSpec{ID: 13, Name: "nothing", TargetResourceType: core.ResourceTypeNone, Action: core.ActionDeny},
+		RuleSpec{ID: 14, Name: "everything", TargetResourceType: core.ResourceTypeAll, Verb: core.VerbCreate | core.VerbExecute, Action: core.ActionAllow},
+	)
+	doc.Profiles = append(doc.Profiles, ProfileSpec{ID: 21, Name: "empty", RuleIDs: []uint64{}})
+	doc.Users = append(doc.Users, UserSpec{ID: 31, Name: "bob", Description: "no email", ProfileIDs: []uint64{}})
+
+	src := FormatString(doc)
+	got, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("Parse(Format(doc)): %v\n%s", err, src)
+	}
+	if !reflect.DeepEqual(got, doc) {
+		t.Fatalf("round trip differs:\n got %+v\nwant %+v\nsource:\n%s", got, doc, src)
+	}
+	if again := FormatString(got); again != src {
+		t.Fatalf("format is not canonical:\n%s\nvs\n%s", again, src)
+	}
+}
+
+func TestDSL_ParseDocumented(t *testing.T) {
+	src := `
+# Anyone with this profile may read and list every project ...
+rule 10:Project:*:read|list:allow name="read-projects"
+# ... but nobody may delete project 42.
+rule 11:project:42:delete:deny   name="keep-42" description="production"  # trailing comment
+
+profile 20 name="readers" rules=10,11
+user 30 profiles=20 email="alice@example.com" name="alice"
+`
+	doc, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := sampleDocument()
+	want.Rules[1].Name, want.Rules[1].Description = "keep-42", "production"
+	want.Profiles[0].Description = ""
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("got %+v\nwant %+v", doc, want)
+	}
+
+	ctrl, err := NewController(doc)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	if got := FormatString(Export(ctrl)); got != FormatString(doc) {
+		t.Fatalf("export formats differently:\n%s\nvs\n%s", got, FormatString(doc))
+	}
+}
+
+func TestDSL_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src       string
+		line, col int
+	}{
+		{"group 1", 1, 1},
+		{"\n  rule x:Project:*:read:allow", 2, 8},
+		{"rule 1:Planet:*:read:allow", 1, 8},
+		{"rule 1:Project:*:fly:allow", 1, 18},
+		{"rule 1:Project:*:read:maybe", 1, 23},
+		{"rule 1:Project:*:read", 1, 22},
+		{"rule 1:Project:*:read:allow_and_forward_to_next_rule", 1, 23},
+		{`rule 1:Project:"42:read:allow`, 1, 16},
+		{`rule 1:Project:*:read:allow name="a" name="b"`, 1, 38},
+		{`rule 1:Project:*:read:allow colour="red"`, 1, 29},
+		{`rule 1:Project:*:read:allow name=bare`, 1, 34},
+		{`rule 1:Project:*:read:allow name="a"description="b"`, 1, 37},
+		{"profile 2 rules=1,x", 1, 19},
+		{"user 3\nuser 3", 2, 1},
+	}
+	for _, tt := range tests {
+		_, err := ParseString(tt.src)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: want a SyntaxError, got %v", tt.src, err)
+			continue
+		}
+		if syntaxErr.Line != tt.line || syntaxErr.Col != tt.col {
+			t.Errorf("%q: got %v, want line %d, col %d", tt.src, err, tt.line, tt.col)
+		}
+	}
+}
+
+func TestDSL_MatchesGetRuleAsDSL(t *testing.T) {
+	for _, rs := range []RuleSpec{
+		{ID: 1, Name: "plain", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "42", Verb: core.VerbRead | core.VerbUpdate, Action: core.ActionAllow},
+		{ID: 2, Name: "quoted", Description: "d", TargetResourceType: core.ResourceTypeURL, TargetResourceID: "/a b", Verb: core.VerbAll, Action: core.ActionAllowAndForwardToNextRule, ForwardRuleID: 1},
+	} {
+		rule, err := rs.Build()
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		if got, want := rule.GetRuleAsDSL(), formatRule(rs); got != want {
+			t.Errorf("GetRuleAsDSL = %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package policy
+
+import (
+	"sort"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// Entitlement is one rule applying to a user, and where it comes from.
+// Chain lists the rules from the one attached to the profile to RuleID:
+// just RuleID for a rule matched directly, more for a deny reached through
+// a forward chain, whose Permission is then narrowed to the requests that
+// reach it.
+type Entitlement struct {
+	Permission Permission `json:"permission"`
+	ProfileID  uint64     `json:"profile_id"`
+	RuleID     uint64     `json:"rule_id"`
+	Chain      []uint64   `json:"chain,omitempty"`
+}
+
+// Access is what the rules of a document let one user do: every allow and
+// every deny that applies to them, through any of their profiles. Delegated
+// grants, time-bound assignments and request attributes are not part of a
+// Document and so not counted.
+type Access struct {
+	UserID uint64        `json:"user_id"`
+	Allows []Entitlement `json:"allows"`
+	Denies []Entitlement `json:"denies"`
+}
+
+// UserAccess works out the access of one user of doc. Rules that match
+// nothing are left out. A forward chain that loops counts as a deny of
+// everything reaching the loop, as the Gatekeeper denies those with an
+// error.
+func UserAccess(doc *Document, us UserSpec) Access {
+	profiles, rules := doc.index()
+	return userAccess(us, profiles, rules)
+}
+
+// AllAccess works out the access of every user of doc, in document order
+func AllAccess(doc *Document) []Access {
+	profiles, rules := doc.index()
+	list := make([]Access, 0, len(doc.Users))
+	for _, us := range doc.Users {
+		list = append(list, userAccess(us, profiles, rules))
+	}
+	return list
+}
+
+// index maps the profiles and rules of doc by ID
+func (doc *Document) index() (map[uint64]ProfileSpec, map[uint64]RuleSpec) {
+	profiles := make(map[uint64]ProfileSpec, len(doc.Profiles))
+	for _, ps := range doc.Profiles {
+		profiles[ps.ID] = ps
+	}
+	rules := make(map[uint64]RuleSpec, len(doc.Rules))
+	for _, rs := range doc.Rules {
+		rules[rs.ID] = rs
+	}
+	return profiles, rules
+}
+
+func userAccess(us UserSpec, profiles map[uint64]ProfileSpec, rules map[uint64]RuleSpec) Access {
+	acc := Access{UserID: us.ID, Allows: []Entitlement{}, Denies: []Entitlement{}}
+	for _, pid := range us.ProfileIDs {
+		ps, ok := profiles[pid]
+		if !ok {
+			continue
+		}
+		for _, rid := range ps.RuleIDs {
+			rs, ok := rules[rid]
+			if !ok || PermissionOf(rs).matchesNothing() {
+				continue
+			}
+			ent := Entitlement{Permission: PermissionOf(rs), ProfileID: pid, RuleID: rid, Chain: []uint64{rid}}
+			switch rs.Action {
+			case core.ActionDeny:
+				acc.Denies = append(acc.Denies, ent)
+			case core.ActionAllow:
+				acc.Allows = append(acc.Allows, ent)
+			case core.ActionAllowAndForwardToNextRule:
+				acc.Allows = append(acc.Allows, ent)
+				if deny, ok := chainDeny(ent, rs.ForwardRuleID, rules); ok {
+					acc.Denies = append(acc.Denies, deny)
+				}
+			}
+		}
+	}
+	return acc
+}
+
+// chainDeny follows a forward chain from ent the way the Gatekeeper does,
+// narrowing to the requests that match every rule on the way, and returns
+// the deny it ends in, if any
+func chainDeny(ent Entitlement, next uint64, rules map[uint64]RuleSpec) (Entitlement, bool) {
+	region, chain := ent.Permission, append([]uint64(nil), ent.Chain...)
+	visited := map[uint64]bool{ent.RuleID: true}
+	for next != 0 {
+		if visited[next] {
+			return Entitlement{Permission: region, ProfileID: ent.ProfileID, RuleID: next, Chain: append(chain, next)}, true
+		}
+		visited[next] = true
+		rs, ok := rules[next]
+		if !ok {
+			return Entitlement{}, false
+		}
+		if region, ok = region.Intersect(PermissionOf(rs)); !ok {
+			return Entitlement{}, false
+		}
+		chain = append(chain, next)
+		switch rs.Action {
+		case core.ActionDeny:
+			return Entitlement{Permission: region, ProfileID: ent.ProfileID, RuleID: next, Chain: chain}, true
+		case core.ActionAllowAndForwardToNextRule:
+			next = rs.ForwardRuleID
+		default:
+			return Entitlement{}, false
+		}
+	}
+	return Entitlement{}, false
+}
+
+// Covering returns the allows and denies of acc that match every request p
+// matches
+func (acc Access) Covering(p Permission) (allows, denies []Entitlement) {
+	for _, e := range acc.Allows {
+		if e.Permission.Covers(p) {
+			allows = append(allows, e)
+		}
+	}
+	for _, e := range acc.Denies {
+		if e.Permission.Covers(p) {
+			denies = append(denies, e)
+		}
+	}
+	return allows, denies
+}
+
+// Allowed reports whether acc allows every request p matches, apart from
+// any a narrower deny takes back
+func (acc Access) Allowed(p Permission) bool {
+	allows, denies := acc.Covering(p)
+	return len(allows) > 0 && len(denies) == 0
+}
+
+// candidates returns, one verb each, the permissions whose Allowed answer
+// can differ between the given accesses: those of every allow, and those
+// where a deny cuts into an allow
+func candidates(accesses ...Access) []Permission {
+	seen := make(map[Permission]bool)
+	var list []Permission
+	add := func(p Permission) {
+		for _, single := range p.Split() {
+			if !seen[single] {
+				seen[single] = true
+				list = append(list, single)
+			}
+		}
+	}
+	for _, acc := range accesses {
+		for _, a := range acc.Allows {
+			add(a.Permission)
+		}
+	}
+	for _, acc := range accesses {
+		for _, d := range acc.Denies {
+			for _, other := range accesses {
+				for _, a := range other.Allows {
+					if both, ok := d.Permission.Intersect(a.Permission); ok {
+						add(both)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].less(list[j]) })
+	return list
+}
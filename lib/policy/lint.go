@@ -0,0 +1,312 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// Severity ranks lint findings
+type Severity uint8
+
+const (
+	SeverityInfo    Severity = iota // worth a look, e.g. overlapping rules
+	SeverityWarning                 // almost certainly a mistake, e.g. a dead rule
+	SeverityError                   // breaks evaluation or grants everything
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseSeverity reads "info", "warning" or "error"
+func ParseSeverity(s string) (Severity, error) {
+	for _, sev := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		if strings.EqualFold(s, sev.String()) {
+			return sev, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q, want info, warning or error", s)
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Lint finding codes. They are stable: tooling may filter or suppress
+// findings by code.
+const (
+	CodeShadowedAllow     = "RBAC001" // an allow rule can never decide, a deny always wins
+	CodeDuplicateRule     = "RBAC002" // two rules match and decide the same
+	CodeOverlappingRules  = "RBAC003" // two rules of a profile with the same action match some requests alike
+	CodeForwardMissing    = "RBAC004" // a forward chain points to a rule that does not exist
+	CodeForwardCycle      = "RBAC005" // a forward chain loops; the Gatekeeper denies with an error
+	CodeTargetNone        = "RBAC006" // a rule targets ResourceTypeNone and matches nothing
+	CodeWildcardGrant     = "RBAC007" // an allow of VerbAll on ResourceTypeAll
+	CodeUnreachableTarget = "RBAC008" // a rule has an empty target ID and matches nothing
+)
+
+// Finding is one problem Lint found. RuleID names the rule the finding is
+// about, RelatedRuleID the other rule involved, if any, and ProfileID the
+// profile it was found in, if it is specific to one.
+type Finding struct {
+	Code          string   `json:"code"`
+	Severity      Severity `json:"severity"`
+	RuleID        uint64   `json:"rule_id"`
+	RelatedRuleID uint64   `json:"related_rule_id,omitempty"`
+	ProfileID     uint64   `json:"profile_id,omitempty"`
+	Message       string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	where := fmt.Sprintf("rule %d", f.RuleID)
+	if f.ProfileID != 0 {
+		where += fmt.Sprintf(" in profile %d", f.ProfileID)
+	}
+	return fmt.Sprintf("%s %s %s: %s", f.Severity, f.Code, where, f.Message)
+}
+
+// Lint reports rules of doc that cannot work as written:
+//
+//   - RBAC001: an allow fully covered by a deny, either in the same profile
+//     or in a profile every holder of the allow's profile also holds
+//   - RBAC002: rules with the same target, verbs and action
+//   - RBAC003: rules of one profile with the same action whose targets overlap
+//   - RBAC004, RBAC005: forward chains to missing rules, or that loop
+//   - RBAC006, RBAC008: rules whose target matches no request
+//   - RBAC007: allows of every verb on every resource
+//
+// Findings are sorted by severity, most severe first, then by code and rule.
+// To lint a running controller, lint Export(ctrl).
+func Lint(doc *Document) []Finding {
+	_, rules := doc.index()
+
+	var findings []Finding
+	findings = append(findings, lintTargets(doc.Rules)...)
+	findings = append(findings, lintDuplicates(doc.Rules)...)
+	findings = append(findings, lintForwardChains(doc.Rules, rules)...)
+	findings = append(findings, lintProfiles(doc, rules)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		switch {
+		case a.Severity != b.Severity:
+			return a.Severity > b.Severity
+		case a.Code != b.Code:
+			return a.Code < b.Code
+		case a.RuleID != b.RuleID:
+			return a.RuleID < b.RuleID
+		case a.ProfileID != b.ProfileID:
+			return a.ProfileID < b.ProfileID
+		}
+		return a.RelatedRuleID < b.RelatedRuleID
+	})
+	return findings
+}
+
+// lintTargets finds rules that match nothing and wildcard grants
+func lintTargets(specs []RuleSpec) []Finding {
+	var findings []Finding
+	for _, rs := range specs {
+		p := PermissionOf(rs)
+		switch {
+		case p.ResourceType == core.ResourceTypeNone:
+			findings = append(findings, Finding{Code: CodeTargetNone, Severity: SeverityWarning, RuleID: rs.ID,
+				Message: fmt.Sprintf("rule %q targets %s and never matches a request", rs.Name, core.ResourceTypeNone)})
+		case p.ResourceID == "":
+			findings = append(findings, Finding{Code: CodeUnreachableTarget, Severity: SeverityWarning, RuleID: rs.ID,
+				Message: fmt.Sprintf("rule %q has no target ID and never matches a request; use %q for every ID", rs.Name, core.ResourceIDAll)})
+		case p.IsWildcard() && grants(rs.Action):
+			findings = append(findings, Finding{Code: CodeWildcardGrant, Severity: SeverityError, RuleID: rs.ID,
+				Message: fmt.Sprintf("rule %q allows every verb on every resource", rs.Name)})
+		}
+	}
+	return findings
+}
+
+// lintDuplicates reports each rule that repeats an earlier one, by ID
+func lintDuplicates(specs []RuleSpec) []Finding {
+	type key struct {
+		perm    Permission
+		action  core.Action
+		forward uint64
+	}
+	sorted := append([]RuleSpec(nil), specs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	first := make(map[key]RuleSpec)
+	var findings []Finding
+	for _, rs := range sorted {
+		k := key{PermissionOf(rs), rs.Action, rs.ForwardRuleID}
+		if orig, ok := first[k]; ok {
+			findings = append(findings, Finding{Code: CodeDuplicateRule, Severity: SeverityWarning, RuleID: rs.ID, RelatedRuleID: orig.ID,
+				Message: fmt.Sprintf("rule %q duplicates rule %q (%d)", rs.Name, orig.Name, orig.ID)})
+			continue
+		}
+		first[k] = rs
+	}
+	return findings
+}
+
+// lintForwardChains reports forwards to missing rules, and each loop once,
+// on the loop's lowest rule ID
+func lintForwardChains(specs []RuleSpec, rules map[uint64]RuleSpec) []Finding {
+	var findings []Finding
+	reported := make(map[uint64]bool)
+	for _, rs := range specs {
+		if rs.Action != core.ActionAllowAndForwardToNextRule {
+			continue
+		}
+		if _, ok := rules[rs.ForwardRuleID]; !ok {
+			findings = append(findings, Finding{Code: CodeForwardMissing, Severity: SeverityWarning, RuleID: rs.ID,
+				Message: fmt.Sprintf("rule %q forwards to rule %d, which does not exist; the chain ends there", rs.Name, rs.ForwardRuleID)})
+			continue
+		}
+
+		// Walk the chain; revisiting a rule on the path closes a loop
+		var path []uint64
+		onPath := make(map[uint64]int)
+		for id := rs.ID; ; {
+			if at, ok := onPath[id]; ok {
+				loop := path[at:]
+				lowest := loop[0]
+				for _, l := range loop {
+					if l < lowest {
+						lowest = l
+					}
+				}
+				if !reported[lowest] {
+					reported[lowest] = true
+					findings = append(findings, Finding{Code: CodeForwardCycle, Severity: SeverityError, RuleID: lowest,
+						Message: fmt.Sprintf("forward chain loops through rules %s; requests reaching it are denied with an error", joinRuleIDs(append(loop[:len(loop):len(loop)], loop[0])))})
+				}
+				break
+			}
+			next, ok := rules[id]
+			if !ok || next.Action != core.ActionAllowAndForwardToNextRule {
+				break
+			}
+			onPath[id] = len(path)
+			path = append(path, id)
+			id = next.ForwardRuleID
+		}
+	}
+	return findings
+}
+
+// lintProfiles finds shadowed allows and overlapping rules within profiles
+func lintProfiles(doc *Document, rules map[uint64]RuleSpec) []Finding {
+	holders := make(map[uint64]map[uint64]bool) // profile ID -> user IDs
+	for _, us := range doc.Users {
+		for _, pid := range us.ProfileIDs {
+			if holders[pid] == nil {
+				holders[pid] = make(map[uint64]bool)
+			}
+			holders[pid][us.ID] = true
+		}
+	}
+	profileRules := func(ps ProfileSpec) []RuleSpec {
+		var list []RuleSpec
+		for _, id := range ps.RuleIDs {
+			if rs, ok := rules[id]; ok {
+				list = append(list, rs)
+			}
+		}
+		return list
+	}
+
+	var findings []Finding
+	for _, ps := range doc.Profiles {
+		own := profileRules(ps)
+		for _, allow := range own {
+			if !grants(allow.Action) {
+				continue
+			}
+			if deny, ok := coveringDeny(allow, own); ok {
+				findings = append(findings, Finding{Code: CodeShadowedAllow, Severity: SeverityWarning, RuleID: allow.ID, RelatedRuleID: deny.ID, ProfileID: ps.ID,
+					Message: fmt.Sprintf("allow %q is shadowed by deny %q in the same profile", allow.Name, deny.Name)})
+				continue
+			}
+			// A deny in another profile shadows the allow for users holding
+			// both; if that is every holder, the allow is dead
+			for _, other := range doc.Profiles {
+				if other.ID == ps.ID || !alsoHold(holders[ps.ID], holders[other.ID]) {
+					continue
+				}
+				if deny, ok := coveringDeny(allow, profileRules(other)); ok {
+					findings = append(findings, Finding{Code: CodeShadowedAllow, Severity: SeverityWarning, RuleID: allow.ID, RelatedRuleID: deny.ID, ProfileID: ps.ID,
+						Message: fmt.Sprintf("allow %q is shadowed by deny %q in profile %q (%d), which every holder of this profile also holds", allow.Name, deny.Name, other.Name, other.ID)})
+					break
+				}
+			}
+		}
+
+		for i, a := range own {
+			for _, b := range own[i+1:] {
+				if a.ID == b.ID || a.Action != b.Action || !PermissionOf(a).Overlaps(PermissionOf(b)) {
+					continue
+				}
+				if PermissionOf(a) == PermissionOf(b) && a.ForwardRuleID == b.ForwardRuleID {
+					continue // reported as a duplicate
+				}
+				first, second := a, b
+				if second.ID < first.ID {
+					first, second = second, first
+				}
+				how := "overlaps"
+				switch {
+				case PermissionOf(first).Covers(PermissionOf(second)):
+					how = "covers"
+				case PermissionOf(second).Covers(PermissionOf(first)):
+					how = "is covered by"
+				}
+				findings = append(findings, Finding{Code: CodeOverlappingRules, Severity: SeverityInfo, RuleID: first.ID, RelatedRuleID: second.ID, ProfileID: ps.ID,
+					Message: fmt.Sprintf("%s %q (%s) %s %q (%s)", first.Action, first.Name, PermissionOf(first), how, second.Name, PermissionOf(second))})
+			}
+		}
+	}
+	return findings
+}
+
+// coveringDeny returns the first deny of candidates matching every request
+// allow matches
+func coveringDeny(allow RuleSpec, candidates []RuleSpec) (RuleSpec, bool) {
+	for _, rs := range candidates {
+		if rs.Action == core.ActionDeny && PermissionOf(rs).Covers(PermissionOf(allow)) {
+			return rs, true
+		}
+	}
+	return RuleSpec{}, false
+}
+
+// alsoHold reports whether there are users, and every one of them is among
+// others
+func alsoHold(users, others map[uint64]bool) bool {
+	if len(users) == 0 {
+		return false
+	}
+	for id := range users {
+		if !others[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func joinRuleIDs(ids []uint64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, " -> ")
+}
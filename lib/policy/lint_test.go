@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+func TestLint(t *testing.T) {
+	doc := &Document{
+		Rules: []RuleSpec{
+			{ID: 10, Name: "read-projects", TargetResourceType: core.ResourceTypeProject, TargetResourceID: core.ResourceIDAll, Verb: core.VerbRead | core.VerbList, Action: core.ActionAllow},
+			{ID: 11, Name: "read-project-7", TargetResourceType: core.ResourceTypeProject, TargetResourceID: "7", Verb: core.VerbRead, Action: core.ActionAllow},
+			{ID: 12, Name: "no-projects", TargetResourceType: core.ResourceTypeProject, TargetResourceID: core.ResourceIDAll, Verb: core.VerbAll, Action: core.ActionDeny},
+			{ID: 13, Name: "read-projects-again", TargetResourceType: core.ResourceTypeProject, TargetResourceID: core.ResourceIDAll, Verb: core.VerbRead | core.VerbList, Action: core.ActionAllow},
+			{ID: 14, Name: "nothing", TargetResourceType: core.ResourceTypeNone, Verb: core.VerbRead, Action: core.ActionAllow},
+			{ID: 15, Name: "root", TargetResourceType: core.ResourceTypeAll, TargetResourceID: core.ResourceIDAll, Verb: core.VerbAll, Action: core.ActionAllow},
+			{ID: 16, Name: "loop-a", TargetResourceType: core.ResourceTypeURL, TargetResourceID: core.ResourceIDAll, Verb: core.VerbRead, Action: core.ActionAllowAndForwardToNextRule, ForwardRuleID: 17},
+			{ID: 17, Name: "loop-b", TargetResourceType: core.ResourceTypeURL, TargetResourceID: core.ResourceIDAll, Verb: core.VerbList, Action: core.ActionAllowAndForwardToNextRule, ForwardRuleID: 16},
+			{ID: 19, Name: "root-and-impersonate", TargetResourceType: core.ResourceTypeAll, TargetResourceID: core.ResourceIDAll, Verb: core.VerbAll | core.VerbImpersonate, Action: core.ActionAllow},
+			{ID: 18, Name: "dangling", TargetResourceType: core.ResourceTypeURL, TargetResourceID: "3", Verb: core.VerbRead, Action: core.ActionAllowAndForwardToNextRule, ForwardRuleID: 99},
+		},
+		Profiles: []ProfileSpec{
+			{ID: 20, Name: "readers", RuleIDs: []uint64{10, 11}},
+			{ID: 21, Name: "locked-out", RuleIDs: []uint64{12}},
+			{ID: 22, Name: "admins", RuleIDs: []uint64{15}},
+		},
+		Users: []UserSpec{
+			{ID: 30, Name: "alice", ProfileIDs: []uint64{20, 21}},
+			{ID: 31, Name: "bob", ProfileIDs: []uint64{21, 22}},
+		},
+	}
+
+	type hit struct {
+		code      string
+		ruleID    uint64
+		profileID uint64
+	}
+	got := make(map[hit]Finding)
+	for _, f := range Lint(doc) {
+		got[hit{f.Code, f.RuleID, f.ProfileID}] = f
+	}
+	for _, want := range []struct {
+		hit
+		severity Severity
+	}{
+		{hit{CodeShadowedAllow, 10, 20}, SeverityWarning},
+		{hit{CodeShadowedAllow, 11, 20}, SeverityWarning},
+		{hit{CodeDuplicateRule, 13, 0}, SeverityWarning},
+		{hit{CodeOverlappingRules, 10, 20}, SeverityInfo},
+		{hit{CodeForwardMissing, 18, 0}, SeverityWarning},
+		{hit{CodeForwardCycle, 16, 0}, SeverityError},
+		{hit{CodeTargetNone, 14, 0}, SeverityWarning},
+		{hit{CodeWildcardGrant, 15, 0}, SeverityError},
+		{hit{CodeWildcardGrant, 19, 0}, SeverityError},
+	} {
+		f, ok := got[want.hit]
+		if !ok {
+			t.Errorf("missing %s on rule %d in profile %d", want.code, want.ruleID, want.profileID)
+			continue
+		}
+		if f.Severity != want.severity {
+			t.Errorf("%s: severity %s, want %s", f, f.Severity, want.severity)
+		}
+		delete(got, want.hit)
+	}
+	for _, f := range got {
+		t.Errorf("unexpected finding: %s", f)
+	}
+
+	if findings := Lint(doc); findings[0].Severity != SeverityError {
+		t.Errorf("Expected errors first, got %s", findings[0])
+	}
+}
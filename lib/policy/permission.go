@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// Permission is what a rule applies to: a resource type, an ID pattern and
+// a set of verbs, matched the way lib.RuleMatches matches requests. The ID
+// pattern is core.ResourceIDAll or a single resource ID.
+type Permission struct {
+	ResourceType core.ResourceType
+	ResourceID   string
+	Verb         core.Verb
+}
+
+// PermissionOf returns what rs applies to
+func PermissionOf(rs RuleSpec) Permission {
+	return Permission{ResourceType: rs.TargetResourceType, ResourceID: rs.TargetResourceID, Verb: rs.Verb}
+}
+
+func (p Permission) String() string {
+	return fmt.Sprintf("%s %s:%s", core.FormatVerb(p.Verb), p.ResourceType, p.ResourceID)
+}
+
+// Covers reports whether every request q matches is also matched by p
+func (p Permission) Covers(q Permission) bool {
+	return (p.ResourceType == core.ResourceTypeAll || p.ResourceType == q.ResourceType) &&
+		(p.ResourceID == core.ResourceIDAll || p.ResourceID == q.ResourceID) &&
+		q.Verb&^p.Verb == 0
+}
+
+// Overlaps reports whether some request is matched by both p and q
+func (p Permission) Overlaps(q Permission) bool {
+	return (p.ResourceType == core.ResourceTypeAll || q.ResourceType == core.ResourceTypeAll || p.ResourceType == q.ResourceType) &&
+		(p.ResourceID == core.ResourceIDAll || q.ResourceID == core.ResourceIDAll || p.ResourceID == q.ResourceID) &&
+		p.Verb&q.Verb != 0
+}
+
+// Intersect returns the requests matched by both p and q, and false when
+// there are none
+func (p Permission) Intersect(q Permission) (Permission, bool) {
+	if !p.Overlaps(q) {
+		return Permission{}, false
+	}
+	both := Permission{ResourceType: p.ResourceType, ResourceID: p.ResourceID, Verb: p.Verb & q.Verb}
+	if both.ResourceType == core.ResourceTypeAll {
+		both.ResourceType = q.ResourceType
+	}
+	if both.ResourceID == core.ResourceIDAll {
+		both.ResourceID = q.ResourceID
+	}
+	return both, true
+}
+
+// Split returns p once per verb, in verb order
+func (p Permission) Split() []Permission {
+	var list []Permission
+	for _, v := range core.Verbs() {
+		if p.Verb&v != 0 {
+			list = append(list, Permission{ResourceType: p.ResourceType, ResourceID: p.ResourceID, Verb: v})
+		}
+	}
+	return list
+}
+
+// matchesNothing reports whether no request can match p, see Lint
+func (p Permission) matchesNothing() bool {
+	return p.ResourceType == core.ResourceTypeNone || p.ResourceID == "" || p.Verb == 0
+}
+
+// less orders permissions by type, ID and verb
+func (p Permission) less(q Permission) bool {
+	switch {
+	case p.ResourceType != q.ResourceType:
+		return p.ResourceType < q.ResourceType
+	case p.ResourceID != q.ResourceID:
+		return p.ResourceID < q.ResourceID
+	}
+	return p.Verb < q.Verb
+}
+
+func (p Permission) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ResourceType string `json:"resource_type"`
+		ResourceID   string `json:"resource_id"`
+		Verb         string `json:"verb"`
+	}{p.ResourceType.String(), p.ResourceID, core.FormatVerb(p.Verb)})
+}
+
+// IsWildcard reports whether p matches every verb on every resource, with
+// or without VerbImpersonate on top
+func (p Permission) IsWildcard() bool {
+	return p.ResourceType == core.ResourceTypeAll && p.ResourceID == core.ResourceIDAll && p.Verb&core.VerbAll == core.VerbAll
+}
+
+// grants reports whether a rule with action allows what it matches
+func grants(action core.Action) bool {
+	return action == core.ActionAllow || action == core.ActionAllowAndForwardToNextRule
+}
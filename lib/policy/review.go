@@ -0,0 +1,182 @@
+package policy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// Review flag codes
+const (
+	FlagWildcard        = "wildcard"         // holds an allow of every verb on every resource
+	FlagAdminEquivalent = "admin_equivalent" // can do, or can grant itself, everything
+)
+
+// Flag marks a user an auditor should look at, and why
+type Flag struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// ReviewEntry is one rule applying to a user. For an allow, DeniedBy lists
+// the denies of the same user that take back part of it, and Shadowed is set
+// when they take back all of it.
+type ReviewEntry struct {
+	Effect      string     `json:"effect"`
+	Permission  Permission `json:"permission"`
+	ProfileID   uint64     `json:"profile_id"`
+	ProfileName string     `json:"profile_name"`
+	RuleID      uint64     `json:"rule_id"`
+	RuleName    string     `json:"rule_name"`
+	Chain       []uint64   `json:"chain,omitempty"`
+	DeniedBy    []uint64   `json:"denied_by,omitempty"`
+	Shadowed    bool       `json:"shadowed,omitempty"`
+}
+
+// UserReview is everything one user can and cannot do
+type UserReview struct {
+	UserID  uint64        `json:"user_id"`
+	Name    string        `json:"name"`
+	Email   string        `json:"email"`
+	Flags   []Flag        `json:"flags"`
+	Entries []ReviewEntry `json:"entries"`
+}
+
+// Review is an access review of every user of a document, for auditors
+type Review struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Users       []UserReview `json:"users"`
+}
+
+// NewReview walks every user of doc, their profiles and those profiles'
+// rules, listing what each user is allowed and denied and through which
+// profile and rule, see AllAccess. Users who hold every verb on every
+// resource, or who may rewrite any profile or rule or impersonate any user
+// and so grant themselves anything, are flagged.
+func NewReview(doc *Document) *Review {
+	profiles, rules := doc.index()
+	review := &Review{GeneratedAt: time.Now(), Users: []UserReview{}}
+	for i, acc := range AllAccess(doc) {
+		us := doc.Users[i]
+		ur := UserReview{UserID: us.ID, Name: us.Name, Email: us.Email, Flags: reviewFlags(acc), Entries: []ReviewEntry{}}
+		entry := func(effect core.Action, e Entitlement) ReviewEntry {
+			re := ReviewEntry{Effect: effect.String(), Permission: e.Permission, ProfileID: e.ProfileID, ProfileName: profiles[e.ProfileID].Name,
+				RuleID: e.RuleID, RuleName: rules[e.RuleID].Name}
+			if len(e.Chain) > 1 {
+				re.Chain = e.Chain
+			}
+			return re
+		}
+		for _, a := range acc.Allows {
+			re := entry(core.ActionAllow, a)
+			for _, d := range acc.Denies {
+				if d.Permission.Overlaps(a.Permission) && !containsID(re.DeniedBy, d.RuleID) {
+					re.DeniedBy = append(re.DeniedBy, d.RuleID)
+				}
+			}
+			_, denies := acc.Covering(a.Permission)
+			re.Shadowed = len(denies) > 0
+			ur.Entries = append(ur.Entries, re)
+		}
+		for _, d := range acc.Denies {
+			ur.Entries = append(ur.Entries, entry(core.ActionDeny, d))
+		}
+		review.Users = append(review.Users, ur)
+	}
+	return review
+}
+
+// reviewFlags flags wildcard and admin-equivalent access
+func reviewFlags(acc Access) []Flag {
+	flags := []Flag{}
+	for _, a := range acc.Allows {
+		if a.Permission.IsWildcard() && acc.Allowed(a.Permission) {
+			flags = append(flags, Flag{Code: FlagWildcard, Reason: fmt.Sprintf("rule %d in profile %d allows every verb on every resource", a.RuleID, a.ProfileID)})
+			break
+		}
+	}
+
+	everything := true
+	for _, t := range core.ResourceTypes() {
+		if !acc.Allowed(Permission{ResourceType: t, ResourceID: core.ResourceIDAll, Verb: core.VerbAll}) {
+			everything = false
+			break
+		}
+	}
+	var reasons []string
+	if everything {
+		reasons = append(reasons, "may do everything on every resource type")
+	}
+	for _, escalation := range []struct {
+		perm   Permission
+		reason string
+	}{
+		{Permission{ResourceType: core.ResourceTypeProfile, ResourceID: core.ResourceIDAll, Verb: core.VerbUpdate}, "may change any profile"},
+		{Permission{ResourceType: core.ResourceTypeRule, ResourceID: core.ResourceIDAll, Verb: core.VerbUpdate}, "may change any rule"},
+		{Permission{ResourceType: core.ResourceTypeUser, ResourceID: core.ResourceIDAll, Verb: core.VerbImpersonate}, "may impersonate any user"},
+	} {
+		if acc.Allowed(escalation.perm) {
+			reasons = append(reasons, escalation.reason)
+		}
+	}
+	if len(reasons) > 0 {
+		flags = append(flags, Flag{Code: FlagAdminEquivalent, Reason: strings.Join(reasons, "; ")})
+	}
+	return flags
+}
+
+// WriteJSON writes the review as indented JSON
+func (r *Review) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// csvHeader names the columns WriteCSV writes
+var csvHeader = []string{
+	"user_id", "user_name", "email", "flags",
+	"effect", "resource_type", "resource_id", "verb",
+	"profile_id", "profile_name", "rule_id", "rule_name", "chain", "denied_by", "shadowed",
+}
+
+// WriteCSV writes the review as CSV, one row per entry. Users without any
+// entry get one row with only their own columns filled. Lists within a cell
+// are separated by ';'.
+func (r *Review) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, ur := range r.Users {
+		codes := make([]string, len(ur.Flags))
+		for i, f := range ur.Flags {
+			codes[i] = f.Code
+		}
+		user := []string{strconv.FormatUint(ur.UserID, 10), ur.Name, ur.Email, strings.Join(codes, ";")}
+		if len(ur.Entries) == 0 {
+			cw.Write(append(user, make([]string, len(csvHeader)-len(user))...))
+			continue
+		}
+		for _, e := range ur.Entries {
+			row := append(append([]string(nil), user...),
+				e.Effect, e.Permission.ResourceType.String(), e.Permission.ResourceID, core.FormatVerb(e.Permission.Verb),
+				strconv.FormatUint(e.ProfileID, 10), e.ProfileName, strconv.FormatUint(e.RuleID, 10), e.RuleName,
+				joinCSVIDs(e.Chain), joinCSVIDs(e.DeniedBy), strconv.FormatBool(e.Shadowed))
+			cw.Write(row)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func joinCSVIDs(ids []uint64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(id, 10)
+	}
+	return strings.Join(parts, ";")
+}
@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+func TestReview(t *testing.T) {
+	doc := sampleDocument()
+	doc.Rules = append(doc.Rules,
+		RuleSpec{ID: 12, Name: "root", TargetResourceType: core.ResourceTypeAll, TargetResourceID: core.ResourceIDAll, Verb: core.VerbAll, Action: core.ActionAllow},
+		RuleSpec{ID: 13, Name: "edit-profiles", TargetResourceType: core.ResourceTypeProfile, TargetResourceID: core.ResourceIDAll, Verb: core.VerbUpdate, Action: core.ActionAllow},
+	)
+	doc.Profiles = append(doc.Profiles,
+		ProfileSpec{ID: 21, Name: "admins", RuleIDs: []uint64{12}},
+		ProfileSpec{ID: 22, Name: "profile-editors", RuleIDs: []uint64{13}},
+	)
+	doc.Users = append(doc.Users,
+		UserSpec{ID: 31, Name: "root", Email: "root@example.com", ProfileIDs: []uint64{21}},
+		UserSpec{ID: 32, Name: "pat", Email: "pat@example.com", ProfileIDs: []uint64{22}},
+		UserSpec{ID: 33, Name: "nobody", Email: "nobody@example.com", ProfileIDs: []uint64{}},
+	)
+
+	review := NewReview(doc)
+	if len(review.Users) != 4 {
+		t.Fatalf("Expected 4 users, got %d", len(review.Users))
+	}
+
+	alice := review.Users[0]
+	wantAlice := []ReviewEntry{
+		{Effect: "allow", Permission: Permission{ResourceType: core.ResourceTypeProject, ResourceID: core.ResourceIDAll, Verb: core.VerbRead | core.VerbList},
+			ProfileID: 20, ProfileName: "readers", RuleID: 10, RuleName: "read-projects"},
+		{Effect: "deny", Permission: Permission{ResourceType: core.ResourceTypeProject, ResourceID: "42", Verb: core.VerbDelete},
+			ProfileID: 20, ProfileName: "readers", RuleID: 11, RuleName: "no-delete"},
+	}
+	if !reflect.DeepEqual(alice.Entries, wantAlice) || len(alice.Flags) != 0 {
+		t.Errorf("alice:\n got %+v %+v\nwant %+v", alice.Entries, alice.Flags, wantAlice)
+	}
+
+	codes := func(ur UserReview) []string {
+		var list []string
+		for _, f := range ur.Flags {
+			list = append(list, f.Code)
+		}
+		return list
+	}
+	if got := codes(review.Users[1]); !reflect.DeepEqual(got, []string{FlagWildcard, FlagAdminEquivalent}) {
+		t.Errorf("root flags: %v", got)
+	}
+	if got := codes(review.Users[2]); !reflect.DeepEqual(got, []string{FlagAdminEquivalent}) {
+		t.Errorf("pat flags: %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := review.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	// Header, alice's two entries, one each for root and pat, and nobody
+	if len(records) != 6 {
+		t.Fatalf("Expected 6 CSV records, got %d: %v", len(records), records)
+	}
+	if got := records[3]; got[3] != "wildcard;admin_equivalent" || got[4] != "allow" || got[11] != "root" {
+		t.Errorf("root row: %v", got)
+	}
+	if got := records[5]; got[1] != "nobody" || got[4] != "" {
+		t.Errorf("nobody row: %v", got)
+	}
+}
@@ -0,0 +1,260 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+// TestCase is one expected decision
+type TestCase struct {
+	Line          int
+	Principal     string // user ID, name or email, see Document.FindUser
+	Verb          core.Verb
+	ResourceType  core.ResourceType
+	ResourceID    uint64
+	Want          lib.Effect
+	WantRuleID    uint64 // 0 accepts any deciding rule
+	WantProfileID uint64 // 0 accepts any deciding profile
+}
+
+func (tc TestCase) String() string {
+	return fmt.Sprintf("%s %s %s %s:%d", tc.Want, tc.Principal, core.FormatVerb(tc.Verb), tc.ResourceType, tc.ResourceID)
+}
+
+// Suite is a list of test cases, usually read from a .rbactest file
+type Suite struct {
+	Cases []TestCase
+}
+
+// ParseSuite reads a test file. Like the policy language it is line
+// oriented, with '#' comments; each case is
+//
+//	allow|deny PRINCIPAL VERB TYPE [ID] [rule=ID] [profile=ID]
+//
+// for example
+//
+//	allow alice read project 7
+//	deny "Bob Smith" delete project 42 rule=11
+//	allow 30 read|list project rule=10 profile=20
+//
+// PRINCIPAL is a user ID, name or email, quoted if it holds spaces. VERB
+// uses core.FormatVerb syntax and TYPE a core.ResourceType name; ID defaults
+// to 0. rule and profile additionally require the decision to name that rule
+// or profile.
+func ParseSuite(r io.Reader) (*Suite, error) {
+	suite := &Suite{}
+	sc := bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		p := &lineParser{src: sc.Text(), line: lineNo}
+		p.skipSpace()
+		if p.done() {
+			continue
+		}
+		tc, err := p.testCase()
+		if err != nil {
+			return nil, err
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return suite, nil
+}
+
+// ReadSuiteFile loads a test file from path
+func ReadSuiteFile(path string) (*Suite, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	suite, err := ParseSuite(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return suite, nil
+}
+
+func (p *lineParser) testCase() (TestCase, error) {
+	tc := TestCase{Line: p.line}
+
+	col := p.col()
+	switch effect := p.word(); effect {
+	case "allow":
+		tc.Want = lib.EffectAllow
+	case "deny":
+		tc.Want = lib.EffectDeny
+	default:
+		return tc, p.errorAt(col, "expected allow or deny, found %q", effect)
+	}
+
+	p.skipSpace()
+	col = p.col()
+	var err error
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		tc.Principal, err = p.quoted()
+	} else {
+		tc.Principal = p.word()
+	}
+	if err != nil {
+		return tc, err
+	}
+	if tc.Principal == "" {
+		return tc, p.errorAt(col, "expected a principal")
+	}
+
+	p.skipSpace()
+	col = p.col()
+	if tc.Verb, err = core.ParseVerb(p.word()); err != nil {
+		return tc, p.errorAt(col, "%v", err)
+	}
+	if tc.Verb == 0 {
+		return tc, p.errorAt(col, "expected a verb")
+	}
+
+	p.skipSpace()
+	col = p.col()
+	if tc.ResourceType, err = core.ParseResourceType(p.word()); err != nil {
+		return tc, p.errorAt(col, "%v", err)
+	}
+	if tc.ResourceType == core.ResourceTypeNone {
+		return tc, p.errorAt(col, "expected a resource type")
+	}
+
+	// An optional bare ID comes before the attributes
+	start := p.pos
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		if tc.ResourceID, err = p.id("resource ID"); err != nil {
+			return tc, err
+		}
+	} else {
+		p.pos = start
+	}
+
+	err = p.attributes(map[string]any{
+		"rule":    &tc.WantRuleID,
+		"profile": &tc.WantProfileID,
+	})
+	return tc, err
+}
+
+// CaseResult is the outcome of one test case
+type CaseResult struct {
+	Case     TestCase
+	Decision lib.Decision
+	Err      error // the case could not be run, e.g. an unknown principal
+	Passed   bool
+
+	doc *Document
+}
+
+// String explains the result, including the decision on failure
+func (r CaseResult) String() string {
+	status := "PASS"
+	if !r.Passed {
+		status = "FAIL"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s line %d: %s", status, r.Case.Line, r.Case)
+	if r.Err != nil {
+		fmt.Fprintf(&sb, "\n    error:   %v", r.Err)
+		return sb.String()
+	}
+	if r.Passed {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "\n    got:     %s", r.Decision.Effect)
+	fmt.Fprintf(&sb, "\n    reason:  %s", r.Decision.Reason)
+	if r.Decision.RuleID != 0 || r.Case.WantRuleID != 0 {
+		fmt.Fprintf(&sb, "\n    rule:    %s", r.doc.ruleLabel(r.Decision.RuleID))
+		if r.Case.WantRuleID != 0 {
+			fmt.Fprintf(&sb, " (want %s)", r.doc.ruleLabel(r.Case.WantRuleID))
+		}
+	}
+	if r.Decision.ProfileID != 0 || r.Case.WantProfileID != 0 {
+		fmt.Fprintf(&sb, "\n    profile: %s", r.doc.profileLabel(r.Decision.ProfileID))
+		if r.Case.WantProfileID != 0 {
+			fmt.Fprintf(&sb, " (want %s)", r.doc.profileLabel(r.Case.WantProfileID))
+		}
+	}
+	return sb.String()
+}
+
+// SuiteResult collects the results of a suite run
+type SuiteResult struct {
+	Results []CaseResult
+	Failed  int
+}
+
+// Passed reports whether every case passed
+func (sr *SuiteResult) Passed() bool {
+	return sr.Failed == 0
+}
+
+// RunSuite loads doc into a fresh controller and runs every case of suite
+// through a Gatekeeper over it. It only returns an error if doc itself does
+// not load; failing cases are reported in the result.
+func RunSuite(doc *Document, suite *Suite) (*SuiteResult, error) {
+	ctrl, err := NewController(doc)
+	if err != nil {
+		return nil, err
+	}
+	gk := lib.NewGatekeeper(lib.WithStore(ctrl))
+
+	result := &SuiteResult{}
+	for _, tc := range suite.Cases {
+		r := CaseResult{Case: tc, doc: doc}
+		if user, err := doc.FindUser(tc.Principal); err != nil {
+			r.Err = err
+		} else {
+			r.Decision = gk.Decide(&lib.RequestContext{
+				PrincipalID:         user.ID,
+				RequestResourceType: tc.ResourceType,
+				RequestResourceID:   tc.ResourceID,
+				RequestVerb:         tc.Verb,
+				ContextDT:           time.Now(),
+			})
+			r.Passed = r.Decision.Effect == tc.Want &&
+				(tc.WantRuleID == 0 || r.Decision.RuleID == tc.WantRuleID) &&
+				(tc.WantProfileID == 0 || r.Decision.ProfileID == tc.WantProfileID)
+		}
+		if !r.Passed {
+			result.Failed++
+		}
+		result.Results = append(result.Results, r)
+	}
+	return result, nil
+}
+
+func (doc *Document) ruleLabel(id uint64) string {
+	for _, r := range doc.Rules {
+		if r.ID == id {
+			return fmt.Sprintf("%s (%d)", r.Name, id)
+		}
+	}
+	if id == 0 {
+		return "none"
+	}
+	return fmt.Sprint(id)
+}
+
+func (doc *Document) profileLabel(id uint64) string {
+	for _, p := range doc.Profiles {
+		if p.ID == id {
+			return fmt.Sprintf("%s (%d)", p.Name, id)
+		}
+	}
+	if id == 0 {
+		return "none"
+	}
+	return fmt.Sprint(id)
+}
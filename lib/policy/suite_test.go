@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/farhansabbir/rbac/core"
+	"github.com/farhansabbir/rbac/lib"
+)
+
+func TestSuite_Run(t *testing.T) {
+	suite, err := ParseSuite(strings.NewReader(`
+# alice reads every project but may not delete 42
+allow alice read project 7
+allow alice@example.com read|list project rule=10 profile=20
+deny 30 delete project 42 rule=11
+allow alice delete project 42       # fails: denied
+allow alice read project 7 rule=11  # fails: wrong rule
+deny nobody read project 7          # fails: unknown principal
+`))
+	if err != nil {
+		t.Fatalf("ParseSuite: %v", err)
+	}
+	if len(suite.Cases) != 6 {
+		t.Fatalf("parsed %d cases, want 6", len(suite.Cases))
+	}
+	want := TestCase{Line: 4, Principal: "alice@example.com", Verb: core.VerbRead | core.VerbList, ResourceType: core.ResourceTypeProject, Want: lib.EffectAllow, WantRuleID: 10, WantProfileID: 20}
+	if suite.Cases[1] != want {
+		t.Errorf("case 2 = %#v, want %#v", suite.Cases[1], want)
+	}
+
+	result, err := RunSuite(sampleDocument(), suite)
+	if err != nil {
+		t.Fatalf("RunSuite: %v", err)
+	}
+	if result.Failed != 3 || result.Passed() {
+		t.Fatalf("failed = %d, want 3", result.Failed)
+	}
+	for i, r := range result.Results {
+		if r.Passed != (i < 3) {
+			t.Errorf("line %d: passed = %v\n%s", r.Case.Line, r.Passed, r)
+		}
+	}
+	if got := result.Results[4].String(); !strings.Contains(got, "read-projects (10) (want no-delete (11))") {
+		t.Errorf("failure explanation does not name the rules:\n%s", got)
+	}
+	if result.Results[5].Err == nil {
+		t.Error("unknown principal should be reported as an error")
+	}
+}
+
+func TestSuite_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src       string
+		line, col int
+	}{
+		{"maybe alice read project", 1, 1},
+		{"allow alice fly project", 1, 13},
+		{"allow alice read planet", 1, 18},
+		{"allow alice read project 7 rule=x", 1, 33},
+		{"allow alice read project 7 owner=1", 1, 28},
+		{`allow "alice read project`, 1, 7},
+	}
+	for _, tt := range tests {
+		_, err := ParseSuite(strings.NewReader(tt.src))
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Line != tt.line || syntaxErr.Col != tt.col {
+			t.Errorf("%q: got %v, want line %d, col %d", tt.src, err, tt.line, tt.col)
+		}
+	}
+}
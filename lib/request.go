@@ -15,6 +15,15 @@ type RequestContext struct {
 	RequestVerb         core.Verb         `json:"request_verb"`
 	ContextDT           time.Time         `json:"context_dt"`
 	Attributes          map[string]any    `json:"attributes"`
+	CorrelationID       string            `json:"correlation_id,omitempty"` // ties the audit record to the caller's request
+	// ImpersonatorID, when set, is the principal acting as PrincipalID. It
+	// needs VerbImpersonate on PrincipalID, and PrincipalID needs the
+	// requested access itself.
+	ImpersonatorID uint64 `json:"impersonator_id,omitempty"`
+	// SessionProfileIDs are the profiles PrincipalID already drew on earlier
+	// in the session or request chain. Profiles conflicting with them may
+	// deny but not allow; see Session.
+	SessionProfileIDs []uint64 `json:"session_profile_ids,omitempty"`
 }
 
 func (ctx *RequestContext) String() string {
@@ -29,7 +38,7 @@ func NewRequestContext(principalID uint64, resType core.ResourceType, resID uint
 	}
 
 	// 2. Verb Validation (Simplified check)
-	isValidVerb := (verb & (core.VerbRead | core.VerbCreate | core.VerbUpdate | core.VerbDelete | core.VerbList | core.VerbExecute)) != 0
+	isValidVerb := (verb & (core.VerbAll | core.VerbImpersonate)) != 0
 	if !isValidVerb {
 		return nil, fmt.Errorf("invalid request verb: %s", verb)
 	}
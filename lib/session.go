@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"context"
+	"sync"
+)
+
+// Session remembers which profiles each principal has drawn on across a
+// chain of related requests, so that dynamic separation-of-duties
+// constraints hold across the chain and not only within one decision. It is
+// safe for concurrent use.
+type Session struct {
+	mux      sync.Mutex
+	profiles map[uint64][]uint64 // principal -> profiles its allows came from
+}
+
+func NewSession() *Session {
+	return &Session{profiles: make(map[uint64][]uint64)}
+}
+
+// Decide is DecideContext without a deadline
+func (s *Session) Decide(g *Gatekeeper, requestcontext *RequestContext) Decision {
+	return s.DecideContext(context.Background(), g, requestcontext)
+}
+
+// DecideContext decides the request as part of the session: it is evaluated
+// with the principal's profiles used so far, and the profile of an allow is
+// added to them. Allows through delegated grants use the grantor's profiles
+// and are not recorded. requestcontext is not modified.
+func (s *Session) DecideContext(ctx context.Context, g *Gatekeeper, requestcontext *RequestContext) Decision {
+	rc := *requestcontext
+	rc.SessionProfileIDs = append(s.ProfileIDs(rc.PrincipalID), rc.SessionProfileIDs...)
+
+	d := g.DecideContext(ctx, &rc)
+	if d.Allowed && d.GrantID == 0 && d.ProfileID != 0 {
+		s.record(rc.PrincipalID, d.ProfileID)
+	}
+	return d
+}
+
+// ProfileIDs returns the profiles principalID has used in the session
+func (s *Session) ProfileIDs(principalID uint64) []uint64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]uint64(nil), s.profiles[principalID]...)
+}
+
+func (s *Session) record(principalID, profileID uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, id := range s.profiles[principalID] {
+		if id == profileID {
+			return
+		}
+	}
+	s.profiles[principalID] = append(s.profiles[principalID], profileID)
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/farhansabbir/rbac/core"
+)
+
+// PolicyStore is the state a Gatekeeper evaluates requests against.
+// controllers.Controller implements it.
+type PolicyStore interface {
+	// View runs fn while the store holds its state steady, so that one
+	// evaluation never observes a partially committed change. Lookups made
+	// from fn must not block on the same lock.
+	View(fn func())
+	GetUserByID(id uint64) (*core.User, error)
+}
+
+// RuleStore is implemented by stores that can look rules up by ID, which
+// the Gatekeeper needs to follow forward chains. Without it a forwarding
+// rule acts as a plain allow.
+type RuleStore interface {
+	GetRuleByID(id uint64) (*core.Rule, error)
+}
+
+// PrincipalStore is implemented by stores that hold principals other than
+// users, such as service accounts. The Gatekeeper then resolves a request's
+// PrincipalID through GetPrincipalByID.
+type PrincipalStore interface {
+	GetPrincipalByID(id uint64) (core.Principal, error)
+}
+
+// DelegationStore is implemented by stores that hold delegated grants. A
+// request no profile allows is then allowed by a valid grant to the
+// principal that matches it, provided the grantor is allowed the same
+// request. GrantsFor is called from View.
+type DelegationStore interface {
+	GrantsFor(granteeID uint64) []*core.Grant
+}
+
+// ContextStore is implemented by stores whose lookups may block, e.g. on a
+// remote service. The Gatekeeper then resolves principals and forward-chain
+// rules through these methods so that they honour the request's deadline
+// and cancellation, falling back to GetPrincipalByID for IDs that are not
+// users.
+type ContextStore interface {
+	GetUserByIDContext(ctx context.Context, id uint64) (*core.User, error)
+	GetRuleByIDContext(ctx context.Context, id uint64) (*core.Rule, error)
+}
+
+// globalStore serves the package-level Users, Profiles and Rules slices
+type globalStore struct{}
+
+func (globalStore) View(fn func()) {
+	fn()
+}
+
+func (globalStore) GetUserByID(id uint64) (*core.User, error) {
+	return GetUserByID(id)
+}
+
+func (globalStore) GetRuleByID(id uint64) (*core.Rule, error) {
+	for _, rule := range Rules {
+		if rule.GetResourceID() == id {
+			return rule, nil
+		}
+	}
+	return nil, fmt.Errorf("Rule with ID %d not found", id)
+}